@@ -2,7 +2,10 @@ package main
 
 import (
 	"bufio"
+	"crypto/ed25519"
+	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -20,14 +23,38 @@ type sendMessage struct {
 }
 
 type receiveMessage struct {
+	ID        string    `json:"id"`
 	TimeStamp time.Time `json:"timeStamp"`
 	Text      string    `json:"text"`
 	Sender    string    `json:"sender"`
 }
 
 type authentication struct {
-	ClientID []byte `json:"clientID"`
 	UserName string `json:"userName"`
+	Device   string `json:"device,omitempty"`
+}
+
+type challenge struct {
+	Nonce  []byte `json:"nonce"`
+	Method string `json:"method"`
+}
+
+type proof struct {
+	Proof     []byte `json:"proof"`
+	PublicKey []byte `json:"publicKey,omitempty"`
+}
+
+type sessionToken struct {
+	ID        string    `json:"id"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type envelope struct {
+	Version int             `json:"v"`
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
 }
 
 type response struct {
@@ -35,21 +62,109 @@ type response struct {
 }
 
 type registration struct {
-	ClientID []byte `json:"clientID"`
-	UserName string `json:"userName"`
-	Name     string `json:"name"`
+	PublicKey []byte `json:"publicKey"`
+	UserName  string `json:"userName"`
+	Name      string `json:"name"`
 }
 
 var (
 	userName string
+	device   string
 	users    []string
 )
 
+func sendFrame(conn *websocket.Conn, frameType string, id string, payload interface{}) error {
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return websocket.JSON.Send(conn, envelope{Version: 1, Type: frameType, ID: id, Payload: data})
+}
+
+func privateKey(clientID string) ed25519.PrivateKey {
+
+	seed := sha256.Sum256([]byte(clientID))
+	return ed25519.NewKeyFromSeed(seed[:])
+}
+
+func authenticate(conn *websocket.Conn, clientID string) response {
+
+	err := sendFrame(conn, "auth", "", authentication{UserName: userName, Device: device})
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	var frame envelope
+	err = websocket.JSON.Receive(conn, &frame)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	var ch challenge
+	err = json.Unmarshal(frame.Payload, &ch)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	message := append(ch.Nonce, userName...)
+	key := privateKey(clientID)
+	answer := proof{Proof: ed25519.Sign(key, message)}
+	if ch.Method == "hmac" {
+		// old account, so prove with the old ClientID and enroll the public key at the same time
+		hash := sha1.Sum([]byte(clientID))
+		mac := hmac.New(sha256.New, hash[:])
+		mac.Write(message)
+		answer = proof{Proof: mac.Sum(nil), PublicKey: key.Public().(ed25519.PublicKey)}
+	}
+
+	err = sendFrame(conn, "proof", "", answer)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	err = websocket.JSON.Receive(conn, &frame)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if frame.Type != "session" {
+		return response{}
+	}
+	var session sessionToken
+	err = json.Unmarshal(frame.Payload, &session)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	fmt.Println("session", session.ID, "expires at", session.ExpiresAt)
+
+	return receiveResponse(conn)
+}
+
+func receiveResponse(conn *websocket.Conn) response {
+
+	var frame envelope
+	err := websocket.JSON.Receive(conn, &frame)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	var res response
+	if frame.Type == "response" {
+		err = json.Unmarshal(frame.Payload, &res)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	return res
+}
+
 //This client is for testing process and should be developed for real use later
 
 func main() {
 
 	op := flag.String("op", "m", "m, r, d, t ...")
+	flag.StringVar(&device, "device", "", "device ID of this client")
 	flag.Parse()
 
 	switch strings.ToLower(*op) {
@@ -83,22 +198,7 @@ func deletion() {
 		log.Fatalln(err)
 	}
 
-	hash := sha1.New()
-	hash.Write([]byte(clientID))
-	hashedClientID := hash.Sum(nil)
-	err = websocket.JSON.Send(conn, authentication{
-		ClientID: hashedClientID,
-		UserName: userName,
-	})
-	if err != nil {
-		log.Fatalln(err)
-	}
-
-	var res response
-	err = websocket.JSON.Receive(conn, &res)
-	if err != nil {
-		log.Fatalln(err)
-	}
+	res := authenticate(conn, clientID)
 
 	fmt.Println(res)
 }
@@ -121,24 +221,18 @@ func registering() {
 		log.Fatalln(err)
 	}
 
-	hash := sha1.New()
-	hash.Write([]byte(clientID))
-	hashedClientID := hash.Sum(nil)
+	publicKey := privateKey(clientID).Public().(ed25519.PublicKey)
 
-	err = websocket.JSON.Send(conn, registration{
-		ClientID: hashedClientID,
-		UserName: userName,
-		Name:     name,
+	err = sendFrame(conn, "register", "", registration{
+		PublicKey: publicKey,
+		UserName:  userName,
+		Name:      name,
 	})
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	var res response
-	err = websocket.JSON.Receive(conn, &res)
-	if err != nil {
-		log.Fatalln(err)
-	}
+	res := receiveResponse(conn)
 
 	fmt.Println(res)
 }
@@ -162,22 +256,7 @@ func messaging() {
 		log.Fatalln(err)
 	}
 
-	hash := sha1.New()
-	hash.Write([]byte(clientID))
-	hashedClientID := hash.Sum(nil)
-	err = websocket.JSON.Send(conn, authentication{
-		ClientID: hashedClientID,
-		UserName: userName,
-	})
-	if err != nil {
-		log.Fatalln(err)
-	}
-
-	var res response
-	err = websocket.JSON.Receive(conn, &res)
-	if err != nil {
-		log.Fatalln(err)
-	}
+	res := authenticate(conn, clientID)
 
 	fmt.Println(res)
 
@@ -206,29 +285,31 @@ func receiving(conn *websocket.Conn, num *int) {
 	fmt.Println("Receiving started . . .")
 
 	for {
-		var data []byte
-		err := websocket.Message.Receive(conn, &data)
+		var frame envelope
+		err := websocket.JSON.Receive(conn, &frame)
 		if err != nil {
 			log.Fatalln(err)
 		}
 
-		var res response
-		err = json.Unmarshal(data, &res)
-		if err != nil {
-			log.Fatalln(err)
-		}
-		if res.Value == "" {
+		switch frame.Type {
+		case "message":
 			var rec receiveMessage
-			err = json.Unmarshal(data, &rec)
+			err = json.Unmarshal(frame.Payload, &rec)
 			if err != nil {
 				log.Fatalln(err)
 			}
 			rec.TimeStamp = rec.TimeStamp.In(time.Local)
 			fmt.Println(rec)
 			*num++
-			continue
+
+			err = sendFrame(conn, "ack", rec.ID, nil)
+			if err != nil {
+				fmt.Println("Error in Send Ack, ", err)
+			}
+
+		case "response", "result", "error":
+			fmt.Println(frame.Type, frame.ID, string(frame.Payload))
 		}
-		fmt.Println(res)
 	}
 }
 
@@ -241,7 +322,7 @@ func sending(conn *websocket.Conn, num *int) {
 	for scanner.Scan() {
 		text := scanner.Text()
 
-		err := websocket.JSON.Send(conn, sendMessage{
+		err := sendFrame(conn, "message", "", sendMessage{
 			TimeStamp: time.Now(),
 			Text:      text,
 			To:        users,
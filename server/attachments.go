@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxMessageAttachments is the maximum number of attachments that a message can have.
+const maxMessageAttachments = 10
+
+// maxMimeTypeLength is the maximum length of the MIME type of an attachment.
+const maxMimeTypeLength = 255
+
+// attachmentsPath is the path of the HTTP endpoint that clients upload attachments to,
+// and they download an attachment from this path followed by its ID.
+const attachmentsPath = "/attachments/"
+
+// receivedMessage makes the clientReceiveMessage of a message that clients get.
+// it gets the message with its attachments loaded.
+func receivedMessage(message messageData) clientReceiveMessage {
+
+	result := clientReceiveMessage{
+		ID:        message.id,
+		TimeStamp: message.timeStamp,
+		Text:      message.text,
+		Sender:    message.sender,
+		Group:     message.group,
+		Channel:   message.channel,
+	}
+	for _, data := range message.attachments {
+		result.Attachments = append(result.Attachments, attachment{
+			ID:       data.id,
+			MimeType: data.mimeType,
+			Size:     data.size,
+			Checksum: data.checksum,
+		})
+	}
+
+	return result
+}
+
+// loadAttachments is a controller pointer method that fills the attachments of the messages that are read from the store.
+// it gets the messages and changes them in place.
+// returns error if something went wrong.
+func (c *controller) loadAttachments(messages []messageData) error {
+
+	IDs := make([]string, len(messages))
+	for i, message := range messages {
+		IDs[i] = message.id
+	}
+
+	attachments, err := c.dbConn.getMessageAttachments(IDs)
+	if err != nil {
+		return err
+	}
+
+	for i := range messages {
+		messages[i].attachments = attachments[messages[i].id]
+	}
+
+	return nil
+}
+
+// canFetch is a controller pointer method that checks whether a user can fetch an attachment or not.
+// the uploader and the members of the conversations that the attachment has been sent to can fetch it.
+// it gets the attachment and the userName.
+// returns error if something went wrong.
+func (c *controller) canFetch(attachment *attachmentData, userName string) (bool, error) {
+
+	if attachment.owner == userName {
+		return true, nil
+	}
+
+	return c.dbConn.checkAttachmentAccess(attachment.id, userName)
+}
+
+// checkAttachments is a controller pointer method that gets the attachments that a user wants to send.
+// users can only send the attachments that they can fetch.
+// it gets the IDs of the attachments and the userName of the sender.
+// it returns the attachments and True if the sender can send all of them and False if not.
+// returns error if something went wrong.
+func (c *controller) checkAttachments(IDs []string, userName string) ([]attachmentData, bool, error) {
+
+	var result []attachmentData
+	for _, ID := range IDs {
+		attachment, err := c.dbConn.getAttachment(ID)
+		if err != nil {
+			return nil, false, err
+		}
+		if attachment == nil {
+			return nil, false, nil
+		}
+
+		ok, err := c.canFetch(attachment, userName)
+		if err != nil {
+			return nil, false, err
+		}
+		if !ok {
+			return nil, false, nil
+		}
+		result = append(result, *attachment)
+	}
+
+	return result, true, nil
+}
+
+// httpRecover is a controller pointer method that recovers the panics of the HTTP handlers.
+// it logs the errScope and answers with an internal server error.
+// it gets the response writer of the request.
+func (c *controller) httpRecover(w http.ResponseWriter) {
+
+	if r := recover(); r != nil {
+		logError(r.(errScope).scope, r.(errScope).err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// httpAuthenticate is a controller pointer method that authenticates an HTTP request with a session token.
+// clients should send their userName and session token as the user and password of the basic authentication.
+// it gets the request.
+// it returns the userName and True if the session is valid and False if not.
+// it panics with an errScope if something went wrong.
+func (c *controller) httpAuthenticate(r *http.Request) (string, bool) {
+
+	userName, token, ok := r.BasicAuth()
+	if !ok || userName == "" || token == "" {
+		return "", false
+	}
+
+	if _, ok := c.checkSessionToken(authentication{UserName: userName, Token: token}); !ok {
+		return "", false
+	}
+
+	return userName, true
+}
+
+// attachmentsHandler is a controller pointer method that handles the HTTP requests of the attachments endpoint.
+// a POST to the attachmentsPath uploads an attachment and a GET to the attachmentsPath followed by an ID downloads it.
+// it gets the response writer and the request.
+func (c *controller) attachmentsHandler(w http.ResponseWriter, r *http.Request) {
+
+	defer c.httpRecover(w)
+
+	userName, ok := c.httpAuthenticate(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="redFok"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	ID := strings.TrimPrefix(r.URL.Path, attachmentsPath)
+	switch {
+	case r.Method == http.MethodPost && ID == "":
+		c.uploadHandler(w, r, userName)
+
+	case r.Method == http.MethodGet && ID != "":
+		c.downloadHandler(w, ID, userName)
+
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// countingHasher is the writer that uploadHandler uses to hash and count the bytes of an attachment while it's stored.
+// hash is the SHA-256 of the written bytes.
+// size is the number of the written bytes.
+type countingHasher struct {
+	hash hash.Hash
+	size int64
+}
+
+// Write adds the bytes to the hash and the size.
+func (h *countingHasher) Write(p []byte) (int, error) {
+
+	h.size += int64(len(p))
+	return h.hash.Write(p)
+}
+
+// uploadHandler is a controller pointer method that stores the body of the request as a new attachment.
+// the MIME type is the Content-Type of the request and it's detected from the content if it's not sent.
+// it answers with the attachment json struct and the uploader can attach its ID to the messages.
+// it gets the response writer, the request and the userName of the uploader.
+// it panics with an errScope if something went wrong.
+func (c *controller) uploadHandler(w http.ResponseWriter, r *http.Request, userName string) {
+
+	maxSize := c.conf.maxAttachmentSize
+	if r.ContentLength > maxSize {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	body := bufio.NewReader(io.LimitReader(r.Body, maxSize+1))
+	mimeType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || len(mimeType) > maxMimeTypeLength {
+		head, _ := body.Peek(512)
+		mimeType, _, _ = mime.ParseMediaType(http.DetectContentType(head))
+	}
+
+	ID := newID()
+	hasher := &countingHasher{hash: sha256.New()}
+	err = c.blobs.put(ID, io.TeeReader(body, hasher))
+	if err != nil {
+		_ = c.blobs.delete(ID)
+		panic(errScope{scope: "uploadHandler-put", err: err})
+	}
+
+	if hasher.size == 0 || hasher.size > maxSize {
+		err = c.blobs.delete(ID)
+		if err != nil {
+			panic(errScope{scope: "uploadHandler-delete", err: err})
+		}
+
+		status := http.StatusBadRequest
+		if hasher.size > maxSize {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	data := attachmentData{
+		id:        ID,
+		owner:     userName,
+		mimeType:  mimeType,
+		size:      hasher.size,
+		checksum:  hex.EncodeToString(hasher.hash.Sum(nil)),
+		createdAt: time.Now().UTC(),
+	}
+	err = c.dbConn.insertAttachment(data)
+	if err != nil {
+		_ = c.blobs.delete(ID)
+		panic(errScope{scope: "uploadHandler-insertAttachment", err: err})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(attachment{
+		ID:       data.id,
+		MimeType: data.mimeType,
+		Size:     data.size,
+		Checksum: data.checksum,
+	})
+}
+
+// downloadHandler is a controller pointer method that sends the content of an attachment.
+// the attachments that the user can't fetch are answered the same as the ones that are not existing.
+// it gets the response writer, the ID of the attachment and the userName of the client.
+// it panics with an errScope if something went wrong.
+func (c *controller) downloadHandler(w http.ResponseWriter, ID string, userName string) {
+
+	if !validateID(ID) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	data, err := c.dbConn.getAttachment(ID)
+	if err != nil {
+		panic(errScope{scope: "downloadHandler-getAttachment", err: err})
+	}
+	if data == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	ok, err := c.canFetch(data, userName)
+	if err != nil {
+		panic(errScope{scope: "downloadHandler-canFetch", err: err})
+	}
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	blob, err := c.blobs.get(ID)
+	if err != nil {
+		panic(errScope{scope: "downloadHandler-get", err: err})
+	}
+	defer func() { _ = blob.Close() }()
+
+	w.Header().Set("Content-Type", data.mimeType)
+	w.Header().Set("Content-Length", strconv.FormatInt(data.size, 10))
+	w.Header().Set("Content-Disposition", "attachment")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("ETag", `"`+data.checksum+`"`)
+	_, _ = io.Copy(w, blob)
+}
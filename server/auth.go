@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"golang.org/x/net/websocket"
+	"strings"
+)
+
+// nonceSize is the size of the random nonce that server sends in challenge frames.
+const nonceSize = 32
+
+// validateAuthentication validates an authentication in terms of data appearance.
+// it gets an authentication struct.
+// it returns True if all checks wend alright and False if not.
+func validateAuthentication(auth authentication) bool {
+
+	if auth.UserName == "" || len(auth.UserName) > 50 || len(auth.Token) > 100 || len(auth.Device) > 50 {
+		return false
+	}
+
+	if strings.Contains(auth.UserName, " ") || strings.Contains(auth.Device, " ") {
+		return false
+	}
+
+	return true
+}
+
+// challengeMessage returns the message that clients should compute their proof on.
+// it's the nonce followed by the userName, so a proof is useless for any other nonce or user.
+func challengeMessage(nonce []byte, userName string) []byte {
+
+	return append(append([]byte(nil), nonce...), userName...)
+}
+
+// hmacProof computes the proof that an old account holding the ClientID should answer to the nonce.
+// proof is the HMAC-SHA256 of the challengeMessage keyed with the ClientID, so the ClientID never goes on the wire.
+func hmacProof(clientID []byte, nonce []byte, userName string) []byte {
+
+	mac := hmac.New(sha256.New, clientID)
+	mac.Write(challengeMessage(nonce, userName))
+	return mac.Sum(nil)
+}
+
+// checkAuthentication is a controller method that checks whether a client is allowed to communicate with the server or not.
+// client sends its userName in an auth frame, server answers with a challenge frame containing a random nonce,
+// and client has to answer with a proof frame containing its signature of the challengeMessage.
+// after a successful challenge server issues a new session and sends its token in a session frame.
+// clients that send a session token in the auth frame skip the challenge as long as the session is valid.
+// the connection is kept with its session, so it's closed when the session is revoked.
+// it gets a websocket connection as the incoming client.
+// it returns the client's userName and device ID if authentication went alright and returns empty strings if not.
+func (c *controller) checkAuthentication(conn *websocket.Conn) (string, string) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			logError(r.(errScope).scope, r.(errScope).err)
+		}
+	}()
+
+	frame, err := frameReceiver(conn)
+	if err != nil {
+		panic(errScope{scope: "checkAuthentication-frameReceiver", err: err})
+	}
+	if frame.Type != authFrame {
+		return "", ""
+	}
+	var auth authentication
+	err = json.Unmarshal(frame.Payload, &auth)
+	if err != nil {
+		panic(errScope{scope: "checkAuthentication-Unmarshal", err: err})
+	}
+
+	if !validateAuthentication(auth) {
+		return "", ""
+	}
+
+	var isValid bool
+	var sessionID string
+	if auth.Token != "" {
+		sessionID, isValid = c.checkSessionToken(auth)
+	} else {
+		creds, err := c.dbConn.getCredentials(auth.UserName)
+		if err != nil {
+			panic(errScope{scope: "checkAuthentication-getCredentials", err: err})
+		}
+
+		isValid = c.checkChallenge(conn, creds, auth.UserName)
+		if isValid {
+			sessionID = c.issueSession(conn, auth.UserName)
+		}
+	}
+
+	if !isValid {
+		err = responseSender(conn, invalidAuth)
+		if err != nil {
+			panic(errScope{scope: "checkAuthentication-responseSender", err: err})
+		}
+
+		return "", ""
+	}
+
+	if auth.Device == "" {
+		auth.Device = defaultDevice
+	}
+
+	// the connection is closed if its session gets revoked.
+	c.trackSession(sessionID, auth.UserName, conn)
+
+	return auth.UserName, auth.Device
+}
+
+// checkChallenge is a controller method that sends a challenge to the client and checks its proof.
+// the accounts with a public key have to sign the challengeMessage with their Ed25519 private key.
+// the old accounts have to answer with the hmacProof and enroll a public key with the same proof frame,
+// so the hmacProof is only accepted once and the ClientID is removed after that.
+// the challenge is sent even if the user is not existing, so clients can't find out the existing userNames this way.
+// it gets a websocket connection as the incoming client, the credentials of the user which is nil if the user
+// is not existing, and the userName.
+// it returns True if the client has proven that it holds the secret and False if not.
+// it panics with an errScope if sending or receiving went wrong.
+func (c *controller) checkChallenge(conn *websocket.Conn, creds *credentials, userName string) bool {
+
+	method := ed25519Method
+	if creds != nil && creds.publicKey == nil {
+		method = hmacMethod
+	}
+
+	nonce := make([]byte, nonceSize)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		panic(errScope{scope: "checkChallenge-Read", err: err})
+	}
+
+	err = frameSender(conn, challengeFrame, "", challenge{Nonce: nonce, Method: method})
+	if err != nil {
+		panic(errScope{scope: "checkChallenge-frameSender", err: err})
+	}
+
+	frame, err := frameReceiver(conn)
+	if err != nil {
+		panic(errScope{scope: "checkChallenge-frameReceiver", err: err})
+	}
+	if frame.Type != proofFrame {
+		return false
+	}
+	var answer proof
+	err = json.Unmarshal(frame.Payload, &answer)
+	if err != nil {
+		panic(errScope{scope: "checkChallenge-Unmarshal", err: err})
+	}
+
+	if creds == nil {
+		return false
+	}
+
+	if method == ed25519Method {
+		return ed25519.Verify(creds.publicKey, challengeMessage(nonce, userName), answer.Proof)
+	}
+
+	if !hmac.Equal(hmacProof(creds.clientID, nonce, userName), answer.Proof) {
+		return false
+	}
+
+	if len(answer.PublicKey) != ed25519.PublicKeySize {
+		return false
+	}
+
+	err = c.dbConn.setPublicKey(userName, answer.PublicKey)
+	if err == errDuplicate {
+		return false
+	}
+	if err != nil {
+		panic(errScope{scope: "checkChallenge-setPublicKey", err: err})
+	}
+
+	return true
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha1"
+	"encoding/json"
+	"golang.org/x/net/websocket"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// challengeClient answers the challenge of checkChallenge with the proof that answer makes out of the challenge.
+// it runs checkChallenge of the controller for the given credentials and userName on a test server.
+// it returns the result of checkChallenge.
+func challengeClient(t *testing.T, c *controller, creds *credentials, userName string, answer func(challenge) proof) bool {
+
+	t.Helper()
+
+	result := make(chan bool, 1)
+	server := httptest.NewServer(websocket.Handler(func(conn *websocket.Conn) {
+		defer func() { _ = conn.Close() }()
+		result <- c.checkChallenge(conn, creds, userName)
+	}))
+	defer server.Close()
+
+	conn, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http"), "", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	frame, err := frameReceiver(conn)
+	if err != nil || frame.Type != challengeFrame {
+		t.Fatalf("challenge frame: got %v %v", frame, err)
+	}
+	var received challenge
+	err = json.Unmarshal(frame.Payload, &received)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = frameSender(conn, proofFrame, "", answer(received))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return <-result
+}
+
+func TestCheckChallengeEd25519(t *testing.T) {
+
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := initNewController(newMemoryStore(), nil, config{})
+	creds := &credentials{publicKey: publicKey}
+
+	sign := func(userName string) func(challenge) proof {
+		return func(received challenge) proof {
+			if received.Method != ed25519Method || len(received.Nonce) != nonceSize {
+				t.Errorf("challenge: got method %q with %d bytes nonce", received.Method, len(received.Nonce))
+			}
+			return proof{Proof: ed25519.Sign(privateKey, challengeMessage(received.Nonce, userName))}
+		}
+	}
+
+	if !challengeClient(t, c, creds, "alice", sign("alice")) {
+		t.Error("a valid signature should be accepted")
+	}
+	if challengeClient(t, c, creds, "alice", sign("bob")) {
+		t.Error("a signature for another userName should not be accepted")
+	}
+	if challengeClient(t, c, nil, "ghost", sign("ghost")) {
+		t.Error("an unknown user should not be accepted")
+	}
+}
+
+func TestCheckChallengeHMACEnrolment(t *testing.T) {
+
+	dbConn, err := createSQLiteConnection(t.TempDir() + "/test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = dbConn.close() }()
+	_, err = dbConn.migrateUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the old accounts only have the SHA-1 of their secret as ClientID.
+	clientID := sha1.Sum([]byte("old secret"))
+	_, err = dbConn.db.Exec("INSERT INTO tbl_users (userName, ClientID, name, ip) VALUES ('old', ?, 'old', '127.0.0.1')",
+		clientID[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := initNewController(dbConn, nil, config{})
+
+	creds, err := dbConn.getCredentials("old")
+	if err != nil || creds == nil || creds.publicKey != nil {
+		t.Fatalf("getCredentials of an old account: got %v %v", creds, err)
+	}
+
+	wrong := func(received challenge) proof {
+		return proof{Proof: hmacProof([]byte("wrong"), received.Nonce, "old")}
+	}
+	if challengeClient(t, c, creds, "old", wrong) {
+		t.Fatal("a proof of another ClientID should not be accepted")
+	}
+
+	// the hmacProof is only accepted to enroll a public key.
+	noKey := func(received challenge) proof {
+		return proof{Proof: hmacProof(clientID[:], received.Nonce, "old")}
+	}
+	if challengeClient(t, c, creds, "old", noKey) {
+		t.Fatal("a valid proof without a public key should not be accepted")
+	}
+
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enrol := func(received challenge) proof {
+		if received.Method != hmacMethod {
+			t.Errorf("challenge method: got %q, want %q", received.Method, hmacMethod)
+		}
+		return proof{Proof: hmacProof(clientID[:], received.Nonce, "old"), PublicKey: publicKey}
+	}
+	if !challengeClient(t, c, creds, "old", enrol) {
+		t.Fatal("a valid proof with a public key should be accepted")
+	}
+
+	// the ClientID is dropped after enrolment, so only the new key is accepted from now on.
+	creds, err = dbConn.getCredentials("old")
+	if err != nil || creds == nil || creds.clientID != nil || !publicKey.Equal(ed25519.PublicKey(creds.publicKey)) {
+		t.Fatalf("getCredentials after enrolment: got %v %v", creds, err)
+	}
+	sign := func(received challenge) proof {
+		return proof{Proof: ed25519.Sign(privateKey, challengeMessage(received.Nonce, "old"))}
+	}
+	if !challengeClient(t, c, creds, "old", sign) {
+		t.Fatal("the enrolled key should be accepted")
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// errBlobNotFound is the error that blob stores return when the blob is not existing.
+var errBlobNotFound = errors.New("blob not found")
+
+// blobStore is the interface of the storages that keep the contents of the attachments.
+// the metadata of the attachments is kept in the store and blobStore only keeps the bytes,
+// so any object storage can be used by implementing it.
+type blobStore interface {
+	// put stores the content that is read from r as the blob with the given ID.
+	// the blob shouldn't be visible until all of it is stored.
+	put(ID string, r io.Reader) error
+
+	// get opens the blob with the given ID for reading and the caller should close it.
+	// returns errBlobNotFound if the blob is not existing.
+	get(ID string) (io.ReadCloser, error)
+
+	// delete deletes the blob with the given ID, deleting a blob that is not existing is not an error.
+	delete(ID string) error
+}
+
+// diskBlobStore is the blobStore that keeps every blob in a file of a local directory.
+// dir is the directory that the blobs are kept in and the files are named by the blob IDs.
+type diskBlobStore struct {
+	dir string
+}
+
+// newDiskBlobStore makes the directory if it's not existing and returns a diskBlobStore as pointer.
+// returns error if the directory can't be made.
+func newDiskBlobStore(dir string) (*diskBlobStore, error) {
+
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	return &diskBlobStore{dir: dir}, nil
+}
+
+// put writes the content into a temporary file and renames it to the blob's file when it's all written.
+// returns error if something went wrong.
+func (d *diskBlobStore) put(ID string, r io.Reader) error {
+
+	file, err := ioutil.TempFile(d.dir, ID+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(file, r)
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return err
+	}
+
+	err = file.Close()
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return err
+	}
+
+	err = os.Rename(file.Name(), filepath.Join(d.dir, ID))
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return err
+	}
+
+	return nil
+}
+
+// get opens the file of the blob.
+// returns errBlobNotFound if the file is not existing and error if something else went wrong.
+func (d *diskBlobStore) get(ID string) (io.ReadCloser, error) {
+
+	file, err := os.Open(filepath.Join(d.dir, ID))
+	if os.IsNotExist(err) {
+		return nil, errBlobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// delete removes the file of the blob.
+// returns error if something went wrong.
+func (d *diskBlobStore) delete(ID string) error {
+
+	err := os.Remove(filepath.Join(d.dir, ID))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"golang.org/x/net/websocket"
+	"strings"
+	"sync"
+)
+
+// validateChannelName validates the name of a channel in terms of data appearance.
+// it returns True if the name is valid and False if not.
+func validateChannelName(name string) bool {
+
+	return name != "" && len(name) <= 50 && !strings.Contains(name, " ")
+}
+
+// channelRoleOf is a controller pointer method that finds the role of a user in a channel.
+// it answers the frame with unknownChannel if the channel is not existing or with notMember if the user is not subscribed.
+// it returns the role of the user which is empty if the user is not allowed.
+// it panics with an errScope if something went wrong.
+func (c *controller) channelRoleOf(conn *websocket.Conn, frame envelope, name string, userName string) string {
+
+	role, err := c.dbConn.getChannelRole(name, userName)
+	if err != nil {
+		panic(errScope{scope: "channelRoleOf-getChannelRole", err: err})
+	}
+	if role != "" {
+		return role
+	}
+
+	exists, err := c.dbConn.checkChannel(name)
+	if err != nil {
+		panic(errScope{scope: "channelRoleOf-checkChannel", err: err})
+	}
+
+	if exists {
+		frameResponder(conn, frame, notMember)
+	} else {
+		frameResponder(conn, frame, unknownChannel)
+	}
+	return ""
+}
+
+// createChannelHandler is a controller pointer method that handles the createChannel frames.
+// the creator becomes the owner of the channel.
+// it gets the frame, the websocket connection, the userName and the device ID of the client.
+func (c *controller) createChannelHandler(frame envelope, conn *websocket.Conn, userName string, device string) {
+
+	defer c.handlerRecover(userName, device)
+
+	var request channelRef
+	if !payloadDecoder(frame, &request, conn) {
+		return
+	}
+
+	if !validateChannelName(request.Channel) {
+		frameErrorSender(conn, invalidFrame, frame)
+		return
+	}
+
+	err := c.dbConn.insertChannel(request.Channel, channelMember{userName: userName, role: ownerRole, joinedID: newID()})
+	if err == errDuplicate {
+		frameResponder(conn, frame, nameTaken)
+		return
+	}
+	if err != nil {
+		panic(errScope{scope: "createChannelHandler-insertChannel", err: err})
+	}
+
+	frameResponder(conn, frame, approved)
+}
+
+// subscribeHandler is a controller pointer method that handles the subscribe frames.
+// subscribing again to the same channel is approved without any change.
+// it gets the frame, the websocket connection, the userName and the device ID of the client.
+func (c *controller) subscribeHandler(frame envelope, conn *websocket.Conn, userName string, device string) {
+
+	defer c.handlerRecover(userName, device)
+
+	var request channelRef
+	if !payloadDecoder(frame, &request, conn) {
+		return
+	}
+
+	exists, err := c.dbConn.checkChannel(request.Channel)
+	if err != nil {
+		panic(errScope{scope: "subscribeHandler-checkChannel", err: err})
+	}
+	if !exists {
+		frameResponder(conn, frame, unknownChannel)
+		return
+	}
+
+	err = c.dbConn.insertChannelMember(request.Channel, channelMember{
+		userName: userName,
+		role:     subscriberRole,
+		joinedID: newID(),
+	})
+	if err != nil && err != errDuplicate {
+		panic(errScope{scope: "subscribeHandler-insertChannelMember", err: err})
+	}
+
+	frameResponder(conn, frame, approved)
+}
+
+// unsubscribeHandler is a controller pointer method that handles the unsubscribe frames.
+// the owner can't unsubscribe from its own channel.
+// it gets the frame, the websocket connection, the userName and the device ID of the client.
+func (c *controller) unsubscribeHandler(frame envelope, conn *websocket.Conn, userName string, device string) {
+
+	defer c.handlerRecover(userName, device)
+
+	var request channelRef
+	if !payloadDecoder(frame, &request, conn) {
+		return
+	}
+
+	role := c.channelRoleOf(conn, frame, request.Channel, userName)
+	if role == "" {
+		return
+	}
+	if role == ownerRole {
+		frameResponder(conn, frame, isOwner)
+		return
+	}
+
+	err := c.dbConn.deleteChannelMember(request.Channel, userName)
+	if err != nil {
+		panic(errScope{scope: "unsubscribeHandler-deleteChannelMember", err: err})
+	}
+
+	frameResponder(conn, frame, approved)
+}
+
+// setChannelRoleHandler is a controller pointer method that handles the setChannelRole frames.
+// only the owner can make a subscriber admin or take it back, and the owner's own role can't be changed.
+// it gets the frame, the websocket connection, the userName and the device ID of the client.
+func (c *controller) setChannelRoleHandler(frame envelope, conn *websocket.Conn, userName string, device string) {
+
+	defer c.handlerRecover(userName, device)
+
+	var request setChannelRole
+	if !payloadDecoder(frame, &request, conn) {
+		return
+	}
+
+	if request.Role != adminRole && request.Role != subscriberRole {
+		frameErrorSender(conn, invalidFrame, frame)
+		return
+	}
+
+	role := c.channelRoleOf(conn, frame, request.Channel, userName)
+	if role == "" {
+		return
+	}
+	if role != ownerRole {
+		frameResponder(conn, frame, notAdmin)
+		return
+	}
+
+	targetRole, err := c.dbConn.getChannelRole(request.Channel, request.UserName)
+	if err != nil {
+		panic(errScope{scope: "setChannelRoleHandler-getChannelRole", err: err})
+	}
+	switch targetRole {
+	case "":
+		frameResponder(conn, frame, invalidUserName)
+		return
+	case ownerRole:
+		frameResponder(conn, frame, isOwner)
+		return
+	}
+
+	err = c.dbConn.setChannelRole(request.Channel, request.UserName, request.Role)
+	if err != nil {
+		panic(errScope{scope: "setChannelRoleHandler-setChannelRole", err: err})
+	}
+
+	frameResponder(conn, frame, approved)
+}
+
+// postHandler is a controller pointer method that handles the message frames that are posted to a channel.
+// only the owner and the admins can post, the post is stored once and delivered to the online subscribers,
+// and the offline ones get it from the storage when they connect.
+// it sends a result frame with no recipient results back to the sender.
+// it gets the frame, the message, its attachments, the websocket connection, the userName and the device ID of the client.
+func (c *controller) postHandler(frame envelope, message clientSendMessage, attachments []attachmentData,
+	conn *websocket.Conn, userName string, device string) {
+
+	defer c.handlerRecover(userName, device)
+
+	role := c.channelRoleOf(conn, frame, message.Channel, userName)
+	if role == "" {
+		return
+	}
+	if role != ownerRole && role != adminRole {
+		frameResponder(conn, frame, notAdmin)
+		return
+	}
+
+	data := messageData{
+		id:          newID(),
+		timeStamp:   message.TimeStamp,
+		text:        message.Text,
+		sender:      userName,
+		channel:     message.Channel,
+		attachments: attachments,
+	}
+	err := c.dbConn.insertPost(message.Channel, data)
+	if err != nil {
+		panic(errScope{scope: "postHandler-insertPost", err: err})
+	}
+
+	if len(attachments) > 0 {
+		err = c.dbConn.linkAttachments(data.id, message.Attachments, []attachmentAccess{{channel: message.Channel}})
+		if err != nil {
+			panic(errScope{scope: "postHandler-linkAttachments", err: err})
+		}
+	}
+
+	subscribers, err := c.dbConn.getChannelSubscribers(message.Channel)
+	if err != nil {
+		panic(errScope{scope: "postHandler-getChannelSubscribers", err: err})
+	}
+
+	var wg sync.WaitGroup
+	for _, subscriber := range subscribers {
+		if subscriber == userName || !c.checkIsClientOnline(subscriber) {
+			continue
+		}
+
+		wg.Add(1)
+		go func(subscriber string) {
+			defer wg.Done()
+			c.deliverMessage(subscriber, data)
+		}(subscriber)
+	}
+	wg.Wait()
+
+	err = frameSender(conn, resultFrame, frame.ID, sendResult{MessageID: data.id, Recipients: []recipientResult{}})
+	if err != nil {
+		panic(errScope{scope: "postHandler-frameSender", err: err})
+	}
+}
+
+// checkMissedPosts is a controller pointer method that returns the posts that a device of userName has missed
+// in all of its channels, at most channelBacklog posts of every channel.
+// it gets a userName and a device ID as user info to check.
+// returns nil if there is no missed post or something went wrong.
+func (c *controller) checkMissedPosts(userName string, device string) []messageData {
+
+	if c.conf.channelBacklog == 0 {
+		return nil
+	}
+
+	channels, err := c.dbConn.getUserChannels(userName)
+	if err != nil {
+		logError("checkMissedPosts-getUserChannels", err)
+		c.removeAndCloseOnlineClient(userName, device)
+		return nil
+	}
+
+	var result []messageData
+	for _, channel := range channels {
+		posts, err := c.dbConn.getMissedPosts(channel, userName, device, c.conf.channelBacklog)
+		if err != nil {
+			logError("checkMissedPosts-getMissedPosts", err)
+			c.removeAndCloseOnlineClient(userName, device)
+			return nil
+		}
+		result = append(result, posts...)
+	}
+
+	return result
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// these are the exit statuses of the subcommands that go wrong.
+// failureStatus is for the failures of the subcommand itself.
+// usageStatus is for the arguments that are not valid.
+const (
+	failureStatus = 1
+	usageStatus   = 2
+)
+
+// migrateCommand runs the 'migrate' subcommand of the server.
+// it gets the subcommand arguments which are the server flags followed by one of up, down or status.
+// up applies the pending migrations, down reverts the last applied one and status prints the state of every migration.
+// it returns the exit status of the server which is not zero if something went wrong.
+func migrateCommand(args []string) int {
+
+	conf, rest, err := loadConfig(args)
+	if err != nil {
+		logError("migrateCommand-loadConfig", err)
+		return usageStatus
+	}
+	if len(rest) != 1 {
+		fmt.Println("usage: server migrate [flags] up|down|status")
+		return usageStatus
+	}
+
+	conf.autoMigrate = false
+	db, err := openStore(conf)
+	if err != nil {
+		logError("migrateCommand-openStore", err)
+		return failureStatus
+	}
+	defer func() { _ = db.close() }()
+
+	m, ok := db.(migrator)
+	if !ok {
+		logError("migrateCommand", errNoMigrations)
+		return failureStatus
+	}
+
+	switch rest[0] {
+	case "up":
+		count, err := m.migrateUp()
+		if err != nil {
+			logError("migrateCommand-migrateUp", err)
+			fmt.Println(count, "migrations applied before the failed one")
+			return failureStatus
+		}
+		fmt.Println(count, "migrations applied")
+
+	case "down":
+		reverted, err := m.migrateDown()
+		if err != nil {
+			logError("migrateCommand-migrateDown", err)
+			return failureStatus
+		}
+		fmt.Println("reverted", reverted.version, reverted.name)
+
+	case "status":
+		states, err := m.migrationStatus()
+		if err != nil {
+			logError("migrateCommand-migrationStatus", err)
+			return failureStatus
+		}
+		for _, state := range states {
+			appliedAt := "pending"
+			if !state.appliedAt.IsZero() {
+				appliedAt = state.appliedAt.Format(time.RFC3339)
+			}
+			fmt.Println(state.version, state.name, appliedAt)
+		}
+
+	default:
+		fmt.Println("usage: server migrate [flags] up|down|status")
+		return usageStatus
+	}
+
+	return 0
+}
+
+// rotateKeysCommand runs the 'rotate-keys' subcommand of the server.
+// it gets the subcommand arguments which are the server flags and the master key file should be one of them.
+// it re-encrypts the queued messages and the channel posts that aren't encrypted with the current master key with it,
+// so the old keys can be removed from the file after it.
+// the running servers should have the new key file before it runs, so they can read the rotated messages.
+// it returns the exit status of the server which is not zero if something went wrong.
+func rotateKeysCommand(args []string) int {
+
+	conf, rest, err := loadConfig(args)
+	if err != nil {
+		logError("rotateKeysCommand-loadConfig", err)
+		return usageStatus
+	}
+	if len(rest) != 0 || conf.masterKeyFile == "" {
+		fmt.Println("usage: server rotate-keys -master-key-file file [flags]")
+		return usageStatus
+	}
+
+	db, err := openStore(conf)
+	if err != nil {
+		logError("rotateKeysCommand-openStore", err)
+		return failureStatus
+	}
+	defer func() { _ = db.close() }()
+
+	r, ok := db.(keyRotator)
+	if !ok {
+		logError("rotateKeysCommand", errNoEncryption)
+		return failureStatus
+	}
+
+	count, err := r.rotateMessageKeys()
+	if err != nil {
+		logError("rotateKeysCommand-rotateMessageKeys", err)
+		fmt.Println(count, "messages rotated before the failure")
+		return failureStatus
+	}
+	fmt.Println(count, "messages rotated")
+
+	return 0
+}
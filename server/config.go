@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"time"
+)
+
+// these are the storage backends that server can work with.
+// mysqlBackend keeps everything in a mysql server.
+// sqliteBackend keeps everything in an embedded sqlite database file and it's for single node deployments.
+// memoryBackend keeps everything in memory and it's for tests and demo servers, everything is lost on restart.
+const (
+	mysqlBackend  = "mysql"
+	sqliteBackend = "sqlite"
+	memoryBackend = "memory"
+)
+
+// these are the blob storage backends that server can keep the attachments in.
+// diskBlobBackend keeps every attachment in a file of a local directory.
+const (
+	diskBlobBackend = "disk"
+)
+
+// config is the struct that keeps server options.
+// addr is the address that the server listens on.
+// storage is the name of the storage backend that server uses.
+// dsn is the connection string of mysql or the file path of sqlite and memory doesn't use it.
+// autoMigrate is the option that applies the pending schema migrations at startup.
+// sessionTTL is the duration that the issued session tokens are valid for.
+// channelBacklog is the maximum number of missed posts of every channel that a device gets when it connects.
+// historyRetention is the duration that the conversations history is kept for and zero keeps it forever.
+// editWindow is the duration after sending that the senders can edit or unsend their messages.
+// receiptRetention is the duration after sending that the messages get delivered and read receipts,
+// it can't be shorter than editWindow because the sent messages that both of them need are deleted after it.
+// blobStorage is the name of the blob storage backend that server keeps the attachments in.
+// blobDir is the directory that the disk blob storage keeps the attachments in.
+// maxAttachmentSize is the maximum size of an uploaded attachment in bytes.
+// masterKeyFile is the file of the master keys that the sql stores encrypt the queued messages and the channel posts with,
+// they are kept as plain text if it's empty and the memory storage never encrypts them.
+type config struct {
+	addr              string
+	storage           string
+	dsn               string
+	autoMigrate       bool
+	sessionTTL        time.Duration
+	channelBacklog    int
+	historyRetention  time.Duration
+	editWindow        time.Duration
+	receiptRetention  time.Duration
+	blobStorage       string
+	blobDir           string
+	maxAttachmentSize int64
+	masterKeyFile     string
+}
+
+// loadConfig parses the command line flags into a config.
+// it gets the flag arguments without the program name.
+// it returns the arguments that are left after the flags.
+// returns error if the flags are not valid.
+func loadConfig(args []string) (config, []string, error) {
+
+	var conf config
+	flags := flag.NewFlagSet("server", flag.ContinueOnError)
+	flags.StringVar(&conf.addr, "addr", ":13013", "address that the server listens on")
+	flags.StringVar(&conf.storage, "storage", mysqlBackend, "storage backend: mysql, sqlite or memory")
+	flags.StringVar(&conf.dsn, "dsn", "", "mysql connection string or sqlite file path")
+	flags.BoolVar(&conf.autoMigrate, "auto-migrate", true, "apply pending schema migrations at startup")
+	flags.DurationVar(&conf.sessionTTL, "session-ttl", 30*24*time.Hour, "duration that the session tokens are valid for")
+	flags.IntVar(&conf.channelBacklog, "channel-backlog", 100, "maximum number of missed posts of every channel that is delivered on connect")
+	flags.DurationVar(&conf.historyRetention, "history-retention", 90*24*time.Hour, "duration that the conversations history is kept for, 0 keeps it forever")
+	flags.DurationVar(&conf.editWindow, "edit-window", 48*time.Hour, "duration after sending that the messages can be edited or unsent")
+	flags.DurationVar(&conf.receiptRetention, "receipt-retention", 30*24*time.Hour, "duration after sending that the messages get delivered and read receipts")
+	flags.StringVar(&conf.blobStorage, "blob-storage", diskBlobBackend, "blob storage backend of the attachments: disk")
+	flags.StringVar(&conf.blobDir, "blob-dir", "attachments", "directory that the disk blob storage keeps the attachments in")
+	flags.Int64Var(&conf.maxAttachmentSize, "max-attachment-size", 25<<20, "maximum size of an uploaded attachment in bytes")
+	flags.StringVar(&conf.masterKeyFile, "master-key-file", "", "file of the master keys that encrypt the queued messages and the channel posts, the first one is the current key")
+	err := flags.Parse(args)
+	if err != nil {
+		return config{}, nil, err
+	}
+
+	if conf.sessionTTL <= 0 {
+		return config{}, nil, errors.New("session-ttl should be positive")
+	}
+
+	if conf.channelBacklog < 0 {
+		return config{}, nil, errors.New("channel-backlog should not be negative")
+	}
+
+	if conf.historyRetention < 0 {
+		return config{}, nil, errors.New("history-retention should not be negative")
+	}
+
+	if conf.editWindow <= 0 {
+		return config{}, nil, errors.New("edit-window should be positive")
+	}
+
+	if conf.receiptRetention < conf.editWindow {
+		return config{}, nil, errors.New("receipt-retention should not be shorter than edit-window")
+	}
+
+	if conf.maxAttachmentSize <= 0 {
+		return config{}, nil, errors.New("max-attachment-size should be positive")
+	}
+
+	if conf.dsn == "" {
+		switch conf.storage {
+		case mysqlBackend:
+			conf.dsn = "mahdi:123@/redFokDB?parseTime=true"
+		case sqliteBackend:
+			conf.dsn = "redFok.db"
+		}
+	}
+
+	return conf, flags.Args(), nil
+}
+
+// openStore opens the storage backend that the config asks for.
+// it also applies the pending schema migrations if autoMigrate is set.
+// returns error if the backend is unknown, not responding, the master keys are not valid or the migrations went wrong.
+func openStore(conf config) (store, error) {
+
+	keys, err := loadKeyRing(conf.masterKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var db store
+	switch conf.storage {
+	case mysqlBackend:
+		dbConn, err := createDBConnection(conf.dsn)
+		if err != nil {
+			return nil, err
+		}
+		if dbConn == nil {
+			return nil, errors.New("mysql is not responding")
+		}
+		dbConn.keys = keys
+		db = dbConn
+
+	case sqliteBackend:
+		dbConn, err := createSQLiteConnection(conf.dsn)
+		if err != nil {
+			return nil, err
+		}
+		dbConn.keys = keys
+		db = dbConn
+
+	case memoryBackend:
+		db = newMemoryStore()
+
+	default:
+		return nil, errors.New("unknown storage backend: " + conf.storage)
+	}
+
+	if m, ok := db.(migrator); ok && conf.autoMigrate {
+		_, err = m.migrateUp()
+		if err != nil {
+			_ = db.close()
+			return nil, err
+		}
+	}
+
+	return db, nil
+}
+
+// openBlobStore opens the blob storage backend that the config asks for.
+// returns error if the backend is unknown or can't be prepared.
+func openBlobStore(conf config) (blobStore, error) {
+
+	switch conf.blobStorage {
+	case diskBlobBackend:
+		return newDiskBlobStore(conf.blobDir)
+
+	default:
+		return nil, errors.New("unknown blob storage backend: " + conf.blobStorage)
+	}
+}
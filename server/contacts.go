@@ -0,0 +1,161 @@
+package main
+
+import "golang.org/x/net/websocket"
+
+// maxListEntries is the maximum number of users that the contacts or the blocked list of a user can have.
+const maxListEntries = 1000
+
+// listFrames is the map of the frames that server uses to answer the requests of getting the lists
+// and key of the map is the list.
+var listFrames = map[string]string{
+	contactsList: contactsFrame,
+	blockedList:  blockedFrame,
+}
+
+// acceptsFrom is a controller pointer method that checks whether a recipient accepts messages from a sender or not.
+// the blocked senders are never accepted and the ones that are not in the recipient's contacts are not accepted
+// in the direct conversations if the recipient has turned contactsOnly on.
+// it gets the userName of the recipient, the userName of the sender and whether the conversation is direct or not.
+// returns error if something went wrong.
+func (c *controller) acceptsFrom(recipient string, sender string, direct bool) (bool, error) {
+
+	isBlocked, err := c.dbConn.checkListEntry(recipient, blockedList, sender)
+	if err != nil {
+		return false, err
+	}
+	if isBlocked {
+		return false, nil
+	}
+
+	if !direct {
+		return true, nil
+	}
+
+	userSettings, err := c.dbConn.getSettings(recipient)
+	if err != nil {
+		return false, err
+	}
+	if !userSettings.contactsOnly {
+		return true, nil
+	}
+
+	return c.dbConn.checkListEntry(recipient, contactsList, sender)
+}
+
+// decodeListRequest decodes and validates the payload of the frames that change the lists.
+// it gets the frame, the websocket connection and the userName of the client.
+// it returns the userNames without duplicates and True if the payload is valid and False if not,
+// the client is already told if it's not valid.
+func decodeListRequest(frame envelope, conn *websocket.Conn, userName string) ([]string, bool) {
+
+	var request userNames
+	if !payloadDecoder(frame, &request, conn) {
+		return nil, false
+	}
+
+	entries, ok := validateMemberNames(request.UserNames)
+	if !ok || len(entries) == 0 {
+		frameErrorSender(conn, invalidFrame, frame)
+		return nil, false
+	}
+
+	for _, entry := range entries {
+		if entry == userName {
+			frameErrorSender(conn, invalidFrame, frame)
+			return nil, false
+		}
+	}
+
+	return entries, true
+}
+
+// addToListHandler is a controller pointer method that handles the addContacts and block frames.
+// the users that are already in the list are ignored and all the others should be existing.
+// it gets the frame, the websocket connection, the userName and the device ID of the client and the list to change.
+func (c *controller) addToListHandler(frame envelope, conn *websocket.Conn, userName string, device string, list string) {
+
+	defer c.handlerRecover(userName, device)
+
+	entries, ok := decodeListRequest(frame, conn, userName)
+	if !ok {
+		return
+	}
+
+	existing, err := c.dbConn.getList(userName, list)
+	if err != nil {
+		panic(errScope{scope: "addToListHandler-getList", err: err})
+	}
+
+	isExisting := make(map[string]bool, len(existing))
+	for _, entry := range existing {
+		isExisting[entry] = true
+	}
+
+	var newEntries []string
+	for _, entry := range entries {
+		if !isExisting[entry] {
+			newEntries = append(newEntries, entry)
+		}
+	}
+
+	if len(existing)+len(newEntries) > maxListEntries {
+		frameErrorSender(conn, invalidFrame, frame)
+		return
+	}
+
+	if !c.checkUsersExist(newEntries) {
+		frameResponder(conn, frame, invalidUserName)
+		return
+	}
+
+	err = c.dbConn.insertListEntries(userName, list, newEntries)
+	if err != nil {
+		panic(errScope{scope: "addToListHandler-insertListEntries", err: err})
+	}
+
+	frameResponder(conn, frame, approved)
+}
+
+// removeFromListHandler is a controller pointer method that handles the removeContacts and unblock frames.
+// the users that are not in the list are ignored.
+// it gets the frame, the websocket connection, the userName and the device ID of the client and the list to change.
+func (c *controller) removeFromListHandler(frame envelope, conn *websocket.Conn, userName string, device string,
+	list string) {
+
+	defer c.handlerRecover(userName, device)
+
+	entries, ok := decodeListRequest(frame, conn, userName)
+	if !ok {
+		return
+	}
+
+	err := c.dbConn.deleteListEntries(userName, list, entries)
+	if err != nil {
+		panic(errScope{scope: "removeFromListHandler-deleteListEntries", err: err})
+	}
+
+	frameResponder(conn, frame, approved)
+}
+
+// getListHandler is a controller pointer method that handles the getContacts and getBlocked frames.
+// it answers with the frame of the list from listFrames.
+// it gets the frame, the websocket connection, the userName and the device ID of the client and the list to send.
+func (c *controller) getListHandler(frame envelope, conn *websocket.Conn, userName string, device string, list string) {
+
+	defer c.handlerRecover(userName, device)
+
+	entries, err := c.dbConn.getList(userName, list)
+	if err != nil {
+		panic(errScope{scope: "getListHandler-getList", err: err})
+	}
+
+	result := userNames{UserNames: entries}
+	if result.UserNames == nil {
+		result.UserNames = []string{}
+	}
+
+	err = frameSender(conn, listFrames[list], frame.ID, result)
+	if err != nil {
+		panic(errScope{scope: "getListHandler-frameSender", err: err})
+	}
+}
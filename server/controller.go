@@ -1,144 +1,158 @@
 package main
 
 import (
-	"bytes"
-	"crypto/sha1"
 	"encoding/json"
 	"fmt"
 	"golang.org/x/net/websocket"
-	"strings"
 	"sync"
 )
 
 // onlineClient is the struct that we use to keep online clients map with its mutex together.
 // mapLock is the mutex that we use to lock onlineClients map to prevent race problems.
-// clients is the map we use to store online users's websocket connection and key of the map is client's userName.
+// clients is the map we use to store online users's device connections and key of the map is client's userName.
+// every user has a map of websocket connections and key of that map is the device ID.
 type onlineClient struct {
 	mapLock sync.Mutex
-	clients map[string]*websocket.Conn
+	clients map[string]map[string]*websocket.Conn
 }
 
 // controller is the struct that we use to init our server for keeping online clients and the database connection.
 // onlineClients is the struct that we use to keep online clients map with its mutex together.
-// dbConn is the database connection holder we use to communicate with mysql database.
+// dbConn is the storage backend we use to keep users and their offline messages.
+// blobs is the storage backend we use to keep the contents of the attachments.
+// conf is the server config.
+// sessionConns is the struct that we use to keep the connections that have been authenticated by every session.
 type controller struct {
 	onlineClients onlineClient
-	dbConn        dbHandler
+	dbConn        store
+	blobs         blobStore
+	conf          config
+	sessionConns  sessionConnections
 }
 
 // initNewController inits a controller and returns it as pointer.
-// it gets a database connection which is in type of dbHandler struct.
-func initNewController(db dbHandler) *controller {
+// it gets a storage backend which can be any implementation of the store interface.
+// it gets a blob storage backend which can be any implementation of the blobStore interface.
+// it gets the server config.
+func initNewController(db store, blobs blobStore, conf config) *controller {
 
 	return &controller{
-		onlineClients: onlineClient{clients: make(map[string]*websocket.Conn)},
+		onlineClients: onlineClient{clients: make(map[string]map[string]*websocket.Conn)},
 		dbConn:        db,
+		blobs:         blobs,
+		conf:          conf,
+		sessionConns:  sessionConnections{conns: make(map[string]map[*websocket.Conn]string)},
 	}
 }
 
-// addOnlineClient is a controller method that adds a new user client to the onlineClients map.
-// it gets user's websocket connection and the userName as the key for the map.
-func (c *controller) addOnlineClient(conn *websocket.Conn, userName string) {
+// addOnlineClient is a controller method that adds a new device connection of a user to the onlineClients map.
+// the peers of the user are told that it's online when its first device is added.
+// it gets user's websocket connection, the userName and the device ID as the keys for the map.
+// it returns False if the device is already online and True if the connection has been added.
+func (c *controller) addOnlineClient(conn *websocket.Conn, userName string, device string) bool {
 
 	c.onlineClients.mapLock.Lock()
 	defer c.onlineClients.mapLock.Unlock()
-	c.onlineClients.clients[userName] = conn
+	devices, ok := c.onlineClients.clients[userName]
+	if !ok {
+		devices = make(map[string]*websocket.Conn)
+		c.onlineClients.clients[userName] = devices
+	}
+	if _, ok := devices[device]; ok {
+		return false
+	}
+
+	devices[device] = conn
+	if len(devices) == 1 {
+		go c.presenceChanged(userName)
+	}
 	go playBeep()
 	fmt.Println("online clients = ", len(c.onlineClients.clients))
+	return true
 }
 
-// removeAndCloseOnlineClient is a controller method that removes and also closes the client from onlineClients map.
+// removeAndCloseOnlineClient is a controller method that removes and also closes a device of the client from onlineClients map.
+// the client is removed from the map when its last device is removed and its peers are told that it's offline.
 // it tries to close the websocket connection whether its already close or not.
-func (c *controller) removeAndCloseOnlineClient(userName string) {
+func (c *controller) removeAndCloseOnlineClient(userName string, device string) {
 
-	conn := c.getWebsocketConnection(userName)
 	c.onlineClients.mapLock.Lock()
 	defer c.onlineClients.mapLock.Unlock()
-	delete(c.onlineClients.clients, userName)
-	if conn != nil {
-		_ = conn.Close()
+	devices := c.onlineClients.clients[userName]
+	conn, ok := devices[device]
+	if !ok {
+		return
 	}
+
+	delete(devices, device)
+	if len(devices) == 0 {
+		delete(c.onlineClients.clients, userName)
+		go c.presenceChanged(userName)
+	}
+	_ = conn.Close()
 	fmt.Println("online clients = ", len(c.onlineClients.clients))
 }
 
-// validateAuthentication validates an authentication in terms of data appearance.
-// it gets an authentication struct.
-// it returns True if all checks wend alright and False if not.
-func validateAuthentication(auth authentication) bool {
-
-	emptyHash := sha1.New()
-	emptyHash.Write([]byte(""))
-	emptyClientID := emptyHash.Sum(nil)
-
-	if auth.UserName == "" || auth.ClientID == nil ||
-		bytes.Compare(auth.ClientID, emptyClientID) == 0 {
-		return false
-	}
+// removeAndCloseOnlineUser is a controller method that removes and also closes all the devices of the client
+// from onlineClients map, and its peers are told that it's offline.
+func (c *controller) removeAndCloseOnlineUser(userName string) {
 
-	if len(auth.ClientID) != sha1.Size || len(auth.UserName) > 50 {
-		return false
+	c.onlineClients.mapLock.Lock()
+	defer c.onlineClients.mapLock.Unlock()
+	devices, ok := c.onlineClients.clients[userName]
+	if !ok {
+		return
 	}
 
-	if strings.Contains(auth.UserName, " ") {
-		return false
+	delete(c.onlineClients.clients, userName)
+	go c.presenceChanged(userName)
+	for _, conn := range devices {
+		_ = conn.Close()
 	}
-
-	return true
+	fmt.Println("online clients = ", len(c.onlineClients.clients))
 }
 
-// checkAuthentication is a controller method that checks whether a client is allowed to communicate with the server or not.
-// it gets a websocket connection as the incoming client.
-// it returns the client's userName if authentication went alright and returns an empty string if not.
-func (c *controller) checkAuthentication(conn *websocket.Conn) string {
-
-	defer func() {
-		if r := recover(); r != nil {
-			logError(r.(errScope).scope, r.(errScope).err)
-		}
-	}()
+// frameSender wraps the payload in an envelope and sends it to the client.
+// it gets a websocket connection for sending, the frame type, the ID of the message that frame is about and the payload.
+// returns error if something went wrong.
+func frameSender(conn *websocket.Conn, frameType string, ID string, payload interface{}) error {
 
-	var data []byte
-	err := websocket.Message.Receive(conn, &data)
-	if err != nil {
-		panic(errScope{scope: "checkAuthentication-Receive", err: err})
-	}
-	var auth authentication
-	err = json.Unmarshal(data, &auth)
+	data, err := json.Marshal(payload)
 	if err != nil {
-		panic(errScope{scope: "checkAuthentication-Unmarshal", err: err})
-	}
-
-	if !validateAuthentication(auth) {
-		return ""
+		return err
 	}
 
-	isClientExist, err := c.dbConn.checkClientID(auth.ClientID)
+	err = websocket.JSON.Send(conn, envelope{
+		Version: protocolVersion,
+		Type:    frameType,
+		ID:      ID,
+		Payload: data,
+	})
 	if err != nil {
-		panic(errScope{scope: "checkAuthentication-checkClientID", err: err})
+		return err
 	}
-	if !isClientExist {
-		err := responseSender(conn, invalidAuth)
-		if err != nil {
-			panic(errScope{scope: "checkAuthentication-responseSender", err: err})
-		}
 
-		return ""
-	}
+	return nil
+}
 
-	result, err := c.dbConn.getUserNameByClientID(auth.ClientID)
+// frameReceiver receives a single frame from the client.
+// it gets a websocket connection for receiving.
+// returns error if receiving went wrong or the frame is not a valid envelope.
+func frameReceiver(conn *websocket.Conn) (envelope, error) {
+
+	var data []byte
+	err := websocket.Message.Receive(conn, &data)
 	if err != nil {
-		panic(errScope{scope: "checkAuthentication-getUserNameByClientID", err: err})
+		return envelope{}, err
 	}
-	if result != auth.UserName {
-		err = responseSender(conn, invalidAuth)
-		if err != nil {
-			panic(errScope{scope: "checkAuthentication-responseSender", err: err})
-		}
 
-		return ""
+	var frame envelope
+	err = json.Unmarshal(data, &frame)
+	if err != nil {
+		return envelope{}, err
 	}
 
-	return result
+	return frame, nil
 }
 
 // responseSender sends server responses to the clients with the specific response flag.
@@ -146,12 +160,35 @@ func (c *controller) checkAuthentication(conn *websocket.Conn) string {
 // returns error if something went wrong.
 func responseSender(conn *websocket.Conn, flag string) error {
 
-	err := websocket.JSON.Send(conn, response{Value: flag})
-	if err != nil {
-		return err
+	return frameSender(conn, responseFrame, "", response{Value: flag})
+}
+
+// errorSender sends server errors to the clients with the specific error flag.
+// it gets a websocket connection for sending, the flag as the error flag and the frame that caused the error.
+// returns error if something went wrong.
+func errorSender(conn *websocket.Conn, flag string, frame envelope) error {
+
+	return frameSender(conn, errorFrame, frame.ID, errorPayload{Value: flag, Type: frame.Type})
+}
+
+// handlerRecover recovers the errScope panics of the frame handlers and removes the device that the frame came from.
+// it should be deferred directly by the handlers.
+func (c *controller) handlerRecover(userName string, device string) {
+
+	if r := recover(); r != nil {
+		logError(r.(errScope).scope, r.(errScope).err)
+		c.removeAndCloseOnlineClient(userName, device)
 	}
+}
 
-	return nil
+// frameResponder sends a response frame with the ID of the frame that it answers.
+// it panics with an errScope if sending went wrong.
+func frameResponder(conn *websocket.Conn, frame envelope, flag string) {
+
+	err := frameSender(conn, responseFrame, frame.ID, response{Value: flag})
+	if err != nil {
+		panic(errScope{scope: "frameResponder-frameSender", err: err})
+	}
 }
 
 // onlineClientsLen is a controller method that return the onlineClients map's length.
@@ -167,7 +204,7 @@ func (c *controller) onlineClientsLen() int {
 
 // checkIsClientOnline is a controller method that checks whether a client is in onlineClients map or not.
 // it gets client's userName as the map key.
-// it returns True if the userName is in map and returns False if not.
+// it returns True if any device of the userName is in map and returns False if not.
 func (c *controller) checkIsClientOnline(userName string) bool {
 
 	c.onlineClients.mapLock.Lock()
@@ -176,13 +213,27 @@ func (c *controller) checkIsClientOnline(userName string) bool {
 	return ok
 }
 
-// getWebsocketConnection gets the websocket connection pinter from onlineClients map.
+// checkIsDeviceOnline is a controller method that checks whether a device of a client is in onlineClients map or not.
+// it gets client's userName and the device ID as the map keys.
+// it returns True if the device is in map and returns False if not.
+func (c *controller) checkIsDeviceOnline(userName string, device string) bool {
+
+	c.onlineClients.mapLock.Lock()
+	defer c.onlineClients.mapLock.Unlock()
+	_, ok := c.onlineClients.clients[userName][device]
+	return ok
+}
+
+// getWebsocketConnections gets the websocket connection pointers of all the devices of a client from onlineClients map.
 // it gets the userName as the key of the map.
-// it returns the websocket connection pointer as the map's value.
-func (c *controller) getWebsocketConnection(userName string) *websocket.Conn {
+// it returns a copy of the devices map, so it can be used after the mutex is unlocked.
+func (c *controller) getWebsocketConnections(userName string) map[string]*websocket.Conn {
 
 	c.onlineClients.mapLock.Lock()
 	defer c.onlineClients.mapLock.Unlock()
-	wc, _ := c.onlineClients.clients[userName]
-	return wc
+	result := make(map[string]*websocket.Conn, len(c.onlineClients.clients[userName]))
+	for device, conn := range c.onlineClients.clients[userName] {
+		result[device] = conn
+	}
+	return result
 }
@@ -9,16 +9,16 @@ import (
 // it gets a websocket connection pinter and uses it as the user connection that will be deleted.
 func (c *controller) deleter(conn *websocket.Conn) {
 
-	userName := c.checkAuthentication(conn)
+	userName, _ := c.checkAuthentication(conn)
 	if userName == "" {
 		_ = conn.Close()
 		return
 	}
 
-	err := c.dbConn.deleteUserAndTable(userName)
+	err := c.dbConn.deleteUser(userName)
 	if err != nil {
-		logError("deleter-deleteUserAndTable", err)
-		c.removeAndCloseOnlineClient(userName)
+		logError("deleter-deleteUser", err)
+		c.removeAndCloseOnlineUser(userName)
 		return
 	}
 
@@ -27,7 +27,7 @@ func (c *controller) deleter(conn *websocket.Conn) {
 		logError("deleter-responseSender", err)
 	}
 
-	c.removeAndCloseOnlineClient(userName)
+	c.removeAndCloseOnlineUser(userName)
 
 	go playBeep()
 	fmt.Println(userName, " deleted")
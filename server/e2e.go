@@ -0,0 +1,235 @@
+package main
+
+import "golang.org/x/net/websocket"
+
+// these are the limits of the getKeys frames, every answer can give out a prekey of every device of the user.
+// keysRate is the number of getKeys frames that every connection can send per second.
+// keysBurst is the number of getKeys frames that every connection can send at once.
+const (
+	keysRate  = 0.5
+	keysBurst = 20
+)
+
+// maxPublicKeySize is the maximum size of the identity keys and the prekeys in bytes.
+const maxPublicKeySize = 128
+
+// maxPrekeys is the maximum number of the one-time prekeys that a device can have.
+const maxPrekeys = 100
+
+// lowPrekeys is the number of the prekeys that a device is told to publish more prekeys under it.
+const lowPrekeys = 10
+
+// maxPayloadSize is the maximum size of an end-to-end encrypted payload in bytes.
+const maxPayloadSize = 64 << 10
+
+// validatePayloads validates the end-to-end encrypted payloads of a message.
+// every payload should be for one of the recipients and every recipient should have at least one payload.
+// it gets the message with its recipients validated.
+// it returns True if the payloads are valid and False if not.
+func validatePayloads(message *clientSendMessage) bool {
+
+	if message.To == nil || message.Text != "" || len(message.Attachments) > 0 {
+		return false
+	}
+
+	hasPayload := make(map[string]bool, len(message.To))
+	for _, user := range message.To {
+		hasPayload[user] = false
+	}
+
+	seen := make(map[userDevice]bool)
+	for _, payload := range message.Payloads {
+		if _, ok := hasPayload[payload.UserName]; !ok {
+			return false
+		}
+		if payload.Device == "" || len(payload.Ciphertext) == 0 || len(payload.Ciphertext) > maxPayloadSize {
+			return false
+		}
+
+		key := userDevice{userName: payload.UserName, device: payload.Device}
+		if seen[key] {
+			return false
+		}
+		seen[key] = true
+		hasPayload[payload.UserName] = true
+	}
+
+	for _, ok := range hasPayload {
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// devicePayloads makes the payloads of every recipient of an end-to-end encrypted message.
+// it gets the payloads of the message.
+// it returns a map of the payloads of the devices with the recipient's userName as key.
+func devicePayloads(payloads []devicePayload) map[string]map[string][]byte {
+
+	result := make(map[string]map[string][]byte)
+	for _, payload := range payloads {
+		if result[payload.UserName] == nil {
+			result[payload.UserName] = make(map[string][]byte)
+		}
+		result[payload.UserName][payload.Device] = payload.Ciphertext
+	}
+
+	return result
+}
+
+// deviceMessage makes the clientReceiveMessage of a message for a single device.
+// the end-to-end encrypted messages only go to the devices that they have a payload for.
+// it gets the message and the device ID.
+// it returns the clientReceiveMessage and True if the device should get the message and False if not.
+func deviceMessage(message messageData, device string) (clientReceiveMessage, bool) {
+
+	result := receivedMessage(message)
+	if message.payloads == nil {
+		return result, true
+	}
+
+	payload, ok := message.payloads[device]
+	result.Payload = payload
+
+	return result, ok
+}
+
+// publishKeysHandler is a controller pointer method that handles the publishKeys frames.
+// it keeps the identity key and adds the prekeys of the device to the key directory,
+// and it answers with a prekeyCount frame.
+// it gets the frame, the websocket connection, the userName and the device ID of the client.
+func (c *controller) publishKeysHandler(frame envelope, conn *websocket.Conn, userName string, device string) {
+
+	defer c.handlerRecover(userName, device)
+
+	var request publishKeys
+	if !payloadDecoder(frame, &request, conn) {
+		return
+	}
+
+	if len(request.IdentityKey) == 0 && len(request.Prekeys) == 0 {
+		frameErrorSender(conn, invalidFrame, frame)
+		return
+	}
+	if len(request.IdentityKey) > maxPublicKeySize || len(request.Prekeys) > maxPrekeys {
+		frameErrorSender(conn, invalidFrame, frame)
+		return
+	}
+
+	prekeys := make([]prekeyData, len(request.Prekeys))
+	for i, key := range request.Prekeys {
+		if key.ID < 0 || len(key.Key) == 0 || len(key.Key) > maxPublicKeySize {
+			frameErrorSender(conn, invalidFrame, frame)
+			return
+		}
+		prekeys[i] = prekeyData{id: key.ID, key: key.Key}
+	}
+
+	// the count is checked before changing anything, so a frame that goes over the limit changes nothing.
+	count, err := c.dbConn.countPrekeys(userName, device)
+	if err != nil {
+		panic(errScope{scope: "publishKeysHandler-countPrekeys", err: err})
+	}
+	if count+len(prekeys) > maxPrekeys {
+		frameErrorSender(conn, invalidFrame, frame)
+		return
+	}
+
+	if len(request.IdentityKey) > 0 {
+		err = c.dbConn.setIdentityKey(userName, device, request.IdentityKey)
+		if err != nil {
+			panic(errScope{scope: "publishKeysHandler-setIdentityKey", err: err})
+		}
+	}
+
+	if len(prekeys) > 0 {
+		err = c.dbConn.insertPrekeys(userName, device, prekeys)
+		if err != nil {
+			panic(errScope{scope: "publishKeysHandler-insertPrekeys", err: err})
+		}
+	}
+
+	count, err = c.dbConn.countPrekeys(userName, device)
+	if err != nil {
+		panic(errScope{scope: "publishKeysHandler-countPrekeys", err: err})
+	}
+
+	err = frameSender(conn, prekeyCountFrame, frame.ID, prekeyCount{Count: count})
+	if err != nil {
+		panic(errScope{scope: "publishKeysHandler-frameSender", err: err})
+	}
+}
+
+// getKeysHandler is a controller pointer method that handles the getKeys frames.
+// it answers with a keys frame that has the keys of every device of the user, and every prekey is given out only once.
+// no key is given out if the user doesn't accept messages from the client, and runReceiver limits the getKeys frames
+// of every connection with keysRate, so the prekeys can only be drained slowly by the users that are accepted.
+// the online devices that are getting low on prekeys are told with a prekeyCount frame.
+// it gets the frame, the websocket connection, the userName and the device ID of the client.
+func (c *controller) getKeysHandler(frame envelope, conn *websocket.Conn, userName string, device string) {
+
+	defer c.handlerRecover(userName, device)
+
+	var request keysRequest
+	if !payloadDecoder(frame, &request, conn) {
+		return
+	}
+
+	if request.UserName == "" || request.UserName == userName {
+		frameErrorSender(conn, invalidFrame, frame)
+		return
+	}
+
+	isClientExist, err := c.dbConn.checkClientUserName(request.UserName)
+	if err != nil {
+		panic(errScope{scope: "getKeysHandler-checkClientUserName", err: err})
+	}
+	if !isClientExist {
+		frameResponder(conn, frame, invalidUserName)
+		return
+	}
+
+	result := userKeys{UserName: request.UserName, Devices: []deviceKey{}}
+	accepted, err := c.acceptsFrom(request.UserName, userName, true)
+	if err != nil {
+		panic(errScope{scope: "getKeysHandler-acceptsFrom", err: err})
+	}
+
+	var keys []deviceKeys
+	if accepted {
+		keys, err = c.dbConn.claimKeys(request.UserName)
+		if err != nil {
+			panic(errScope{scope: "getKeysHandler-claimKeys", err: err})
+		}
+	}
+
+	for _, key := range keys {
+		data := deviceKey{Device: key.device, IdentityKey: key.identityKey}
+		if key.prekey != nil {
+			data.Prekey = &prekey{ID: key.prekey.id, Key: key.prekey.key}
+		}
+		result.Devices = append(result.Devices, data)
+	}
+
+	err = frameSender(conn, keysFrame, frame.ID, result)
+	if err != nil {
+		panic(errScope{scope: "getKeysHandler-frameSender", err: err})
+	}
+
+	connections := c.getWebsocketConnections(request.UserName)
+	for _, key := range keys {
+		targetConn, ok := connections[key.device]
+		if !ok || key.prekeysLeft >= lowPrekeys {
+			continue
+		}
+
+		err := frameSender(targetConn, prekeyCountFrame, "", prekeyCount{Count: key.prekeysLeft})
+		if err != nil {
+			c.removeAndCloseOnlineClient(request.UserName, key.device)
+
+			logError("getKeysHandler-frameSender", err)
+		}
+	}
+}
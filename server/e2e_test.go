@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestValidatePayloads(t *testing.T) {
+
+	payload := func(userName string, device string) devicePayload {
+		return devicePayload{UserName: userName, Device: device, Ciphertext: []byte("ciphertext")}
+	}
+
+	tests := []struct {
+		name    string
+		message clientSendMessage
+		want    bool
+	}{
+		{"every recipient has a payload",
+			clientSendMessage{To: []string{"bob", "carol"}, Payloads: []devicePayload{payload("bob", "phone"), payload("bob", "desk"), payload("carol", "phone")}}, true},
+		{"a recipient has no payload",
+			clientSendMessage{To: []string{"bob", "carol"}, Payloads: []devicePayload{payload("bob", "phone")}}, false},
+		{"a payload is for a user that is not a recipient",
+			clientSendMessage{To: []string{"bob"}, Payloads: []devicePayload{payload("bob", "phone"), payload("dave", "phone")}}, false},
+		{"a device has two payloads",
+			clientSendMessage{To: []string{"bob"}, Payloads: []devicePayload{payload("bob", "phone"), payload("bob", "phone")}}, false},
+		{"a payload has no device",
+			clientSendMessage{To: []string{"bob"}, Payloads: []devicePayload{payload("bob", "")}}, false},
+		{"a payload is empty",
+			clientSendMessage{To: []string{"bob"}, Payloads: []devicePayload{{UserName: "bob", Device: "phone"}}}, false},
+		{"a payload is too big",
+			clientSendMessage{To: []string{"bob"}, Payloads: []devicePayload{{UserName: "bob", Device: "phone", Ciphertext: bytes.Repeat([]byte{1}, maxPayloadSize+1)}}}, false},
+		{"the message has a text",
+			clientSendMessage{Text: "plain", To: []string{"bob"}, Payloads: []devicePayload{payload("bob", "phone")}}, false},
+		{"the message has attachments",
+			clientSendMessage{To: []string{"bob"}, Attachments: []string{newID()}, Payloads: []devicePayload{payload("bob", "phone")}}, false},
+		{"the message is for a group",
+			clientSendMessage{Group: newID(), Payloads: []devicePayload{payload("bob", "phone")}}, false},
+	}
+
+	for _, test := range tests {
+		message := test.message
+		if got := validatePayloads(&message); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
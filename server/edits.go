@@ -0,0 +1,115 @@
+package main
+
+import (
+	"golang.org/x/net/websocket"
+	"strings"
+	"time"
+)
+
+// changeableMessage is a controller pointer method that finds the message that an edit or unsend frame is about.
+// only the sender of the message can change it and only in the editWindow after sending.
+// it answers the frame with unknownMessage or notSender if the user is not allowed.
+// it returns the sent message which is nil if the user is not allowed.
+// it panics with an errScope if something went wrong.
+func (c *controller) changeableMessage(conn *websocket.Conn, frame envelope, userName string) *sentMessage {
+
+	if len(frame.ID) != idLength {
+		frameErrorSender(conn, invalidFrame, frame)
+		return nil
+	}
+
+	message, err := c.dbConn.getSentMessage(frame.ID)
+	if err != nil {
+		panic(errScope{scope: "changeableMessage-getSentMessage", err: err})
+	}
+
+	if message == nil || time.Since(message.sentAt) > c.conf.editWindow {
+		frameResponder(conn, frame, unknownMessage)
+		return nil
+	}
+	if message.sender != userName {
+		frameResponder(conn, frame, notSender)
+		return nil
+	}
+
+	return message
+}
+
+// forwardChange is a controller pointer method that forwards the change of a message to the online recipients.
+// the devices that haven't taken the message yet get the changed one from the storage, so nothing is kept for them.
+// the offline devices that have already acknowledged the message don't get the change at all,
+// because the change is not queued and they keep their old copy.
+// it gets the type of the frame, the sent message and the change.
+func (c *controller) forwardChange(frameType string, message *sentMessage, change messageChange) {
+
+	for _, recipient := range message.recipients {
+		c.notifyUser(recipient, frameType, message.id, change)
+	}
+}
+
+// editHandler is a controller pointer method that handles the edit frames.
+// the frame's ID is the ID of the message, and the new text replaces the old one in the offline messages and the history.
+// the end-to-end encrypted messages can't be edited, because server can't make their payloads.
+// it gets the frame, the websocket connection, the userName and the device ID of the client.
+func (c *controller) editHandler(frame envelope, conn *websocket.Conn, userName string, device string) {
+
+	defer c.handlerRecover(userName, device)
+
+	var request messageEdit
+	if !payloadDecoder(frame, &request, conn) {
+		return
+	}
+
+	request.Text = strings.TrimSpace(request.Text)
+	if request.Text == "" {
+		frameErrorSender(conn, invalidFrame, frame)
+		return
+	}
+
+	message := c.changeableMessage(conn, frame, userName)
+	if message == nil {
+		return
+	}
+	if message.encrypted {
+		frameErrorSender(conn, invalidFrame, frame)
+		return
+	}
+
+	err := c.dbConn.editMessage(message.id, request.Text)
+	if err != nil {
+		panic(errScope{scope: "editHandler-editMessage", err: err})
+	}
+
+	c.forwardChange(editFrame, message, messageChange{
+		ID:     message.id,
+		Sender: userName,
+		Group:  message.group,
+		Text:   request.Text,
+	})
+	frameResponder(conn, frame, approved)
+}
+
+// unsendHandler is a controller pointer method that handles the unsend frames.
+// the frame's ID is the ID of the message, and the message is deleted from the offline messages and the history.
+// it gets the frame, the websocket connection, the userName and the device ID of the client.
+func (c *controller) unsendHandler(frame envelope, conn *websocket.Conn, userName string, device string) {
+
+	defer c.handlerRecover(userName, device)
+
+	message := c.changeableMessage(conn, frame, userName)
+	if message == nil {
+		return
+	}
+
+	err := c.dbConn.unsendMessage(message.id)
+	if err != nil {
+		panic(errScope{scope: "unsendHandler-unsendMessage", err: err})
+	}
+
+	c.forwardChange(unsendFrame, message, messageChange{
+		ID:     message.id,
+		Sender: userName,
+		Group:  message.group,
+	})
+	frameResponder(conn, frame, approved)
+}
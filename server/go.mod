@@ -5,5 +5,6 @@ go 1.15
 require (
 	github.com/faiface/beep v1.0.2
 	github.com/go-sql-driver/mysql v1.5.0
+	github.com/mattn/go-sqlite3 v1.14.6
 	golang.org/x/net v0.0.0-20201010224723-4f7140c49acb
 )
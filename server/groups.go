@@ -0,0 +1,400 @@
+package main
+
+import (
+	"golang.org/x/net/websocket"
+	"strings"
+	"time"
+)
+
+// maxGroupMembers is the maximum number of members that a group can have.
+const maxGroupMembers = 256
+
+// validateGroupName validates the name of a group in terms of data appearance.
+// it gets the name pointer for space trimming so the value will be change globally.
+// it returns True if the name is valid and False if not.
+func validateGroupName(name *string) bool {
+
+	*name = strings.TrimSpace(*name)
+	return *name != "" && len(*name) <= 50
+}
+
+// validateMemberNames validates the userNames of a group frame in terms of data appearance.
+// it removes the repeated userNames.
+// it returns the userNames and True if they are valid and False if not.
+func validateMemberNames(userNames []string) ([]string, bool) {
+
+	if len(userNames) > maxGroupMembers {
+		return nil, false
+	}
+
+	seen := make(map[string]bool)
+	var result []string
+	for _, userName := range userNames {
+		if userName == "" || len(userName) > 50 || strings.Contains(userName, " ") {
+			return nil, false
+		}
+		if seen[userName] {
+			continue
+		}
+		seen[userName] = true
+		result = append(result, userName)
+	}
+
+	return result, true
+}
+
+// groupMemberRole is a controller pointer method that finds the role of a user in a group.
+// it gets the ID of the group and the userName.
+// it returns the members of the group and the role of the user which is empty if the user is not a member.
+// it panics with an errScope if something went wrong.
+func (c *controller) groupMemberRole(groupID string, userName string) ([]groupMember, string) {
+
+	if len(groupID) != idLength {
+		return nil, ""
+	}
+
+	members, err := c.dbConn.getGroupMembers(groupID)
+	if err != nil {
+		panic(errScope{scope: "groupMemberRole-getGroupMembers", err: err})
+	}
+
+	for _, member := range members {
+		if member.userName == userName {
+			return members, member.role
+		}
+	}
+
+	return members, ""
+}
+
+// checkGroupAdmin is a controller pointer method that checks whether the user is an admin of the group.
+// it answers the frame with notMember or notAdmin if the user is not allowed.
+// it returns the members of the group and True if the user is an admin and False if not.
+// it panics with an errScope if something went wrong.
+func (c *controller) checkGroupAdmin(conn *websocket.Conn, frame envelope, groupID string, userName string) ([]groupMember, bool) {
+
+	members, role := c.groupMemberRole(groupID, userName)
+	switch role {
+	case adminRole:
+		return members, true
+	case "":
+		frameResponder(conn, frame, notMember)
+	default:
+		frameResponder(conn, frame, notAdmin)
+	}
+
+	return nil, false
+}
+
+// checkUsersExist is a controller pointer method that checks whether all the userNames are existing or not.
+// it returns True if all of them are existing and False if not.
+// it panics with an errScope if something went wrong.
+func (c *controller) checkUsersExist(userNames []string) bool {
+
+	for _, userName := range userNames {
+		isClientExist, err := c.dbConn.checkClientUserName(userName)
+		if err != nil {
+			panic(errScope{scope: "checkUsersExist-checkClientUserName", err: err})
+		}
+		if !isClientExist {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ensureGroupAdmin is a controller pointer method that promotes the oldest member of the group to admin
+// if the group has no admin anymore, so a group never stays without an admin.
+// it panics with an errScope if something went wrong.
+func (c *controller) ensureGroupAdmin(groupID string) {
+
+	members, err := c.dbConn.getGroupMembers(groupID)
+	if err != nil {
+		panic(errScope{scope: "ensureGroupAdmin-getGroupMembers", err: err})
+	}
+	if len(members) == 0 {
+		return
+	}
+
+	for _, member := range members {
+		if member.role == adminRole {
+			return
+		}
+	}
+
+	err = c.dbConn.setGroupRole(groupID, members[0].userName, adminRole)
+	if err != nil {
+		panic(errScope{scope: "ensureGroupAdmin-setGroupRole", err: err})
+	}
+}
+
+// groupSender sends a group frame describing the group and its members.
+// it panics with an errScope if sending went wrong.
+func groupSender(conn *websocket.Conn, frame envelope, group groupData, members []groupMember) {
+
+	info := groupInfo{ID: group.id, Name: group.name, Members: []memberInfo{}}
+	for _, member := range members {
+		info.Members = append(info.Members, memberInfo{UserName: member.userName, Role: member.role})
+	}
+
+	err := frameSender(conn, groupFrame, frame.ID, info)
+	if err != nil {
+		panic(errScope{scope: "groupSender-frameSender", err: err})
+	}
+}
+
+// createGroupHandler is a controller pointer method that handles the createGroup frames.
+// the creator becomes the first admin of the group and the others are added as members.
+// it answers with a group frame containing the ID of the new group.
+// it gets the frame, the websocket connection, the userName and the device ID of the client.
+func (c *controller) createGroupHandler(frame envelope, conn *websocket.Conn, userName string, device string) {
+
+	defer c.handlerRecover(userName, device)
+
+	var request createGroup
+	if !payloadDecoder(frame, &request, conn) {
+		return
+	}
+
+	userNames, ok := validateMemberNames(request.Members)
+	if !ok || !validateGroupName(&request.Name) || len(userNames) >= maxGroupMembers {
+		frameErrorSender(conn, invalidFrame, frame)
+		return
+	}
+
+	if !c.checkUsersExist(userNames) {
+		frameResponder(conn, frame, invalidUserName)
+		return
+	}
+
+	now := time.Now().UTC()
+	group := groupData{id: newID(), name: request.Name, createdAt: now}
+	members := []groupMember{{userName: userName, role: adminRole, joinedAt: now}}
+	for _, member := range userNames {
+		if member != userName {
+			members = append(members, groupMember{userName: member, role: memberRole, joinedAt: now})
+		}
+	}
+
+	err := c.dbConn.insertGroup(group, members)
+	if err != nil {
+		panic(errScope{scope: "createGroupHandler-insertGroup", err: err})
+	}
+
+	groupSender(conn, frame, group, members)
+}
+
+// renameGroupHandler is a controller pointer method that handles the renameGroup frames.
+// only the group admins can rename the group.
+// it gets the frame, the websocket connection, the userName and the device ID of the client.
+func (c *controller) renameGroupHandler(frame envelope, conn *websocket.Conn, userName string, device string) {
+
+	defer c.handlerRecover(userName, device)
+
+	var request renameGroup
+	if !payloadDecoder(frame, &request, conn) {
+		return
+	}
+
+	if !validateGroupName(&request.Name) {
+		frameErrorSender(conn, invalidFrame, frame)
+		return
+	}
+
+	if _, ok := c.checkGroupAdmin(conn, frame, request.Group, userName); !ok {
+		return
+	}
+
+	err := c.dbConn.renameGroup(request.Group, request.Name)
+	if err != nil {
+		panic(errScope{scope: "renameGroupHandler-renameGroup", err: err})
+	}
+
+	frameResponder(conn, frame, approved)
+}
+
+// addMembersHandler is a controller pointer method that handles the addMembers frames.
+// only the group admins can add members and the users that are already members are ignored.
+// it gets the frame, the websocket connection, the userName and the device ID of the client.
+func (c *controller) addMembersHandler(frame envelope, conn *websocket.Conn, userName string, device string) {
+
+	defer c.handlerRecover(userName, device)
+
+	var request groupMembers
+	if !payloadDecoder(frame, &request, conn) {
+		return
+	}
+
+	userNames, ok := validateMemberNames(request.Members)
+	if !ok {
+		frameErrorSender(conn, invalidFrame, frame)
+		return
+	}
+
+	members, ok := c.checkGroupAdmin(conn, frame, request.Group, userName)
+	if !ok {
+		return
+	}
+
+	existing := make(map[string]bool, len(members))
+	for _, member := range members {
+		existing[member.userName] = true
+	}
+
+	now := time.Now().UTC()
+	var newMembers []groupMember
+	var newUserNames []string
+	for _, member := range userNames {
+		if !existing[member] {
+			newMembers = append(newMembers, groupMember{userName: member, role: memberRole, joinedAt: now})
+			newUserNames = append(newUserNames, member)
+		}
+	}
+
+	if len(members)+len(newMembers) > maxGroupMembers {
+		frameErrorSender(conn, invalidFrame, frame)
+		return
+	}
+
+	if !c.checkUsersExist(newUserNames) {
+		frameResponder(conn, frame, invalidUserName)
+		return
+	}
+
+	err := c.dbConn.insertGroupMembers(request.Group, newMembers)
+	if err != nil {
+		panic(errScope{scope: "addMembersHandler-insertGroupMembers", err: err})
+	}
+
+	frameResponder(conn, frame, approved)
+}
+
+// removeMembersHandler is a controller pointer method that handles the removeMembers frames.
+// only the group admins can remove members and the oldest member becomes admin if no admin is left.
+// it gets the frame, the websocket connection, the userName and the device ID of the client.
+func (c *controller) removeMembersHandler(frame envelope, conn *websocket.Conn, userName string, device string) {
+
+	defer c.handlerRecover(userName, device)
+
+	var request groupMembers
+	if !payloadDecoder(frame, &request, conn) {
+		return
+	}
+
+	userNames, ok := validateMemberNames(request.Members)
+	if !ok {
+		frameErrorSender(conn, invalidFrame, frame)
+		return
+	}
+
+	if _, ok := c.checkGroupAdmin(conn, frame, request.Group, userName); !ok {
+		return
+	}
+
+	err := c.dbConn.deleteGroupMembers(request.Group, userNames)
+	if err != nil {
+		panic(errScope{scope: "removeMembersHandler-deleteGroupMembers", err: err})
+	}
+
+	c.ensureGroupAdmin(request.Group)
+	frameResponder(conn, frame, approved)
+}
+
+// setRoleHandler is a controller pointer method that handles the setRole frames.
+// only the group admins can change the roles and the oldest member becomes admin if no admin is left.
+// it gets the frame, the websocket connection, the userName and the device ID of the client.
+func (c *controller) setRoleHandler(frame envelope, conn *websocket.Conn, userName string, device string) {
+
+	defer c.handlerRecover(userName, device)
+
+	var request setRole
+	if !payloadDecoder(frame, &request, conn) {
+		return
+	}
+
+	if request.Role != adminRole && request.Role != memberRole {
+		frameErrorSender(conn, invalidFrame, frame)
+		return
+	}
+
+	members, ok := c.checkGroupAdmin(conn, frame, request.Group, userName)
+	if !ok {
+		return
+	}
+
+	isMember := false
+	for _, member := range members {
+		if member.userName == request.UserName {
+			isMember = true
+		}
+	}
+	if !isMember {
+		frameResponder(conn, frame, invalidUserName)
+		return
+	}
+
+	err := c.dbConn.setGroupRole(request.Group, request.UserName, request.Role)
+	if err != nil {
+		panic(errScope{scope: "setRoleHandler-setGroupRole", err: err})
+	}
+
+	c.ensureGroupAdmin(request.Group)
+	frameResponder(conn, frame, approved)
+}
+
+// leaveGroupHandler is a controller pointer method that handles the leaveGroup frames.
+// the oldest member becomes admin if the last admin leaves, and the group is deleted when the last member leaves.
+// it gets the frame, the websocket connection, the userName and the device ID of the client.
+func (c *controller) leaveGroupHandler(frame envelope, conn *websocket.Conn, userName string, device string) {
+
+	defer c.handlerRecover(userName, device)
+
+	var request groupRef
+	if !payloadDecoder(frame, &request, conn) {
+		return
+	}
+
+	if _, role := c.groupMemberRole(request.Group, userName); role == "" {
+		frameResponder(conn, frame, notMember)
+		return
+	}
+
+	err := c.dbConn.deleteGroupMembers(request.Group, []string{userName})
+	if err != nil {
+		panic(errScope{scope: "leaveGroupHandler-deleteGroupMembers", err: err})
+	}
+
+	c.ensureGroupAdmin(request.Group)
+	frameResponder(conn, frame, approved)
+}
+
+// listMembersHandler is a controller pointer method that handles the listMembers frames.
+// it answers with a group frame and only the group members can ask for it.
+// it gets the frame, the websocket connection, the userName and the device ID of the client.
+func (c *controller) listMembersHandler(frame envelope, conn *websocket.Conn, userName string, device string) {
+
+	defer c.handlerRecover(userName, device)
+
+	var request groupRef
+	if !payloadDecoder(frame, &request, conn) {
+		return
+	}
+
+	members, role := c.groupMemberRole(request.Group, userName)
+	if role == "" {
+		frameResponder(conn, frame, notMember)
+		return
+	}
+
+	group, err := c.dbConn.getGroup(request.Group)
+	if err != nil {
+		panic(errScope{scope: "listMembersHandler-getGroup", err: err})
+	}
+	if group == nil {
+		frameResponder(conn, frame, notMember)
+		return
+	}
+
+	groupSender(conn, frame, *group, members)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"golang.org/x/net/websocket"
+	"strings"
+)
+
+// these are the limits of the history pages.
+// defaultHistoryLimit is the number of messages of a page if the client doesn't ask for a limit.
+// maxHistoryLimit is the maximum number of messages that a page can have.
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 200
+)
+
+// historyConversation is a controller pointer method that finds the conversation that a setHistory or history frame is about.
+// exactly one of with and group should be set, and the user should be one of the sides of the conversation.
+// for the groups, only the admins are allowed if adminOnly is True.
+// it answers the frame with invalidUserName, notMember or notAdmin if the user is not allowed.
+// it returns the conversation and True if the user is allowed and False if not.
+// it panics with an errScope if something went wrong.
+func (c *controller) historyConversation(conn *websocket.Conn, frame envelope, with string, group string,
+	userName string, adminOnly bool) (conversation, bool) {
+
+	if (with == "") == (group == "") {
+		frameErrorSender(conn, invalidFrame, frame)
+		return conversation{}, false
+	}
+
+	if group != "" {
+		_, role := c.groupMemberRole(group, userName)
+		switch {
+		case role == "":
+			frameResponder(conn, frame, notMember)
+			return conversation{}, false
+		case adminOnly && role != adminRole:
+			frameResponder(conn, frame, notAdmin)
+			return conversation{}, false
+		}
+		return groupConversation(group), true
+	}
+
+	if with == userName || len(with) > 50 || strings.Contains(with, " ") || !c.checkUsersExist([]string{with}) {
+		frameResponder(conn, frame, invalidUserName)
+		return conversation{}, false
+	}
+
+	return directConversation(userName, with), true
+}
+
+// setHistoryHandler is a controller pointer method that handles the setHistory frames.
+// both sides of a direct conversation can turn its history on or off, but only the admins can do it for a group.
+// it gets the frame, the websocket connection, the userName and the device ID of the client.
+func (c *controller) setHistoryHandler(frame envelope, conn *websocket.Conn, userName string, device string) {
+
+	defer c.handlerRecover(userName, device)
+
+	var request historySetting
+	if !payloadDecoder(frame, &request, conn) {
+		return
+	}
+
+	conv, ok := c.historyConversation(conn, frame, request.With, request.Group, userName, true)
+	if !ok {
+		return
+	}
+
+	err := c.dbConn.setKeepHistory(conv, request.Enabled)
+	if err != nil {
+		panic(errScope{scope: "setHistoryHandler-setKeepHistory", err: err})
+	}
+
+	frameResponder(conn, frame, approved)
+}
+
+// historyHandler is a controller pointer method that handles the history frames.
+// it answers with a historyPage frame and only the sides of the conversation can ask for it.
+// it gets the frame, the websocket connection, the userName and the device ID of the client.
+func (c *controller) historyHandler(frame envelope, conn *websocket.Conn, userName string, device string) {
+
+	defer c.handlerRecover(userName, device)
+
+	var request historyRequest
+	if !payloadDecoder(frame, &request, conn) {
+		return
+	}
+
+	if request.Limit == 0 {
+		request.Limit = defaultHistoryLimit
+	}
+	if request.Limit < 0 || request.Limit > maxHistoryLimit || (request.Before != "" && request.After != "") ||
+		(request.Before != "" && len(request.Before) != idLength) || (request.After != "" && len(request.After) != idLength) {
+		frameErrorSender(conn, invalidFrame, frame)
+		return
+	}
+
+	conv, ok := c.historyConversation(conn, frame, request.With, request.Group, userName, false)
+	if !ok {
+		return
+	}
+
+	// one more message is asked to find out whether there are more messages beyond the page or not.
+	messages, err := c.dbConn.getHistory(conv, request.Before, request.After, request.Limit+1)
+	if err != nil {
+		panic(errScope{scope: "historyHandler-getHistory", err: err})
+	}
+
+	page := historyPage{Messages: []clientReceiveMessage{}, HasMore: len(messages) > request.Limit}
+	if page.HasMore {
+		if request.After != "" {
+			messages = messages[:request.Limit]
+		} else {
+			messages = messages[1:]
+		}
+	}
+	err = c.loadAttachments(messages)
+	if err != nil {
+		panic(errScope{scope: "historyHandler-loadAttachments", err: err})
+	}
+	for _, message := range messages {
+		page.Messages = append(page.Messages, receivedMessage(message))
+	}
+
+	err = frameSender(conn, historyPageFrame, frame.ID, page)
+	if err != nil {
+		panic(errScope{scope: "historyHandler-frameSender", err: err})
+	}
+}
+
+// keepHistory is a controller pointer method that stores a sent message in the history of its conversations
+// that have their history turned on.
+// a group message is stored once for the group and a direct message is stored once for every existing recipient.
+// it gets the message, the userName of the sender and the results of the recipients.
+// returns error if something went wrong.
+func (c *controller) keepHistory(message messageData, userName string, results []recipientResult) error {
+
+	var convs []conversation
+	if message.group != "" {
+		convs = append(convs, groupConversation(message.group))
+	} else {
+		for _, result := range results {
+			if result.Status != unknownUserStatus && result.Status != blockedStatus {
+				convs = append(convs, directConversation(userName, result.UserName))
+			}
+		}
+	}
+
+	for _, conv := range convs {
+		enabled, err := c.dbConn.checkKeepHistory(conv)
+		if err != nil {
+			return err
+		}
+		if !enabled {
+			continue
+		}
+
+		err = c.dbConn.insertHistory(conv, message)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// idLength is the length of the ids that newID generates.
+const idLength = 32
+
+// lastID is the last id that newID has generated with its mutex.
+// newID uses it to keep the ids of the same millisecond in order.
+var lastID struct {
+	locker sync.Mutex
+	id     [16]byte
+}
+
+// newID generates a new unique id in the form of 32 hex digits.
+// the first 12 digits are the milliseconds of the current time and the rest are random.
+// so ids that are generated later sort after the older ones, and we can use them for ordering.
+// if the new id doesn't sort after the last one, which happens in the same millisecond, the last one plus one is used instead.
+func newID() string {
+
+	var id [16]byte
+	var now [8]byte
+	binary.BigEndian.PutUint64(now[:], uint64(time.Now().UnixNano()/int64(time.Millisecond)))
+	copy(id[:6], now[2:])
+	_, err := rand.Read(id[6:])
+	if err != nil {
+		panic(errScope{scope: "newID-Read", err: err})
+	}
+
+	lastID.locker.Lock()
+	defer lastID.locker.Unlock()
+	if bytes.Compare(id[:], lastID.id[:]) <= 0 {
+		id = lastID.id
+		for i := len(id) - 1; i >= 0; i-- {
+			id[i]++
+			if id[i] != 0 {
+				break
+			}
+		}
+	}
+	lastID.id = id
+
+	return hex.EncodeToString(id[:])
+}
+
+// validateID checks whether the given string is in the form of the ids that newID generates or not.
+// it's used for the ids that end up in file paths, so they can't point anywhere else.
+func validateID(ID string) bool {
+
+	if len(ID) != idLength {
+		return false
+	}
+
+	_, err := hex.DecodeString(ID)
+	return err == nil
+}
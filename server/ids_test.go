@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func TestNewID(t *testing.T) {
+
+	seen := make(map[string]bool)
+	for i := 0; i < 10000; i++ {
+		ID := newID()
+		if len(ID) != idLength {
+			t.Fatalf("newID returned %q with length %d, want %d", ID, len(ID), idLength)
+		}
+		if _, err := hex.DecodeString(ID); err != nil {
+			t.Fatalf("newID returned an ID that is not hex: %q", ID)
+		}
+		if seen[ID] {
+			t.Fatalf("newID returned %q twice", ID)
+		}
+		seen[ID] = true
+	}
+}
+
+func TestNewIDOrder(t *testing.T) {
+
+	// most of these IDs are generated in the same millisecond, and they still sort in the order of generation.
+	last := newID()
+	for i := 0; i < 10000; i++ {
+		ID := newID()
+		if ID <= last {
+			t.Fatalf("newID is not increasing: %q after %q", ID, last)
+		}
+		last = ID
+	}
+}
+
+func TestNewIDTimeOrder(t *testing.T) {
+
+	older := newID()
+	time.Sleep(2 * time.Millisecond)
+	newer := newID()
+	if newer <= older {
+		t.Fatalf("the ID of a later millisecond should sort after the older one: %q after %q", newer, older)
+	}
+}
+
+func TestValidateID(t *testing.T) {
+
+	tests := map[string]bool{
+		newID():                              true,
+		"":                                   false,
+		"0123456789abcdef0123456789abcdef":   true,
+		"0123456789abcdef0123456789abcde":    false,
+		"0123456789abcdef0123456789abcdeg":   false,
+		"../../../../etc/passwd/../../../..": false,
+	}
+	for ID, want := range tests {
+		if got := validateID(ID); got != want {
+			t.Errorf("validateID(%q) = %v, want %v", ID, got, want)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import "time"
+
+// janitor is a controller pointer method that deletes the old data that server doesn't need anymore.
+// it runs forever and cleans every hour.
+// the history messages that are older than historyRetention are deleted if historyRetention is not zero,
+// and the sent messages that are older than receiptRetention are deleted, so they don't get receipts anymore.
+// they can't be edited or unsent after editWindow either, but that is checked with the time that they have been sent.
+// the expired sessions are deleted too, because the clients that never come back don't delete them.
+func (c *controller) janitor() {
+
+	for {
+		now := time.Now().UTC()
+
+		if c.conf.historyRetention != 0 {
+			err := c.dbConn.deleteOldHistory(now.Add(-c.conf.historyRetention))
+			if err != nil {
+				logError("janitor-deleteOldHistory", err)
+			}
+		}
+
+		err := c.dbConn.deleteOldSentMessages(now.Add(-c.conf.receiptRetention))
+		if err != nil {
+			logError("janitor-deleteOldSentMessages", err)
+		}
+
+		err = c.dbConn.deleteExpiredSessions(now)
+		if err != nil {
+			logError("janitor-deleteExpiredSessions", err)
+		}
+
+		time.Sleep(time.Hour)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+)
+
+// maxKeyIDLength is the maximum length of the IDs of the master keys, the messages table keeps them in a VARCHAR(32).
+const maxKeyIDLength = 32
+
+// masterKeySize is the size of the master keys in bytes, they are AES-256 keys.
+const masterKeySize = 32
+
+// errNoEncryption is the error that we return when the storage backend doesn't encrypt the queued messages.
+var errNoEncryption = errors.New("storage backend doesn't encrypt the queued messages")
+
+// errUnknownKey is the error that we return when a row is encrypted with a master key that is not in the keyRing.
+var errUnknownKey = errors.New("message is encrypted with an unknown master key")
+
+// keyRing is the struct that keeps the master keys that encrypt the queued messages and the channel posts at rest.
+// current is the ID of the key that the new rows are encrypted with.
+// keys are the AES-GCM ciphers of every key and key of the map is the key ID,
+// the keys beside the current one are only kept to decrypt the rows that haven't been rotated yet.
+// a nil keyRing keeps the rows as plain text.
+type keyRing struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// keyRotator is the interface of the storage backends that encrypt the queued messages at rest.
+type keyRotator interface {
+
+	// rotateMessageKeys encrypts every queued message and channel post that isn't encrypted with the current master key
+	// with the current one and returns the number of the rotated rows.
+	rotateMessageKeys() (int, error)
+}
+
+// loadKeyRing reads the master keys from the given file.
+// every line of the file is a key ID followed by the hex of the key, and the empty lines and the ones that start with '#' are ignored.
+// the first key is the current one, so a key is rotated by adding the new key at the top and running the rotate-keys command.
+// it returns nil if the path is empty.
+// returns error if the file can't be read or the keys are not valid.
+func loadKeyRing(path string) (*keyRing, error) {
+
+	if path == "" {
+		return nil, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	ring := &keyRing{keys: make(map[string]cipher.AEAD)}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 || len(fields[0]) > maxKeyIDLength {
+			return nil, errors.New("master keys should be lines of a key ID and the hex of the key")
+		}
+		if _, ok := ring.keys[fields[0]]; ok {
+			return nil, errors.New("master key ID is repeated: " + fields[0])
+		}
+
+		key, err := hex.DecodeString(fields[1])
+		if err != nil || len(key) != masterKeySize {
+			return nil, errors.New("master key should be 32 bytes in hex: " + fields[0])
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		if ring.current == "" {
+			ring.current = fields[0]
+		}
+		ring.keys[fields[0]] = aead
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if ring.current == "" {
+		return nil, errors.New("master key file has no key")
+	}
+
+	return ring, nil
+}
+
+// currentKey returns the ID of the key that the new rows are encrypted with and it's empty for a nil keyRing.
+func (ring *keyRing) currentKey() string {
+
+	if ring == nil {
+		return ""
+	}
+
+	return ring.current
+}
+
+// seal encrypts the text of a message with the current key.
+// the message ID is authenticated with it, so the text can't be moved to another message.
+// it gets the message ID and the text.
+// it returns the ID of the key and the base64 of the nonce and the ciphertext,
+// or an empty key ID and the text itself for a nil keyRing.
+// returns error if something went wrong.
+func (ring *keyRing) seal(ID string, text string) (string, string, error) {
+
+	if ring == nil {
+		return "", text, nil
+	}
+
+	aead := ring.keys[ring.current]
+	nonce := make([]byte, aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(text), []byte(ID))
+	return ring.current, base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts the text of a message that seal has encrypted.
+// the rows with an empty key ID are plain text and they are returned as they are.
+// it gets the key ID, the message ID and the sealed text.
+// returns errUnknownKey if the key is not in the keyRing and error if the text is not valid.
+func (ring *keyRing) open(keyID string, ID string, sealed string) (string, error) {
+
+	if keyID == "" {
+		return sealed, nil
+	}
+
+	var aead cipher.AEAD
+	if ring != nil {
+		aead = ring.keys[keyID]
+	}
+	if aead == nil {
+		return "", errUnknownKey
+	}
+
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < aead.NonceSize() {
+		return "", errors.New("sealed message is too short")
+	}
+
+	text, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(ID))
+	if err != nil {
+		return "", err
+	}
+
+	return string(text), nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeKeyFile writes a master key file with the given key IDs and returns its path.
+// every key is the SHA-256 of its ID, so the same ID has the same key in every file.
+func writeKeyFile(t *testing.T, keyIDs ...string) string {
+
+	t.Helper()
+
+	var lines []string
+	for _, keyID := range keyIDs {
+		key := sha256.Sum256([]byte(keyID))
+		lines = append(lines, keyID+" "+hex.EncodeToString(key[:]))
+	}
+
+	path := filepath.Join(t.TempDir(), "keys")
+	err := ioutil.WriteFile(path, []byte("# master keys\n\n"+strings.Join(lines, "\n")+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadKeyRing(t *testing.T) {
+
+	ring, err := loadKeyRing("")
+	if err != nil || ring != nil {
+		t.Fatalf("empty path: got %v %v", ring, err)
+	}
+
+	ring, err = loadKeyRing(writeKeyFile(t, "new", "old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ring.currentKey() != "new" || len(ring.keys) != 2 {
+		t.Fatalf("got current key %q and %d keys", ring.currentKey(), len(ring.keys))
+	}
+
+	_, err = loadKeyRing(writeKeyFile(t, "same", "same"))
+	if err == nil {
+		t.Fatal("a repeated key ID should not be accepted")
+	}
+
+	path := filepath.Join(t.TempDir(), "short")
+	err = ioutil.WriteFile(path, []byte("short abcd\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = loadKeyRing(path)
+	if err == nil {
+		t.Fatal("a short key should not be accepted")
+	}
+}
+
+func TestKeyRingSealOpen(t *testing.T) {
+
+	ring, err := loadKeyRing(writeKeyFile(t, "k1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ID := newID()
+	keyID, sealed, err := ring.seal(ID, "secret text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyID != "k1" || strings.Contains(sealed, "secret") {
+		t.Fatalf("seal: got %q %q", keyID, sealed)
+	}
+
+	text, err := ring.open(keyID, ID, sealed)
+	if err != nil || text != "secret text" {
+		t.Fatalf("open: got %q %v", text, err)
+	}
+
+	_, err = ring.open(keyID, newID(), sealed)
+	if err == nil {
+		t.Fatal("a sealed text should not be opened for another message ID")
+	}
+
+	_, err = ring.open("missing", ID, sealed)
+	if err != errUnknownKey {
+		t.Fatalf("open with an unknown key: got %v, want errUnknownKey", err)
+	}
+
+	// a nil keyRing keeps the texts as they are, and the plain rows are opened by any keyRing.
+	var plain *keyRing
+	keyID, sealed, err = plain.seal(ID, "plain text")
+	if err != nil || keyID != "" || sealed != "plain text" {
+		t.Fatalf("nil keyRing seal: got %q %q %v", keyID, sealed, err)
+	}
+	text, err = ring.open("", ID, "plain text")
+	if err != nil || text != "plain text" {
+		t.Fatalf("open of a plain row: got %q %v", text, err)
+	}
+}
+
+func TestRotateMessageKeys(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "test.db")
+	dbConn, err := createSQLiteConnection(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = dbConn.close() }()
+	_, err = dbConn.migrateUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	insertTestUsers(t, dbConn, "alice", "bob")
+	err = dbConn.insertDevice("bob", "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dbConn.insertChannel("news", channelMember{userName: "alice", role: ownerRole, joinedID: newID()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dbConn.insertChannelMember("news", channelMember{userName: "bob", role: subscriberRole, joinedID: newID()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first message is kept as plain text and the second one with the old key.
+	plain := messageData{id: newID(), timeStamp: time.Now().UTC(), text: "plain", sender: "alice"}
+	err = dbConn.insertMessage("bob", plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbConn.keys, err = loadKeyRing(writeKeyFile(t, "old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := messageData{id: newID(), timeStamp: time.Now().UTC(), text: "old key", sender: "alice"}
+	err = dbConn.insertMessage("bob", old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := messageData{id: newID(), timeStamp: time.Now().UTC(), text: "old post", sender: "alice"}
+	err = dbConn.insertPost("news", post)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored string
+	err = dbConn.db.QueryRow("SELECT text FROM channel_posts WHERE postID = ?", post.id).Scan(&stored)
+	if err != nil || stored == post.text {
+		t.Fatalf("the post should be stored encrypted: got %q %v", stored, err)
+	}
+
+	dbConn.keys, err = loadKeyRing(writeKeyFile(t, "new", "old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := dbConn.rotateMessageKeys()
+	if err != nil || count != 3 {
+		t.Fatalf("rotateMessageKeys: got %d %v, want 2 rotated messages and 1 rotated post", count, err)
+	}
+	count, err = dbConn.rotateMessageKeys()
+	if err != nil || count != 0 {
+		t.Fatalf("repeated rotateMessageKeys: got %d %v, want nothing to rotate", count, err)
+	}
+
+	// the rows are readable without the old key after the rotation.
+	ring, err := loadKeyRing(writeKeyFile(t, "new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbConn.keys = ring
+	messages, err := dbConn.getMessages("bob", "phone")
+	if err != nil || len(messages) != 2 || messages[0].text != "plain" || messages[1].text != "old key" {
+		t.Fatalf("getMessages after the rotation: got %v %v", messages, err)
+	}
+	posts, err := dbConn.getMissedPosts("news", "bob", "phone", 10)
+	if err != nil || len(posts) != 1 || posts[0].text != "old post" {
+		t.Fatalf("getMissedPosts after the rotation: got %v %v", posts, err)
+	}
+}
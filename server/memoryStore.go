@@ -0,0 +1,1536 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errNoSuchUser is the error that memoryStore returns when the asked user is not existing.
+var errNoSuchUser = errors.New("no such user")
+
+// memoryStore is the store implementation that keeps everything in memory.
+// it's for tests and throwaway servers because everything will be lost on restart.
+// locker is the mutex that we use to lock the maps to prevent race problems.
+// users is the map of users and key of the map is user's userName.
+// publicKeys is the map of userNames and key of the map is user's public key.
+// devices is the map of the known devices of users and key of the map is user's userName.
+// messages is the map of offline messages queues and key of the map is the receiver's userName.
+// sessions is the map of sessions and key of the map is the session's token hash.
+// groups is the map of groups and key of the map is the group's ID.
+// groupMembers is the map of the members of groups in the order that they have joined and key of the map is the group's ID.
+// channelMembers is the map of the members of channels, key of the map is the channel's name and key of the inner map is
+// the member's userName, every channel has an entry even if it has no member.
+// channelPosts is the map of the posts of channels in the order that they have been posted and key of the map is the channel's name.
+// channelCursors is the map of the last acknowledged post IDs and key of the map is made by cursorKey.
+// keepHistory is the set of the conversations that have their history turned on.
+// history is the map of the history of conversations in the order of the message IDs and key of the map is the conversation.
+// sentMessages is the map of the sent messages that can be changed and key of the map is the message's ID.
+// marks is the map of the times that the sent messages have been delivered or read.
+// receipts is the map of offline receipts queues and key of the map is the sender's userName.
+// settings is the map of the settings of the users that have changed them and key of the map is user's userName.
+// lastSeen is the map of the times that users have been online for the last time and key of the map is user's userName.
+// lists is the map of the sets of the entries of the users' lists and key of the map is the userList.
+// attachments is the map of the uploaded attachments and key of the map is the attachment's ID.
+// messageAttachments is the map of the attachment IDs of messages in their order and key of the map is the message's ID.
+// attachmentAccess is the map of the conversations that can fetch the attachments and key of the map is the attachment's ID.
+// identityKeys is the map of the identity keys of devices and key of the map is made by the userName and the device ID.
+// prekeys is the map of the one-time prekeys of devices in the order of their IDs and key of the map is the same.
+type memoryStore struct {
+	locker             sync.Mutex
+	users              map[string]userData
+	publicKeys         map[string]string
+	devices            map[string][]string
+	messages           map[string][]queuedMessage
+	sessions           map[string]sessionData
+	groups             map[string]groupData
+	groupMembers       map[string][]groupMember
+	channelMembers     map[string]map[string]channelMember
+	channelPosts       map[string][]messageData
+	channelCursors     map[string]string
+	keepHistory        map[conversation]bool
+	history            map[conversation][]historyMessage
+	sentMessages       map[string]sentMessage
+	marks              map[mark]time.Time
+	receipts           map[string][]queuedReceipt
+	settings           map[string]userSettings
+	lastSeen           map[string]time.Time
+	lists              map[userList]map[string]bool
+	attachments        map[string]attachmentData
+	messageAttachments map[string][]string
+	attachmentAccess   map[string]map[attachmentAccess]bool
+	identityKeys       map[userDevice][]byte
+	prekeys            map[userDevice][]prekeyData
+}
+
+// queuedMessage is the struct that memoryStore uses to keep a message in the offline messages queue.
+// device is the device that the message is kept for and it's empty if no device has taken the message yet.
+// message is the messageData.
+type queuedMessage struct {
+	device  string
+	message messageData
+}
+
+// historyMessage is the struct that memoryStore uses to keep a message in the history of a conversation.
+// storedAt is the time that the message has been stored.
+// message is the messageData.
+type historyMessage struct {
+	storedAt time.Time
+	message  messageData
+}
+
+// mark is the struct that memoryStore uses to keep the deliveredStatus and readStatus of the sent messages.
+// ID is the ID of the message.
+// recipient is the userName of the recipient.
+// status is either deliveredStatus or readStatus.
+type mark struct {
+	ID        string
+	recipient string
+	status    string
+}
+
+// userList is the struct that memoryStore uses to identify a list of a user.
+// userName is the owner of the list.
+// list is either contactsList or blockedList.
+type userList struct {
+	userName string
+	list     string
+}
+
+// userDevice is the struct that memoryStore uses to identify a device of a user.
+type userDevice struct {
+	userName string
+	device   string
+}
+
+// queuedReceipt is the struct that memoryStore uses to keep a receipt in the offline receipts queue.
+// device is the device that the receipt is kept for and it's empty if no device has taken the receipt yet.
+// receipt is the receiptData.
+type queuedReceipt struct {
+	device  string
+	receipt receiptData
+}
+
+// newMemoryStore inits an empty memoryStore and returns it as pointer.
+func newMemoryStore() *memoryStore {
+
+	return &memoryStore{
+		users:              make(map[string]userData),
+		publicKeys:         make(map[string]string),
+		devices:            make(map[string][]string),
+		messages:           make(map[string][]queuedMessage),
+		sessions:           make(map[string]sessionData),
+		groups:             make(map[string]groupData),
+		groupMembers:       make(map[string][]groupMember),
+		channelMembers:     make(map[string]map[string]channelMember),
+		channelPosts:       make(map[string][]messageData),
+		channelCursors:     make(map[string]string),
+		keepHistory:        make(map[conversation]bool),
+		history:            make(map[conversation][]historyMessage),
+		sentMessages:       make(map[string]sentMessage),
+		marks:              make(map[mark]time.Time),
+		receipts:           make(map[string][]queuedReceipt),
+		settings:           make(map[string]userSettings),
+		lastSeen:           make(map[string]time.Time),
+		lists:              make(map[userList]map[string]bool),
+		attachments:        make(map[string]attachmentData),
+		messageAttachments: make(map[string][]string),
+		attachmentAccess:   make(map[string]map[attachmentAccess]bool),
+		identityKeys:       make(map[userDevice][]byte),
+		prekeys:            make(map[userDevice][]prekeyData),
+	}
+}
+
+// checkPublicKey checks whether the public key exists in the memory or not.
+func (m *memoryStore) checkPublicKey(key []byte) (bool, error) {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	_, ok := m.publicKeys[string(key)]
+	return ok, nil
+}
+
+// getCredentials gets a userName and returns the credentials of that user.
+// memory never has the old ClientIDs, so only the public key is returned.
+// returns nil if the user is not existing.
+func (m *memoryStore) getCredentials(userName string) (*credentials, error) {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	user, ok := m.users[userName]
+	if !ok {
+		return nil, nil
+	}
+
+	return &credentials{publicKey: append([]byte(nil), user.publicKey...)}, nil
+}
+
+// setPublicKey replaces the public key of the given userName.
+// returns errDuplicate if the public key belongs to another user and errNoSuchUser if the user is not existing.
+func (m *memoryStore) setPublicKey(userName string, key []byte) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	user, ok := m.users[userName]
+	if !ok {
+		return errNoSuchUser
+	}
+	if owner, ok := m.publicKeys[string(key)]; ok && owner != userName {
+		return errDuplicate
+	}
+
+	delete(m.publicKeys, string(user.publicKey))
+	user.publicKey = append([]byte(nil), key...)
+	m.users[userName] = user
+	m.publicKeys[string(user.publicKey)] = userName
+	return nil
+}
+
+// checkClientUserName checks whether the userName exists in the memory or not.
+func (m *memoryStore) checkClientUserName(userName string) (bool, error) {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	_, ok := m.users[userName]
+	return ok, nil
+}
+
+// insertDevice adds the device to the known devices of the given userName if it's not already there.
+// the first device of the user takes the messages and the receipts that have been queued with an empty device.
+// returns errNoSuchUser if the user is not existing.
+func (m *memoryStore) insertDevice(userName string, device string) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	if _, ok := m.users[userName]; !ok {
+		return errNoSuchUser
+	}
+
+	for _, known := range m.devices[userName] {
+		if known == device {
+			return nil
+		}
+	}
+
+	if len(m.devices[userName]) == 0 {
+		for i := range m.messages[userName] {
+			if m.messages[userName][i].device == "" {
+				m.messages[userName][i].device = device
+			}
+		}
+		for i := range m.receipts[userName] {
+			if m.receipts[userName][i].device == "" {
+				m.receipts[userName][i].device = device
+			}
+		}
+	}
+
+	m.devices[userName] = append(m.devices[userName], device)
+	return nil
+}
+
+// getDevices returns a copy of the known devices of the given userName sorted by the ID.
+func (m *memoryStore) getDevices(userName string) ([]string, error) {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	devices := append([]string(nil), m.devices[userName]...)
+	sort.Strings(devices)
+	return devices, nil
+}
+
+// deleteDevice deletes the device of the given userName from the memory with its queued messages and receipts,
+// its channel cursors and its keys.
+func (m *memoryStore) deleteDevice(userName string, device string) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	var devices []string
+	for _, known := range m.devices[userName] {
+		if known != device {
+			devices = append(devices, known)
+		}
+	}
+	m.devices[userName] = devices
+
+	messages := m.messages[userName][:0]
+	for _, queued := range m.messages[userName] {
+		if queued.device != device {
+			messages = append(messages, queued)
+		}
+	}
+	m.messages[userName] = messages
+
+	receipts := m.receipts[userName][:0]
+	for _, queued := range m.receipts[userName] {
+		if queued.device != device {
+			receipts = append(receipts, queued)
+		}
+	}
+	m.receipts[userName] = receipts
+
+	for name := range m.channelMembers {
+		delete(m.channelCursors, cursorKey(name, userName, device))
+	}
+	userDevice := userDevice{userName: userName, device: device}
+	delete(m.identityKeys, userDevice)
+	delete(m.prekeys, userDevice)
+	return nil
+}
+
+// insertMessage appends a copy of the messageData for every device of the given userName to its offline messages queue.
+// if the user has no device yet, a single copy with an empty device is appended that the first device will take.
+// returns errNoSuchUser if the user is not existing.
+func (m *memoryStore) insertMessage(userName string, message messageData) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	if _, ok := m.users[userName]; !ok {
+		return errNoSuchUser
+	}
+
+	devices := m.devices[userName]
+	if message.payloads != nil {
+		for _, device := range devices {
+			if payload, ok := message.payloads[device]; ok {
+				copied := message
+				copied.payloads = map[string][]byte{device: append([]byte(nil), payload...)}
+				m.messages[userName] = append(m.messages[userName], queuedMessage{device: device, message: copied})
+			}
+		}
+		return nil
+	}
+
+	if len(devices) == 0 {
+		devices = []string{""}
+	}
+
+	for _, device := range devices {
+		m.messages[userName] = append(m.messages[userName], queuedMessage{device: device, message: message})
+	}
+	return nil
+}
+
+// getMessages returns the offline messages of the given device of the given userName.
+func (m *memoryStore) getMessages(userName string, device string) ([]messageData, error) {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	var result []messageData
+	for _, queued := range m.messages[userName] {
+		if queued.device == device {
+			result = append(result, queued.message)
+		}
+	}
+
+	return result, nil
+}
+
+// deleteMessage deletes the message with the given ID of the given device from the offline messages queue of the given userName.
+func (m *memoryStore) deleteMessage(userName string, device string, ID string) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	queue := m.messages[userName]
+	result := queue[:0]
+	for _, queued := range queue {
+		if queued.message.id == ID && queued.device == device {
+			continue
+		}
+		result = append(result, queued)
+	}
+	m.messages[userName] = result
+
+	return nil
+}
+
+// insertSentMessage keeps the sentMessage in the memory.
+func (m *memoryStore) insertSentMessage(message sentMessage) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	message.recipients = append([]string(nil), message.recipients...)
+	m.sentMessages[message.id] = message
+	return nil
+}
+
+// getSentMessage returns the sentMessage with the given ID from the memory.
+// returns nil if there is no such message.
+func (m *memoryStore) getSentMessage(ID string) (*sentMessage, error) {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	message, ok := m.sentMessages[ID]
+	if !ok {
+		return nil, nil
+	}
+
+	message.recipients = append([]string(nil), message.recipients...)
+	return &message, nil
+}
+
+// editMessage changes the text of the message with the given ID in the offline messages queues and the history of the memory.
+func (m *memoryStore) editMessage(ID string, text string) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	for _, queue := range m.messages {
+		for i := range queue {
+			if queue[i].message.id == ID {
+				queue[i].message.text = text
+			}
+		}
+	}
+	for _, messages := range m.history {
+		for i := range messages {
+			if messages[i].message.id == ID {
+				messages[i].message.text = text
+			}
+		}
+	}
+
+	return nil
+}
+
+// unsendMessage deletes the message with the given ID from the offline messages queues, the history and the sent messages of the memory.
+func (m *memoryStore) unsendMessage(ID string) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	for userName, queue := range m.messages {
+		result := queue[:0]
+		for _, queued := range queue {
+			if queued.message.id != ID {
+				result = append(result, queued)
+			}
+		}
+		m.messages[userName] = result
+	}
+	for conv, messages := range m.history {
+		var result []historyMessage
+		for _, message := range messages {
+			if message.message.id != ID {
+				result = append(result, message)
+			}
+		}
+		m.history[conv] = result
+	}
+	delete(m.sentMessages, ID)
+	m.removeStaleMarks()
+
+	return nil
+}
+
+// deleteOldSentMessages deletes the sent messages of the memory that have been sent before the given time.
+func (m *memoryStore) deleteOldSentMessages(before time.Time) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	for ID, message := range m.sentMessages {
+		if message.sentAt.Before(before) {
+			delete(m.sentMessages, ID)
+		}
+	}
+	m.removeStaleMarks()
+
+	return nil
+}
+
+// removeStaleMarks removes the marks of the messages that are not in the sent messages anymore.
+// the locker should be locked by the caller.
+func (m *memoryStore) removeStaleMarks() {
+
+	for key := range m.marks {
+		if _, ok := m.sentMessages[key.ID]; !ok {
+			delete(m.marks, key)
+		}
+	}
+}
+
+// markDelivered marks the sent message with the given ID as delivered to the recipient in the memory.
+// it returns the sender of the message if it has been marked now and an empty string if not.
+func (m *memoryStore) markDelivered(ID string, recipient string, at time.Time) (string, error) {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	return m.setMark(mark{ID: ID, recipient: recipient, status: deliveredStatus}, at), nil
+}
+
+// markRead marks the sent message with the given ID as read and delivered by the recipient in the memory.
+// it returns the sender of the message if it has been marked as read now and an empty string if not.
+func (m *memoryStore) markRead(ID string, recipient string, at time.Time) (string, error) {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	sender := m.setMark(mark{ID: ID, recipient: recipient, status: readStatus}, at)
+	if sender != "" {
+		m.setMark(mark{ID: ID, recipient: recipient, status: deliveredStatus}, at)
+	}
+	return sender, nil
+}
+
+// setMark sets the mark if the message has been sent to the recipient and the mark is not set yet.
+// it returns the sender of the message if the mark has been set now and an empty string if not.
+// the locker should be locked by the caller.
+func (m *memoryStore) setMark(key mark, at time.Time) string {
+
+	message, ok := m.sentMessages[key.ID]
+	if !ok {
+		return ""
+	}
+	if _, ok := m.marks[key]; ok {
+		return ""
+	}
+
+	for _, recipient := range message.recipients {
+		if recipient == key.recipient {
+			m.marks[key] = at
+			return message.sender
+		}
+	}
+	return ""
+}
+
+// insertReceipt inserts the receiptData into the offline receipts queue of every device of the given userName.
+func (m *memoryStore) insertReceipt(userName string, receipt receiptData) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	if _, ok := m.users[userName]; !ok {
+		return errNoSuchUser
+	}
+
+	devices := m.devices[userName]
+	if len(devices) == 0 {
+		devices = []string{""}
+	}
+
+	for _, device := range devices {
+		m.receipts[userName] = append(m.receipts[userName], queuedReceipt{device: device, receipt: receipt})
+	}
+	return nil
+}
+
+// getReceipts returns the offline receipts of the given device of the given userName.
+func (m *memoryStore) getReceipts(userName string, device string) ([]receiptData, error) {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	var result []receiptData
+	for _, queued := range m.receipts[userName] {
+		if queued.device == device {
+			result = append(result, queued.receipt)
+		}
+	}
+
+	return result, nil
+}
+
+// deleteReceipt deletes the receiptData of the given device from the offline receipts queue of the given userName.
+func (m *memoryStore) deleteReceipt(userName string, device string, receipt receiptData) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	queue := m.receipts[userName]
+	result := queue[:0]
+	for _, queued := range queue {
+		if queued.receipt.messageID == receipt.messageID && queued.receipt.recipient == receipt.recipient &&
+			queued.receipt.status == receipt.status && queued.device == device {
+			continue
+		}
+		result = append(result, queued)
+	}
+	m.receipts[userName] = result
+
+	return nil
+}
+
+// getSettings returns the settings of the given userName from the memory.
+// returns the defaultSettings if the user hasn't changed them.
+func (m *memoryStore) getSettings(userName string) (userSettings, error) {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	settings, ok := m.settings[userName]
+	if !ok {
+		return defaultSettings, nil
+	}
+
+	return settings, nil
+}
+
+// setSettings replaces the settings of the given userName in the memory.
+func (m *memoryStore) setSettings(userName string, settings userSettings) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	m.settings[userName] = settings
+	return nil
+}
+
+// setLastSeen replaces the last seen time of the given userName in the memory if the user is existing.
+func (m *memoryStore) setLastSeen(userName string, at time.Time) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	if _, ok := m.users[userName]; !ok {
+		return nil
+	}
+	m.lastSeen[userName] = at.UTC()
+	return nil
+}
+
+// getLastSeen returns the last seen time of the given userName from the memory.
+// returns nil if it's not stored.
+func (m *memoryStore) getLastSeen(userName string) (*time.Time, error) {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	lastSeen, ok := m.lastSeen[userName]
+	if !ok {
+		return nil, nil
+	}
+
+	return &lastSeen, nil
+}
+
+// getPeers returns the userNames of the other members of the groups that the given userName is a member of
+// and the users that have it in their contactsList, beside the ones in its blockedList.
+func (m *memoryStore) getPeers(userName string) ([]string, error) {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	peers := make(map[string]bool)
+	for _, members := range m.groupMembers {
+		isMember := false
+		for _, member := range members {
+			if member.userName == userName {
+				isMember = true
+				break
+			}
+		}
+		if !isMember {
+			continue
+		}
+		for _, member := range members {
+			if member.userName != userName {
+				peers[member.userName] = true
+			}
+		}
+	}
+
+	for key, entries := range m.lists {
+		if key.list == contactsList && entries[userName] {
+			peers[key.userName] = true
+		}
+	}
+
+	var result []string
+	for peer := range peers {
+		if !m.lists[userList{userName: userName, list: blockedList}][peer] {
+			result = append(result, peer)
+		}
+	}
+
+	sort.Strings(result)
+	return result, nil
+}
+
+// insertListEntries adds the entries to the list of the given userName in the memory.
+func (m *memoryStore) insertListEntries(userName string, list string, entries []string) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	key := userList{userName: userName, list: list}
+	if m.lists[key] == nil {
+		m.lists[key] = make(map[string]bool)
+	}
+	for _, entry := range entries {
+		m.lists[key][entry] = true
+	}
+
+	return nil
+}
+
+// deleteListEntries removes the entries from the list of the given userName in the memory.
+func (m *memoryStore) deleteListEntries(userName string, list string, entries []string) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	key := userList{userName: userName, list: list}
+	for _, entry := range entries {
+		delete(m.lists[key], entry)
+	}
+
+	return nil
+}
+
+// getList returns the entries of the list of the given userName from the memory.
+func (m *memoryStore) getList(userName string, list string) ([]string, error) {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	var result []string
+	for entry := range m.lists[userList{userName: userName, list: list}] {
+		result = append(result, entry)
+	}
+
+	sort.Strings(result)
+	return result, nil
+}
+
+// checkListEntry checks whether the entry is in the list of the given userName in the memory or not.
+func (m *memoryStore) checkListEntry(userName string, list string, entry string) (bool, error) {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	return m.lists[userList{userName: userName, list: list}][entry], nil
+}
+
+// insertAttachment inserts an attachmentData into the memory.
+func (m *memoryStore) insertAttachment(attachment attachmentData) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	m.attachments[attachment.id] = attachment
+	return nil
+}
+
+// getAttachment returns the attachmentData with the given ID from the memory.
+// returns nil if there is no such attachment.
+func (m *memoryStore) getAttachment(ID string) (*attachmentData, error) {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	attachment, ok := m.attachments[ID]
+	if !ok {
+		return nil, nil
+	}
+
+	return &attachment, nil
+}
+
+// linkAttachments keeps the attachments of the message and the conversations that can fetch them in the memory.
+func (m *memoryStore) linkAttachments(messageID string, IDs []string, access []attachmentAccess) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	m.messageAttachments[messageID] = append([]string(nil), IDs...)
+	for _, ID := range IDs {
+		if m.attachmentAccess[ID] == nil {
+			m.attachmentAccess[ID] = make(map[attachmentAccess]bool)
+		}
+		for _, conv := range access {
+			m.attachmentAccess[ID][conv] = true
+		}
+	}
+
+	return nil
+}
+
+// getMessageAttachments returns the attachments of the given messages from the memory.
+func (m *memoryStore) getMessageAttachments(messageIDs []string) (map[string][]attachmentData, error) {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	result := make(map[string][]attachmentData)
+	for _, messageID := range messageIDs {
+		for _, ID := range m.messageAttachments[messageID] {
+			result[messageID] = append(result[messageID], m.attachments[ID])
+		}
+	}
+
+	return result, nil
+}
+
+// checkAttachmentAccess checks whether the given userName is the recipient, a member of the group or a member
+// of the channel of a conversation that can fetch the attachment or not.
+func (m *memoryStore) checkAttachmentAccess(ID string, userName string) (bool, error) {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	for conv := range m.attachmentAccess[ID] {
+		switch {
+		case conv.userName != "":
+			if conv.userName == userName {
+				return true, nil
+			}
+
+		case conv.group != "":
+			for _, member := range m.groupMembers[conv.group] {
+				if member.userName == userName {
+					return true, nil
+				}
+			}
+
+		case conv.channel != "":
+			if _, ok := m.channelMembers[conv.channel][userName]; ok {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// setIdentityKey replaces the identity key of the device in the memory and deletes its prekeys if the key has changed.
+func (m *memoryStore) setIdentityKey(userName string, device string, key []byte) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	userDevice := userDevice{userName: userName, device: device}
+	oldKey, ok := m.identityKeys[userDevice]
+	if ok && bytes.Equal(oldKey, key) {
+		return nil
+	}
+
+	m.identityKeys[userDevice] = append([]byte(nil), key...)
+	delete(m.prekeys, userDevice)
+	return nil
+}
+
+// insertPrekeys adds the prekeys of the device to the memory, the existing prekey IDs are ignored.
+func (m *memoryStore) insertPrekeys(userName string, device string, prekeys []prekeyData) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	userDevice := userDevice{userName: userName, device: device}
+	existing := make(map[int64]bool)
+	for _, prekey := range m.prekeys[userDevice] {
+		existing[prekey.id] = true
+	}
+
+	for _, prekey := range prekeys {
+		if existing[prekey.id] {
+			continue
+		}
+		existing[prekey.id] = true
+		prekey.key = append([]byte(nil), prekey.key...)
+		m.prekeys[userDevice] = append(m.prekeys[userDevice], prekey)
+	}
+
+	result := m.prekeys[userDevice]
+	sort.Slice(result, func(i, j int) bool { return result[i].id < result[j].id })
+	return nil
+}
+
+// countPrekeys returns the number of the prekeys of the device in the memory.
+func (m *memoryStore) countPrekeys(userName string, device string) (int, error) {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	return len(m.prekeys[userDevice{userName: userName, device: device}]), nil
+}
+
+// claimKeys returns the identity keys of the devices of the given userName with the oldest prekey of each device
+// and removes the returned prekeys from the memory.
+func (m *memoryStore) claimKeys(userName string) ([]deviceKeys, error) {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	var result []deviceKeys
+	for key, identityKey := range m.identityKeys {
+		if key.userName != userName {
+			continue
+		}
+
+		keys := deviceKeys{device: key.device, identityKey: identityKey}
+		if prekeys := m.prekeys[key]; len(prekeys) > 0 {
+			prekey := prekeys[0]
+			keys.prekey = &prekey
+			m.prekeys[key] = prekeys[1:]
+			keys.prekeysLeft = len(prekeys) - 1
+		}
+		result = append(result, keys)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].device < result[j].device })
+	return result, nil
+}
+
+// insertUser inserts a userData into the memory and prepares an empty offline messages queue for it.
+// returns errDuplicate if the userName or the public key is already existing.
+func (m *memoryStore) insertUser(user userData) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	if _, ok := m.users[user.userName]; ok {
+		return errDuplicate
+	}
+	if _, ok := m.publicKeys[string(user.publicKey)]; ok {
+		return errDuplicate
+	}
+
+	user.publicKey = append([]byte(nil), user.publicKey...)
+	m.users[user.userName] = user
+	m.publicKeys[string(user.publicKey)] = user.userName
+	m.messages[user.userName] = nil
+	return nil
+}
+
+// deleteUser deletes the user, the devices, the offline messages queue and the sessions of the user.
+func (m *memoryStore) deleteUser(userName string) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	user, ok := m.users[userName]
+	if !ok {
+		return nil
+	}
+
+	delete(m.publicKeys, string(user.publicKey))
+	delete(m.users, userName)
+	delete(m.devices, userName)
+	delete(m.messages, userName)
+	for tokenHash, session := range m.sessions {
+		if session.userName == userName {
+			delete(m.sessions, tokenHash)
+		}
+	}
+	for ID := range m.groupMembers {
+		m.removeGroupMembers(ID, []string{userName})
+		m.promoteOldestMember(ID)
+	}
+	for name := range m.channelMembers {
+		m.removeChannelMember(name, userName)
+	}
+	for ID, message := range m.sentMessages {
+		if message.sender == userName {
+			delete(m.sentMessages, ID)
+			continue
+		}
+		var recipients []string
+		for _, recipient := range message.recipients {
+			if recipient != userName {
+				recipients = append(recipients, recipient)
+			}
+		}
+		message.recipients = recipients
+		m.sentMessages[ID] = message
+	}
+	m.removeStaleMarks()
+	delete(m.receipts, userName)
+	for name, queue := range m.receipts {
+		result := queue[:0]
+		for _, queued := range queue {
+			if queued.receipt.recipient != userName {
+				result = append(result, queued)
+			}
+		}
+		m.receipts[name] = result
+	}
+	delete(m.settings, userName)
+	delete(m.lastSeen, userName)
+	for key, entries := range m.lists {
+		if key.userName == userName {
+			delete(m.lists, key)
+			continue
+		}
+		delete(entries, userName)
+	}
+	for _, access := range m.attachmentAccess {
+		delete(access, attachmentAccess{userName: userName})
+	}
+	for key := range m.identityKeys {
+		if key.userName == userName {
+			delete(m.identityKeys, key)
+		}
+	}
+	for key := range m.prekeys {
+		if key.userName == userName {
+			delete(m.prekeys, key)
+		}
+	}
+	for conv := range m.keepHistory {
+		if conv.peer1 == userName || conv.peer2 == userName {
+			delete(m.keepHistory, conv)
+		}
+	}
+	for conv := range m.history {
+		if conv.peer1 == userName || conv.peer2 == userName {
+			delete(m.history, conv)
+		}
+	}
+	return nil
+}
+
+// insertSession inserts a sessionData into the memory.
+func (m *memoryStore) insertSession(session sessionData) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	m.sessions[string(session.tokenHash)] = session
+	return nil
+}
+
+// getSession gets the hash of a session token and returns its sessionData.
+// returns nil if there is no such session.
+func (m *memoryStore) getSession(tokenHash []byte) (*sessionData, error) {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	session, ok := m.sessions[string(tokenHash)]
+	if !ok {
+		return nil, nil
+	}
+
+	return &session, nil
+}
+
+// getSessions returns the sessions of the given userName that are not expired at the given time.
+// sessions are sorted by their creation time.
+func (m *memoryStore) getSessions(userName string, now time.Time) ([]sessionData, error) {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	var result []sessionData
+	for _, session := range m.sessions {
+		if session.userName == userName && session.expiresAt.After(now) {
+			result = append(result, session)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].createdAt.Before(result[j].createdAt)
+	})
+	return result, nil
+}
+
+// deleteSession deletes the session with the given ID of the given userName.
+func (m *memoryStore) deleteSession(userName string, ID string) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	for tokenHash, session := range m.sessions {
+		if session.userName == userName && session.id == ID {
+			delete(m.sessions, tokenHash)
+		}
+	}
+	return nil
+}
+
+// deleteExpiredSessions deletes the sessions that are expired at the given time from the memory.
+func (m *memoryStore) deleteExpiredSessions(now time.Time) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	for tokenHash, session := range m.sessions {
+		if !session.expiresAt.After(now) {
+			delete(m.sessions, tokenHash)
+		}
+	}
+	return nil
+}
+
+// insertGroup inserts a groupData and its first members into the memory.
+func (m *memoryStore) insertGroup(group groupData, members []groupMember) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	if _, ok := m.groups[group.id]; ok {
+		return errDuplicate
+	}
+
+	m.groups[group.id] = group
+	m.groupMembers[group.id] = append([]groupMember(nil), members...)
+	return nil
+}
+
+// getGroup returns the group with the given ID.
+// returns nil if there is no such group.
+func (m *memoryStore) getGroup(ID string) (*groupData, error) {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	group, ok := m.groups[ID]
+	if !ok {
+		return nil, nil
+	}
+
+	return &group, nil
+}
+
+// renameGroup changes the name of the group with the given ID.
+func (m *memoryStore) renameGroup(ID string, name string) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	group, ok := m.groups[ID]
+	if !ok {
+		return nil
+	}
+
+	group.name = name
+	m.groups[ID] = group
+	return nil
+}
+
+// getGroupMembers returns a copy of the members of the group with the given ID.
+func (m *memoryStore) getGroupMembers(ID string) ([]groupMember, error) {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	members := m.groupMembers[ID]
+	if len(members) == 0 {
+		return nil, nil
+	}
+
+	result := make([]groupMember, len(members))
+	copy(result, members)
+	return result, nil
+}
+
+// insertGroupMembers appends the members to the members of the group with the given ID.
+func (m *memoryStore) insertGroupMembers(ID string, members []groupMember) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	if _, ok := m.groups[ID]; !ok {
+		return nil
+	}
+
+	m.groupMembers[ID] = append(m.groupMembers[ID], members...)
+	return nil
+}
+
+// deleteGroupMembers removes the given userNames from the members of the group with the given ID.
+// the group itself is deleted when its last member is removed.
+func (m *memoryStore) deleteGroupMembers(ID string, userNames []string) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	m.removeGroupMembers(ID, userNames)
+	return nil
+}
+
+// removeGroupMembers removes the given userNames from the members of the group with the given ID.
+// the group itself is deleted when its last member is removed.
+// the locker should be locked by the caller.
+func (m *memoryStore) removeGroupMembers(ID string, userNames []string) {
+
+	removed := make(map[string]bool, len(userNames))
+	for _, userName := range userNames {
+		removed[userName] = true
+	}
+
+	var result []groupMember
+	for _, member := range m.groupMembers[ID] {
+		if !removed[member.userName] {
+			result = append(result, member)
+		}
+	}
+
+	if len(result) == 0 {
+		delete(m.groups, ID)
+		delete(m.groupMembers, ID)
+		delete(m.keepHistory, groupConversation(ID))
+		delete(m.history, groupConversation(ID))
+		return
+	}
+	m.groupMembers[ID] = result
+}
+
+// promoteOldestMember makes the oldest member of the group with the given ID its admin if the group has no admin.
+// the locker should be locked by the caller.
+func (m *memoryStore) promoteOldestMember(ID string) {
+
+	members := m.groupMembers[ID]
+	if len(members) == 0 {
+		return
+	}
+
+	for _, member := range members {
+		if member.role == adminRole {
+			return
+		}
+	}
+	members[0].role = adminRole
+}
+
+// setGroupRole changes the role of the given userName in the group with the given ID.
+func (m *memoryStore) setGroupRole(ID string, userName string, role string) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	for i, member := range m.groupMembers[ID] {
+		if member.userName == userName {
+			m.groupMembers[ID][i].role = role
+		}
+	}
+	return nil
+}
+
+// cursorKey makes the key of the channelCursors map for the given device of the user in the channel.
+func cursorKey(name string, userName string, device string) string {
+
+	return name + "\x00" + userName + "\x00" + device
+}
+
+// insertChannel inserts a channel with its owner into the memory.
+// returns errDuplicate if the name is already taken.
+func (m *memoryStore) insertChannel(name string, owner channelMember) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	if _, ok := m.channelMembers[name]; ok {
+		return errDuplicate
+	}
+
+	m.channelMembers[name] = map[string]channelMember{owner.userName: owner}
+	return nil
+}
+
+// checkChannel checks whether the channel exists in the memory or not.
+func (m *memoryStore) checkChannel(name string) (bool, error) {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	_, ok := m.channelMembers[name]
+	return ok, nil
+}
+
+// getChannelRole returns the role of the given userName in the channel.
+// returns an empty string if the user is not subscribed.
+func (m *memoryStore) getChannelRole(name string, userName string) (string, error) {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	return m.channelMembers[name][userName].role, nil
+}
+
+// insertChannelMember adds a channelMember to the members of the channel.
+// returns errDuplicate if the user is already subscribed.
+func (m *memoryStore) insertChannelMember(name string, member channelMember) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	members, ok := m.channelMembers[name]
+	if !ok {
+		return nil
+	}
+	if _, ok := members[member.userName]; ok {
+		return errDuplicate
+	}
+
+	members[member.userName] = member
+	return nil
+}
+
+// deleteChannelMember removes the given userName and its cursors from the channel.
+func (m *memoryStore) deleteChannelMember(name string, userName string) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	m.removeChannelMember(name, userName)
+	return nil
+}
+
+// removeChannelMember removes the given userName and its cursors from the channel.
+// the locker should be locked by the caller.
+func (m *memoryStore) removeChannelMember(name string, userName string) {
+
+	delete(m.channelMembers[name], userName)
+	prefix := cursorKey(name, userName, "")
+	for key := range m.channelCursors {
+		if strings.HasPrefix(key, prefix) {
+			delete(m.channelCursors, key)
+		}
+	}
+}
+
+// setChannelRole changes the role of the given userName in the channel.
+func (m *memoryStore) setChannelRole(name string, userName string, role string) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	member, ok := m.channelMembers[name][userName]
+	if !ok {
+		return nil
+	}
+
+	member.role = role
+	m.channelMembers[name][userName] = member
+	return nil
+}
+
+// getChannelSubscribers returns the userNames of all the members of the channel.
+func (m *memoryStore) getChannelSubscribers(name string) ([]string, error) {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	var result []string
+	for userName := range m.channelMembers[name] {
+		result = append(result, userName)
+	}
+	return result, nil
+}
+
+// getUserChannels returns the names of the channels that the given userName is subscribed to.
+func (m *memoryStore) getUserChannels(userName string) ([]string, error) {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	var result []string
+	for name, members := range m.channelMembers {
+		if _, ok := members[userName]; ok {
+			result = append(result, name)
+		}
+	}
+
+	sort.Strings(result)
+	return result, nil
+}
+
+// insertPost appends a post to the posts of the channel.
+func (m *memoryStore) insertPost(name string, post messageData) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	post.channel = name
+	m.channelPosts[name] = append(m.channelPosts[name], post)
+	return nil
+}
+
+// getMissedPosts returns the last posts of the channel that are after both the subscription of the user and the cursor
+// of the device, at most limit of them, the user's own posts are not included.
+func (m *memoryStore) getMissedPosts(name string, userName string, device string, limit int) ([]messageData, error) {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	member, ok := m.channelMembers[name][userName]
+	if !ok {
+		return nil, nil
+	}
+
+	cursor := member.joinedID
+	if last := m.channelCursors[cursorKey(name, userName, device)]; last > cursor {
+		cursor = last
+	}
+
+	var result []messageData
+	for _, post := range m.channelPosts[name] {
+		if post.id > cursor && post.sender != userName {
+			result = append(result, post)
+		}
+	}
+
+	if len(result) > limit {
+		result = result[len(result)-limit:]
+	}
+	return append([]messageData(nil), result...), nil
+}
+
+// ackPost moves the cursor of the given device of the user to the post with the given ID.
+// the cursor never moves back, and nothing happens if the ID is not a post of a channel that the user is subscribed to.
+func (m *memoryStore) ackPost(userName string, device string, ID string) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	for name, posts := range m.channelPosts {
+		if _, ok := m.channelMembers[name][userName]; !ok {
+			continue
+		}
+
+		for _, post := range posts {
+			if post.id != ID {
+				continue
+			}
+
+			key := cursorKey(name, userName, device)
+			if m.channelCursors[key] < ID {
+				m.channelCursors[key] = ID
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// setKeepHistory turns the history of the conversation on or off in the memory.
+func (m *memoryStore) setKeepHistory(conv conversation, enabled bool) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	if enabled {
+		m.keepHistory[conv] = true
+	} else {
+		delete(m.keepHistory, conv)
+	}
+	return nil
+}
+
+// checkKeepHistory checks whether the history of the conversation is turned on in the memory or not.
+func (m *memoryStore) checkKeepHistory(conv conversation) (bool, error) {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	return m.keepHistory[conv], nil
+}
+
+// insertHistory inserts a messageData into the history of the conversation in the memory.
+// the history is kept sorted by the message IDs.
+func (m *memoryStore) insertHistory(conv conversation, message messageData) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	messages := m.history[conv]
+	i := sort.Search(len(messages), func(i int) bool { return messages[i].message.id > message.id })
+	messages = append(messages, historyMessage{})
+	copy(messages[i+1:], messages[i:])
+	messages[i] = historyMessage{storedAt: time.Now().UTC(), message: message}
+	m.history[conv] = messages
+	return nil
+}
+
+// getHistory returns at most limit messages of the conversation from the memory before or after the given IDs.
+func (m *memoryStore) getHistory(conv conversation, before string, after string, limit int) ([]messageData, error) {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	messages := m.history[conv]
+	switch {
+	case after != "":
+		i := sort.Search(len(messages), func(i int) bool { return messages[i].message.id > after })
+		messages = messages[i:]
+		if len(messages) > limit {
+			messages = messages[:limit]
+		}
+	case before != "":
+		i := sort.Search(len(messages), func(i int) bool { return messages[i].message.id >= before })
+		messages = messages[:i]
+		fallthrough
+	default:
+		if len(messages) > limit {
+			messages = messages[len(messages)-limit:]
+		}
+	}
+
+	var result []messageData
+	for _, message := range messages {
+		result = append(result, message.message)
+	}
+	return result, nil
+}
+
+// searchHistory searches the history of the memory for the messages of the conversations that the userName belongs to.
+// the terms are matched with hasWordPrefix, so every term should be the start of a word of the message.
+func (m *memoryStore) searchHistory(userName string, filter searchFilter, limit int) ([]searchMatch, error) {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+
+	isMember := make(map[string]bool)
+	for ID, members := range m.groupMembers {
+		for _, member := range members {
+			if member.userName == userName {
+				isMember[ID] = true
+			}
+		}
+	}
+
+	var result []searchMatch
+	for conv, messages := range m.history {
+		if conv.peer1 != userName && conv.peer2 != userName && !isMember[conv.group] {
+			continue
+		}
+
+		for _, message := range messages {
+			if matchFilter(message.message, filter) {
+				result = append(result, searchMatch{conv: conv, message: message.message})
+			}
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].message.id > result[j].message.id })
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+// matchFilter checks whether the message matches the searchFilter or not, every term should start a word of the text.
+func matchFilter(message messageData, filter searchFilter) bool {
+
+	if filter.sender != "" && message.sender != filter.sender {
+		return false
+	}
+	if !filter.from.IsZero() && message.timeStamp.Before(filter.from) {
+		return false
+	}
+	if !filter.to.IsZero() && !message.timeStamp.Before(filter.to) {
+		return false
+	}
+	if filter.before != "" && message.id >= filter.before {
+		return false
+	}
+
+	words := splitWords(message.text)
+	for _, term := range filter.terms {
+		if !hasWordPrefix(words, term) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasWordPrefix checks whether any of the words starts with the term or not, just like the full-text indexes do.
+func hasWordPrefix(words []string, term string) bool {
+
+	for _, word := range words {
+		if strings.HasPrefix(word, term) {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteOldHistory deletes the history messages of the memory that have been stored before the given time.
+func (m *memoryStore) deleteOldHistory(before time.Time) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	for conv, messages := range m.history {
+		var result []historyMessage
+		for _, message := range messages {
+			if !message.storedAt.Before(before) {
+				result = append(result, message)
+			}
+		}
+		if len(result) == 0 {
+			delete(m.history, conv)
+			continue
+		}
+		m.history[conv] = result
+	}
+	return nil
+}
+
+// changeIP changes the ip of the given userName by the given ip.
+func (m *memoryStore) changeIP(userName string, ip string) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	user, ok := m.users[userName]
+	if !ok {
+		return errNoSuchUser
+	}
+
+	user.ip = ip
+	m.users[userName] = user
+	return nil
+}
+
+// ping always succeeds because memory is always there.
+func (m *memoryStore) ping() error {
+
+	return nil
+}
+
+// close does nothing because there is nothing to release.
+func (m *memoryStore) close() error {
+
+	return nil
+}
@@ -1,46 +1,284 @@
 package main
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
+
+// protocolVersion is the version of the frames envelope that server speaks.
+const protocolVersion = 1
+
+// these are the types of the frames that server and clients send to each other.
+// authFrame is the frame that clients send at the very beginning of messaging and deletion connections.
+// challengeFrame is the frame that server sends in answer to the auth frame and it contains a random nonce.
+// proofFrame is the frame that clients send in answer to the challenge frame to prove that they hold their secret.
+// sessionFrame is the frame that server sends after a successful challenge and it contains the new session token.
+// listSessionsFrame is the frame that clients send to get their active sessions.
+// sessionsFrame is the frame that server uses to answer the listSessions frame.
+// revokeSessionFrame is the frame that clients send to revoke one of their sessions.
+// listDevicesFrame is the frame that clients send to get their devices.
+// devicesFrame is the frame that server uses to answer the listDevices frame.
+// retireDeviceFrame is the frame that clients send to retire one of their devices.
+// registerFrame is the frame that clients send at the very beginning of registration connections.
+// messageFrame is the frame that clients use to send messages and server uses to deliver them.
+// ackFrame is the frame that clients send after handling a delivered message.
+// resultFrame is the frame that server uses to report what happened to a sent message for every recipient.
+// createGroupFrame is the frame that clients send to create a new group.
+// renameGroupFrame is the frame that group admins send to change the name of a group.
+// addMembersFrame is the frame that group admins send to add users to a group.
+// removeMembersFrame is the frame that group admins send to remove members from a group.
+// setRoleFrame is the frame that group admins send to change the role of a member.
+// leaveGroupFrame is the frame that group members send to leave a group.
+// listMembersFrame is the frame that group members send to get the members of a group.
+// groupFrame is the frame that server uses to answer the createGroup and listMembers frames.
+// createChannelFrame is the frame that clients send to create a new channel, the creator becomes its owner.
+// subscribeFrame is the frame that clients send to subscribe to a channel.
+// unsubscribeFrame is the frame that clients send to unsubscribe from a channel.
+// setChannelRoleFrame is the frame that channel owners send to make a subscriber admin or to take it back.
+// setHistoryFrame is the frame that clients send to turn the history of a conversation on or off.
+// historyFrame is the frame that clients send to get a page of the history of a conversation.
+// historyPageFrame is the frame that server uses to answer the history frame.
+// searchFrame is the frame that clients send to search the history of their conversations.
+// searchResultsFrame is the frame that server uses to answer the search frame.
+// editFrame is the frame that senders use to change the text of their messages and server uses to forward the change.
+// unsendFrame is the frame that senders use to take back their messages and server uses to forward it.
+// readFrame is the frame that clients send after the user has read a message.
+// receiptFrame is the frame that server uses to tell the senders that their message has been delivered or read.
+// getSettingsFrame is the frame that clients send to get their settings.
+// setSettingsFrame is the frame that clients send to change their settings.
+// settingsFrame is the frame that server uses to answer the getSettings and setSettings frames.
+// typingFrame is the frame that clients send when the user starts or stops typing and server uses to forward it.
+// getPresenceFrame is the frame that clients send to get the online status and the last seen time of users.
+// addContactsFrame is the frame that clients send to add users to their contacts.
+// removeContactsFrame is the frame that clients send to remove users from their contacts.
+// getContactsFrame is the frame that clients send to get their contacts.
+// contactsFrame is the frame that server uses to answer the getContacts frames.
+// blockFrame is the frame that clients send to block users.
+// unblockFrame is the frame that clients send to unblock users.
+// getBlockedFrame is the frame that clients send to get the users that they have blocked.
+// blockedFrame is the frame that server uses to answer the getBlocked frames.
+// publishKeysFrame is the frame that devices send to publish their identity key and one-time prekeys.
+// prekeyCountFrame is the frame that server uses to tell a device how many of its prekeys are left.
+// getKeysFrame is the frame that clients send to get the keys of the devices of a user for encrypting messages to it.
+// keysFrame is the frame that server uses to answer the getKeys frames.
+// responseFrame is the frame that server uses to send responses.
+// presenceFrame is the frame that server uses to send online and offline status of users.
+// errorFrame is the frame that server uses to report a frame that it couldn't handle.
+const (
+	authFrame           = "auth"
+	challengeFrame      = "challenge"
+	proofFrame          = "proof"
+	sessionFrame        = "session"
+	listSessionsFrame   = "listSessions"
+	sessionsFrame       = "sessions"
+	revokeSessionFrame  = "revokeSession"
+	listDevicesFrame    = "listDevices"
+	devicesFrame        = "devices"
+	retireDeviceFrame   = "retireDevice"
+	registerFrame       = "register"
+	messageFrame        = "message"
+	ackFrame            = "ack"
+	resultFrame         = "result"
+	createGroupFrame    = "createGroup"
+	renameGroupFrame    = "renameGroup"
+	addMembersFrame     = "addMembers"
+	removeMembersFrame  = "removeMembers"
+	setRoleFrame        = "setRole"
+	leaveGroupFrame     = "leaveGroup"
+	listMembersFrame    = "listMembers"
+	groupFrame          = "group"
+	createChannelFrame  = "createChannel"
+	subscribeFrame      = "subscribe"
+	unsubscribeFrame    = "unsubscribe"
+	setChannelRoleFrame = "setChannelRole"
+	setHistoryFrame     = "setHistory"
+	historyFrame        = "history"
+	historyPageFrame    = "historyPage"
+	searchFrame         = "search"
+	searchResultsFrame  = "searchResults"
+	editFrame           = "edit"
+	unsendFrame         = "unsend"
+	readFrame           = "read"
+	receiptFrame        = "receipt"
+	getSettingsFrame    = "getSettings"
+	setSettingsFrame    = "setSettings"
+	settingsFrame       = "settings"
+	typingFrame         = "typing"
+	getPresenceFrame    = "getPresence"
+	addContactsFrame    = "addContacts"
+	removeContactsFrame = "removeContacts"
+	getContactsFrame    = "getContacts"
+	contactsFrame       = "contacts"
+	blockFrame          = "block"
+	unblockFrame        = "unblock"
+	getBlockedFrame     = "getBlocked"
+	blockedFrame        = "blocked"
+	publishKeysFrame    = "publishKeys"
+	prekeyCountFrame    = "prekeyCount"
+	getKeysFrame        = "getKeys"
+	keysFrame           = "keys"
+	responseFrame       = "response"
+	presenceFrame       = "presence"
+	errorFrame          = "error"
+)
 
 // these are the flags that we use to send server responses.
 // the values contains three digits of the flag name.
-// received is the flag that server uses to response to clients saying that we processed your message successfully.
 // approved is the flag that server uses to response to clients saying that your operation processed successfully.
 // invalidUserName is the flag that server uses to response to clients saying that username is not valid to be save in database.
-// noSuchUser is the flag that server uses to response to clients saying that the username you want to send message to it, is not existing in database.
-// alreadyReg is the flag that server uses to response to clients saying that the ClientID is already existing in database.
+// alreadyReg is the flag that server uses to response to clients saying that the public key is already existing in database.
 // invalidAuth is the flag that server uses to response to clients saying that authentication is not valid.
+// notMember is the flag that server uses to response to clients saying that they are not a member of the group.
+// notAdmin is the flag that server uses to response to clients saying that only the group or channel admins can do that.
+// nameTaken is the flag that server uses to response to clients saying that the channel name is already taken.
+// unknownChannel is the flag that server uses to response to clients saying that the channel is not existing.
+// isOwner is the flag that server uses to response to clients saying that the channel owner can't do that.
+// unknownMessage is the flag that server uses to response to clients saying that the message is not existing or can't be changed anymore.
+// notSender is the flag that server uses to response to clients saying that only the sender of the message can do that.
+// unknownAttachment is the flag that server uses to response to clients saying that an attachment is not existing
+// or they can't fetch it.
+const (
+	approved          = "APV"
+	invalidUserName   = "IUN"
+	alreadyReg        = "ART"
+	invalidAuth       = "IAT"
+	notMember         = "NMB"
+	notAdmin          = "NAD"
+	nameTaken         = "NTK"
+	unknownChannel    = "UCH"
+	isOwner           = "OWN"
+	unknownMessage    = "UMS"
+	notSender         = "NSD"
+	unknownAttachment = "UAT"
+)
+
+// these are the roles of the group members.
+// adminRole is the role of the members who can rename the group and manage its members, the creator is the first admin.
+// memberRole is the role of the members who can only send messages, list the members and leave.
+// ownerRole is the role of the channel creator who can post and make other subscribers admin.
+// adminRole is also used in channels for the subscribers who can post.
+// subscriberRole is the role of the channel subscribers who can only read the posts.
+const (
+	adminRole      = "admin"
+	memberRole     = "member"
+	ownerRole      = "owner"
+	subscriberRole = "subscriber"
+)
+
+// these are the statuses of the recipients that server reports in result frames.
+// deliveredStatus means that the message has been sent to the online recipient.
+// queuedStatus means that the recipient is offline and the message is kept for the next connect.
+// unknownUserStatus means that the recipient is not existing.
+// blockedStatus means that the recipient doesn't accept messages from the sender, because it has blocked the sender
+// or it only accepts direct messages from its contacts.
+// noDeviceStatus means that the end-to-end encrypted message has no payload for any device of the recipient,
+// so nothing is kept for it and the sender should get the keys of the recipient again.
+// readStatus is only used in the receipts and means that the recipient has read the message.
+const (
+	deliveredStatus   = "delivered"
+	queuedStatus      = "queued"
+	unknownUserStatus = "unknownUser"
+	blockedStatus     = "blocked"
+	noDeviceStatus    = "noDevice"
+	readStatus        = "read"
+)
+
+// these are the states of the typing frames.
+// typingStart means that the user is typing and clients should send it again before the typing state expires.
+// typingStop means that the user has stopped typing.
+const (
+	typingStart = "start"
+	typingStop  = "stop"
+)
+
+// these are the flags that we use to send server errors.
+// unsupportedVersion is the flag that server uses to say that the frame's protocol version is newer than server's.
+// unsupportedFrame is the flag that server uses to say that it doesn't know the frame's type.
+// invalidFrame is the flag that server uses to say that the frame's payload is not valid.
+// rateLimited is the flag that server uses to say that the client has sent too many frames of the type and should wait.
 const (
-	received        = "RCV"
-	approved        = "APV"
-	invalidUserName = "IUN"
-	noSuchUser      = "NSU"
-	alreadyReg      = "ART"
-	invalidAuth     = "IAT"
+	unsupportedVersion = "UPV"
+	unsupportedFrame   = "UFT"
+	invalidFrame       = "IFR"
+	rateLimited        = "RLM"
 )
 
-// response is the json struct that we use to send server responses.
+// envelope is the json struct that every frame is wrapped in, in both directions.
+// Version is the protocol version of the frame.
+// Type is the type of the frame and receivers dispatch frames on it.
+// receivers should ignore the types that they don't know, so new frame types don't break old clients.
+// ID is the ID of the message that the frame is about and it's empty if the frame is not about a message.
+// Payload is the frame's json struct which depends on the Type.
+type envelope struct {
+	Version int             `json:"v"`
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// response is the json struct that we use as payload of the response frames.
 // Value can be either one of the above flags.
 type response struct {
 	Value string `json:"value"`
 }
 
+// errorPayload is the json struct that we use as payload of the error frames.
+// Value can be either one of the above error flags.
+// Type is the type of the frame that caused the error.
+type errorPayload struct {
+	Value string `json:"value"`
+	Type  string `json:"type"`
+}
+
+// these are the methods that clients should use to answer challenges.
+// ed25519Method means that the proof is the Ed25519 signature of the nonce followed by the userName.
+// hmacMethod means that the proof is the HMAC-SHA256 of the nonce followed by the userName, keyed with the ClientID.
+// it's only for the old accounts that haven't enrolled a public key yet.
+const (
+	ed25519Method = "ed25519"
+	hmacMethod    = "hmac"
+)
+
 // authentication is the json struct that clients should send at the very beginning of connection.
-// ClientID is the unique identifier that we use for 2FA and ... .
+// clients don't send their secret, they prove that they hold it by answering a challenge.
 // UserName is the unique identifier that we use to detect different users from each other.
+// Token is the optional session token that server has issued before, and clients that send it skip the challenge.
+// Device is the ID of the client's device, every device of a user gets its own copy of the messages.
+// clients that don't send it are treated as the defaultDevice.
 type authentication struct {
-	ClientID []byte `json:"ClientID"`
 	UserName string `json:"userName"`
+	Token    string `json:"token,omitempty"`
+	Device   string `json:"device,omitempty"`
+}
+
+// defaultDevice is the device ID of the clients that don't send any.
+const defaultDevice = "default"
+
+// challenge is the json struct that we use as payload of the challenge frames.
+// Nonce is the random bytes that client should compute its proof for.
+// Method can be either one of the above methods and tells the client how to compute the proof.
+type challenge struct {
+	Nonce  []byte `json:"nonce"`
+	Method string `json:"method"`
+}
+
+// proof is the json struct that clients should use as payload of the proof frames.
+// Proof is the answer of the challenge that is computed by the challenge's Method.
+// PublicKey is the Ed25519 public key that the old accounts have to send with their hmac proof to enroll it.
+// after enrolling, the account has to use the ed25519 method and its ClientID is removed.
+type proof struct {
+	Proof     []byte `json:"proof"`
+	PublicKey []byte `json:"publicKey,omitempty"`
 }
 
 // registration is the json struct that clients should use for sending info to register an account.
-// ClientID is the unique identifier that we use for 2FA and ... .
+// PublicKey is the Ed25519 public key that user proves its identity with, and the private key never leaves the client.
 // UserName is the unique identifier that we use to detect different users from each other.
 // Name is the optional name that user can choose for profile.
 type registration struct {
-	ClientID []byte `json:"ClientID"`
-	UserName string `json:"userName"`
-	Name     string `json:"name"`
+	PublicKey []byte `json:"publicKey"`
+	UserName  string `json:"userName"`
+	Name      string `json:"name"`
 }
 
 // clientSendMessage is the json struct that clients should use for sending their messages.
@@ -48,19 +286,415 @@ type registration struct {
 // TimeStamp is the time that user has sent the message.
 // Text is user's text message.
 // To is a slice containing usernames of whom the sender want to send this message to.
+// Group is the ID of the group that the sender want to send this message to, it's used instead of To.
+// Channel is the name of the channel that the sender want to post this message to, it's used instead of To.
+// Attachments are the IDs of the uploaded attachments of the message and Text can be empty if it has any.
+// Payloads are the end-to-end encrypted payloads of the message for every device of the recipients.
+// the encrypted messages can only be sent To users and they should have no Text and no Attachments.
 type clientSendMessage struct {
-	TimeStamp time.Time `json:"timeStamp"`
-	Text      string    `json:"text"`
-	To        []string  `json:"To"`
+	TimeStamp   time.Time       `json:"timeStamp"`
+	Text        string          `json:"text"`
+	To          []string        `json:"To,omitempty"`
+	Group       string          `json:"group,omitempty"`
+	Channel     string          `json:"channel,omitempty"`
+	Attachments []string        `json:"attachments,omitempty"`
+	Payloads    []devicePayload `json:"payloads,omitempty"`
+}
+
+// devicePayload is the json struct that clients should use for an end-to-end encrypted payload of a message.
+// UserName is the recipient.
+// Device is the ID of the recipient's device that the payload is encrypted for.
+// Ciphertext is the encrypted payload and server never looks into it.
+type devicePayload struct {
+	UserName   string `json:"userName"`
+	Device     string `json:"device"`
+	Ciphertext []byte `json:"ciphertext"`
 }
 
 // clientReceiveMessage is the json struct that server uses to send clients messages to clients.
 // clients should get message in this json format.
+// clients should send an ack frame with the message ID after handling every clientReceiveMessage.
+// server keeps the message until it gets the ack, and delivers it again on the next connect if it didn't.
+// clients may get a message more than once, so they should ignore the IDs that they have already handled.
+// ID is the unique ID that server has given to the message.
 // TimeStamp is the time that the sender has sent the message.
 // Text is the sender's text message.
 // Sender is the sender's 'userName' that has sent the message.
+// Group is the ID of the group that the message has been sent to and it's empty for the direct messages.
+// Channel is the name of the channel that the message has been posted to and it's empty for the other messages.
+// Attachments are the attachments of the message that clients can download from the attachments endpoint.
+// Payload is the end-to-end encrypted payload of the message for the device that gets it and Text is empty if it's set.
 type clientReceiveMessage struct {
-	TimeStamp time.Time `json:"timeStamp"`
-	Text      string    `json:"text"`
-	Sender    string    `json:"sender"`
+	ID          string       `json:"id"`
+	TimeStamp   time.Time    `json:"timeStamp"`
+	Text        string       `json:"text"`
+	Sender      string       `json:"sender"`
+	Group       string       `json:"group,omitempty"`
+	Channel     string       `json:"channel,omitempty"`
+	Attachments []attachment `json:"attachments,omitempty"`
+	Payload     []byte       `json:"payload,omitempty"`
+}
+
+// recipientResult is the json struct that server uses to report what happened to a sent message for a single recipient.
+// UserName is the recipient's userName.
+// Status can be either one of the above recipient statuses.
+type recipientResult struct {
+	UserName string `json:"userName"`
+	Status   string `json:"status"`
+}
+
+// sendResult is the json struct that we use as payload of the result frames.
+// MessageID is the unique ID that server has given to the message.
+// Recipients is a slice containing the result of every recipient in the order of the message's To,
+// or in the order of the group members for the group messages, and it's empty for the channel posts.
+type sendResult struct {
+	MessageID  string            `json:"messageID"`
+	Recipients []recipientResult `json:"recipients"`
+}
+
+// sessionToken is the json struct that we use as payload of the session frames.
+// ID is the ID of the session that clients can use to revoke it.
+// Token is the secret that clients should send in their next auth frames instead of answering a challenge.
+// ExpiresAt is the time that the session will be expired after it.
+type sessionToken struct {
+	ID        string    `json:"id"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// sessionInfo is the json struct that server uses to describe an active session.
+// ID is the ID of the session.
+// CreatedAt is the time that the session has been issued.
+// ExpiresAt is the time that the session will be expired after it.
+type sessionInfo struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// sessionsList is the json struct that we use as payload of the sessions frames.
+// Sessions is a slice containing the active sessions of the user.
+type sessionsList struct {
+	Sessions []sessionInfo `json:"sessions"`
+}
+
+// revokeSession is the json struct that clients should use as payload of the revokeSession frames.
+// ID is the ID of the session that should be revoked.
+type revokeSession struct {
+	ID string `json:"id"`
+}
+
+// devicesList is the json struct that we use as payload of the devices frames.
+// Devices is a slice containing the device IDs of the user.
+type devicesList struct {
+	Devices []string `json:"devices"`
+}
+
+// retireDevice is the json struct that clients should use as payload of the retireDevice frames.
+// Device is the ID of the device that should be retired.
+type retireDevice struct {
+	Device string `json:"device"`
+}
+
+// createGroup is the json struct that clients should use as payload of the createGroup frames.
+// Name is the name of the new group.
+// Members is a slice containing the userNames that should be added to the group beside the creator.
+type createGroup struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+}
+
+// renameGroup is the json struct that clients should use as payload of the renameGroup frames.
+// Group is the ID of the group.
+// Name is the new name of the group.
+type renameGroup struct {
+	Group string `json:"group"`
+	Name  string `json:"name"`
+}
+
+// groupMembers is the json struct that clients should use as payload of the addMembers and removeMembers frames.
+// Group is the ID of the group.
+// Members is a slice containing the userNames that should be added or removed.
+type groupMembers struct {
+	Group   string   `json:"group"`
+	Members []string `json:"members"`
+}
+
+// setRole is the json struct that clients should use as payload of the setRole frames.
+// Group is the ID of the group.
+// UserName is the member whose role should be changed.
+// Role can be either one of the above roles.
+type setRole struct {
+	Group    string `json:"group"`
+	UserName string `json:"userName"`
+	Role     string `json:"role"`
+}
+
+// groupRef is the json struct that clients should use as payload of the leaveGroup and listMembers frames.
+// Group is the ID of the group.
+type groupRef struct {
+	Group string `json:"group"`
+}
+
+// memberInfo is the json struct that server uses to describe a group member.
+// UserName is the member's userName.
+// Role can be either one of the above roles.
+type memberInfo struct {
+	UserName string `json:"userName"`
+	Role     string `json:"role"`
+}
+
+// groupInfo is the json struct that we use as payload of the group frames.
+// ID is the ID of the group that clients should use to send messages to the group.
+// Name is the name of the group.
+// Members is a slice containing the members of the group in the order that they have joined.
+type groupInfo struct {
+	ID      string       `json:"id"`
+	Name    string       `json:"name"`
+	Members []memberInfo `json:"members"`
+}
+
+// channelRef is the json struct that clients should use as payload of the createChannel, subscribe and unsubscribe frames.
+// Channel is the name of the channel.
+type channelRef struct {
+	Channel string `json:"channel"`
+}
+
+// setChannelRole is the json struct that clients should use as payload of the setChannelRole frames.
+// Channel is the name of the channel.
+// UserName is the subscriber whose role should be changed.
+// Role can be either adminRole or subscriberRole.
+type setChannelRole struct {
+	Channel  string `json:"channel"`
+	UserName string `json:"userName"`
+	Role     string `json:"role"`
+}
+
+// historySetting is the json struct that clients should use as payload of the setHistory frames.
+// With is the userName of the other side of a direct conversation.
+// Group is the ID of the group, it's used instead of With and only the group admins can change its history.
+// Enabled turns the history of the conversation on or off, turning it off doesn't delete what is already kept.
+type historySetting struct {
+	With    string `json:"with,omitempty"`
+	Group   string `json:"group,omitempty"`
+	Enabled bool   `json:"enabled"`
+}
+
+// historyRequest is the json struct that clients should use as payload of the history frames.
+// With is the userName of the other side of a direct conversation.
+// Group is the ID of the group, it's used instead of With.
+// Before is the optional message ID that the page should end just before it.
+// After is the optional message ID that the page should start just after it, it can't be used with Before.
+// the last messages are returned if none of Before and After is set.
+// Limit is the maximum number of messages of the page, it's defaultHistoryLimit if it's zero.
+type historyRequest struct {
+	With   string `json:"with,omitempty"`
+	Group  string `json:"group,omitempty"`
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+	Limit  int    `json:"limit,omitempty"`
+}
+
+// historyPage is the json struct that we use as payload of the historyPage frames.
+// Messages is a slice containing the messages of the page in the order that they have been sent.
+// clients should use the ID of the first one as Before or the last one as After to get the next page.
+// HasMore is True if there are more messages beyond the page in the asked direction.
+type historyPage struct {
+	Messages []clientReceiveMessage `json:"messages"`
+	HasMore  bool                   `json:"hasMore"`
+}
+
+// searchRequest is the json struct that clients should use as payload of the search frames.
+// Query is the keywords that the messages should contain all of them, every keyword matches the start of a word.
+// Sender is the optional userName that the messages should be sent by.
+// From and To are the optional time range that the messages should be sent in, To itself is not in the range.
+// at least one of Query, Sender, From and To should be set.
+// Before is the optional message ID that the results should be older than it, clients should use the ID of the
+// last result to get the next page.
+// Limit is the maximum number of results, it's defaultSearchLimit if it's zero.
+type searchRequest struct {
+	Query  string    `json:"query,omitempty"`
+	Sender string    `json:"sender,omitempty"`
+	From   time.Time `json:"from,omitempty"`
+	To     time.Time `json:"to,omitempty"`
+	Before string    `json:"before,omitempty"`
+	Limit  int       `json:"limit,omitempty"`
+}
+
+// searchResult is the json struct that server uses to describe a message that has matched a search.
+// With is the userName of the other side of the direct conversation that the message has been found in,
+// and it's empty for the group messages which have their Group set.
+// Message is the message itself.
+type searchResult struct {
+	With    string               `json:"with,omitempty"`
+	Message clientReceiveMessage `json:"message"`
+}
+
+// searchResults is the json struct that we use as payload of the searchResults frames.
+// Results is a slice containing the matched messages, the newest ones first.
+// HasMore is True if there are more older results beyond the page.
+type searchResults struct {
+	Results []searchResult `json:"results"`
+	HasMore bool           `json:"hasMore"`
+}
+
+// messageEdit is the json struct that senders should use as payload of the edit frames, the frame's ID is the message ID.
+// Text is the new text of the message.
+type messageEdit struct {
+	Text string `json:"text"`
+}
+
+// messageChange is the json struct that server uses as payload of the forwarded edit and unsend frames.
+// ID is the ID of the changed message.
+// Sender is the sender's 'userName' that has changed the message.
+// Group is the ID of the group that the message has been sent to and it's empty for the direct messages.
+// Text is the new text of the edited message and it's empty for the unsent messages.
+type messageChange struct {
+	ID     string `json:"id"`
+	Sender string `json:"sender"`
+	Group  string `json:"group,omitempty"`
+	Text   string `json:"text,omitempty"`
+}
+
+// receipt is the json struct that we use as payload of the receipt frames, the frame's ID is the message ID.
+// ID is the ID of the message that the receipt is about.
+// UserName is the recipient who has got or read the message.
+// Status is either deliveredStatus or readStatus.
+// At is the time that the message has been delivered or read.
+type receipt struct {
+	ID       string    `json:"id"`
+	UserName string    `json:"userName"`
+	Status   string    `json:"status"`
+	At       time.Time `json:"at"`
+}
+
+// settings is the json struct that we use as payload of the settings frames.
+// ReadReceipts is the option that lets the senders know when the user reads their messages.
+// HidePresence is the option that hides the online status and the last seen time of the user from the others.
+// ContactsOnly is the option that rejects the direct messages of the users that are not in the user's contacts.
+type settings struct {
+	ReadReceipts bool `json:"readReceipts"`
+	HidePresence bool `json:"hidePresence"`
+	ContactsOnly bool `json:"contactsOnly"`
+}
+
+// settingsUpdate is the json struct that clients should use as payload of the setSettings frames.
+// every field is the same as the settings field and the ones that are not sent are not changed.
+type settingsUpdate struct {
+	ReadReceipts *bool `json:"readReceipts,omitempty"`
+	HidePresence *bool `json:"hidePresence,omitempty"`
+	ContactsOnly *bool `json:"contactsOnly,omitempty"`
+}
+
+// typing is the json struct that clients should use as payload of the typing frames.
+// To is the userName of the user that the sender is typing to.
+// Group is the ID of the group that the sender is typing in, it's used instead of To.
+// State is either typingStart or typingStop.
+type typing struct {
+	To    string `json:"to,omitempty"`
+	Group string `json:"group,omitempty"`
+	State string `json:"state"`
+}
+
+// typingState is the json struct that server uses as payload of the forwarded typing frames.
+// UserName is the user who is typing.
+// Group is the ID of the group that the user is typing in and it's empty for the direct conversations.
+// State is either typingStart or typingStop.
+// ExpiresIn is the number of seconds that clients should show the typing state for, if no other typing frame comes.
+type typingState struct {
+	UserName  string `json:"userName"`
+	Group     string `json:"group,omitempty"`
+	State     string `json:"state"`
+	ExpiresIn int    `json:"expiresIn,omitempty"`
+}
+
+// presenceQuery is the json struct that clients should use as payload of the getPresence frames.
+// UserNames is the list of the users that client wants to know their presence.
+type presenceQuery struct {
+	UserNames []string `json:"userNames"`
+}
+
+// presenceStatus is the json struct that we use to describe the presence of a user.
+// UserName is the user that the presence is about.
+// Online is True if any device of the user is online.
+// LastSeen is the last time that the user has been online and it's empty if the user is online, has hidden it
+// or has never been seen.
+type presenceStatus struct {
+	UserName string     `json:"userName"`
+	Online   bool       `json:"online"`
+	LastSeen *time.Time `json:"lastSeen,omitempty"`
+}
+
+// presences is the json struct that we use as payload of the presence frames.
+// it's used both for answering the getPresence frames and for the online and offline events.
+// Users is the list of the presence of users.
+type presences struct {
+	Users []presenceStatus `json:"users"`
+}
+
+// userNames is the json struct that we use as payload of the contacts and blocks frames.
+// clients use it to add or remove users and server uses it to answer the getContacts and getBlocked frames.
+// UserNames is the list of the users.
+type userNames struct {
+	UserNames []string `json:"userNames"`
+}
+
+// attachment is the json struct that server uses to describe an attachment.
+// it's the body of the upload response and is sent in the messages that have attachments.
+// ID is the ID of the attachment that clients use to download it and to attach it to their messages.
+// MimeType is the MIME type of the attachment.
+// Size is the size of the attachment in bytes.
+// Checksum is the hex encoded SHA-256 of the attachment, so clients can check what they download.
+type attachment struct {
+	ID       string `json:"id"`
+	MimeType string `json:"mimeType"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"`
+}
+
+// prekey is the json struct that we use for a one-time prekey.
+// ID is the ID that the device has given to the prekey, and the sender tells it to the recipient with the message.
+// Key is the public key.
+type prekey struct {
+	ID  int64  `json:"id"`
+	Key []byte `json:"key"`
+}
+
+// publishKeys is the json struct that devices should use as payload of the publishKeys frames.
+// IdentityKey is the public identity key of the device and it's not changed if it's not sent.
+// publishing a different identity key deletes the prekeys of the old one.
+// Prekeys are the one-time prekeys that are added to the prekeys of the device.
+type publishKeys struct {
+	IdentityKey []byte   `json:"identityKey,omitempty"`
+	Prekeys     []prekey `json:"prekeys,omitempty"`
+}
+
+// prekeyCount is the json struct that we use as payload of the prekeyCount frames.
+// Count is the number of the prekeys of the device that are left.
+type prekeyCount struct {
+	Count int `json:"count"`
+}
+
+// keysRequest is the json struct that clients should use as payload of the getKeys frames.
+// UserName is the user that client wants to send encrypted messages to.
+type keysRequest struct {
+	UserName string `json:"userName"`
+}
+
+// deviceKey is the json struct that we use for the keys of a device.
+// Device is the device ID that the payload for this device should be sent with.
+// IdentityKey is the public identity key of the device.
+// Prekey is a one-time prekey of the device that is never given out again and it's empty if the device has no prekey left.
+type deviceKey struct {
+	Device      string  `json:"device"`
+	IdentityKey []byte  `json:"identityKey"`
+	Prekey      *prekey `json:"prekey,omitempty"`
+}
+
+// userKeys is the json struct that we use as payload of the keys frames.
+// UserName is the user that the keys belong to.
+// Devices are the keys of the devices of the user that have published an identity key.
+type userKeys struct {
+	UserName string      `json:"userName"`
+	Devices  []deviceKey `json:"devices"`
 }
@@ -4,28 +4,34 @@ import (
 	"encoding/json"
 	"golang.org/x/net/websocket"
 	"strings"
+	"sync"
+	"time"
 )
 
 // messenger is a controller pointer method that handles messaging process.
+// every user can be connected from several devices at the same time, but every device only once.
 // it gets a websocket connection pointer and uses it as incoming user.
 func (c *controller) messenger(conn *websocket.Conn) {
 
-	userName := c.checkAuthentication(conn)
-	if userName == "" || c.checkIsClientOnline(userName) {
+	userName, device := c.checkAuthentication(conn)
+	if userName == "" || !c.addOnlineClient(conn, userName, device) {
 		_ = conn.Close()
 		return
 	}
 
-	c.addOnlineClient(conn, userName)
-
 	defer func() {
 		if r := recover(); r != nil {
 			logError(r.(errScope).scope, r.(errScope).err)
-			c.removeAndCloseOnlineClient(userName)
+			c.removeAndCloseOnlineClient(userName, device)
 		}
 	}()
 
-	err := responseSender(conn, approved)
+	err := c.dbConn.insertDevice(userName, device)
+	if err != nil {
+		panic(errScope{scope: "messenger-insertDevice", err: err})
+	}
+
+	err = responseSender(conn, approved)
 	if err != nil {
 		panic(errScope{scope: "messenger-responseSender", err: err})
 	}
@@ -36,175 +42,611 @@ func (c *controller) messenger(conn *websocket.Conn) {
 		panic(errScope{scope: "messenger-changeIP", err: err})
 	}
 
-	messages := c.checkUnseenMessages(userName)
-	if messages != nil {
+	// unseen messages stay in the database until the device acknowledges them.
+	// so the ones that device doesn't acknowledge will be delivered again on its next connect.
+	// the missed channel posts are delivered after them, and the receipts that have been queued for the device at last.
+	messages := append(c.checkUnseenMessages(userName, device), c.checkMissedPosts(userName, device)...)
+	err = c.loadAttachments(messages)
+	if err != nil {
+		panic(errScope{scope: "messenger-loadAttachments", err: err})
+	}
+	go func() {
 		for _, message := range messages {
-			err = c.dbConn.deleteMessage("tbl_"+userName, message)
-			if err != nil {
-				panic(errScope{scope: "messenger-deleteMessage", err: err})
+			received, _ := deviceMessage(message, device)
+			delivered := c.deliverToDevice(userName, device, conn, received)
+			if delivered && message.channel == "" {
+				err := c.sendReceipt(message.id, userName, deliveredStatus)
+				if err != nil {
+					logError("messenger-sendReceipt", err)
+					c.removeAndCloseOnlineClient(userName, device)
+					return
+				}
 			}
-
-			go func(message messageData) {
-				c.deliverMessage(userName, clientReceiveMessage{
-					TimeStamp: message.timeStamp,
-					Text:      message.text,
-					Sender:    message.sender,
-				})
-			}(message)
 		}
-	}
 
-	c.runReceiver(conn, userName)
+		c.deliverReceipts(userName, device, conn)
+	}()
+
+	c.runReceiver(conn, userName, device)
 }
 
 // runReceiver runs a websocket Receiver on the given conn.
 // it gets a websocket connection pointer to listen and receive.
-// it gets a userName which is the clients authorized userName.
-func (c *controller) runReceiver(conn *websocket.Conn, userName string) {
+// it gets a userName which is the clients authorized userName and the device ID of the connection.
+func (c *controller) runReceiver(conn *websocket.Conn, userName string, device string) {
 
+	typingLimiter := newRateLimiter(typingRate, typingBurst)
+	keysLimiter := newRateLimiter(keysRate, keysBurst)
 	for {
-		var data []byte
-		err := websocket.Message.Receive(conn, &data)
+		frame, err := frameReceiver(conn)
 		if err != nil {
-			if c.checkIsClientOnline(userName) {
-				logError("runReceiver-Receive", err)
-				c.removeAndCloseOnlineClient(userName)
+			if c.checkIsDeviceOnline(userName, device) {
+				logError("runReceiver-frameReceiver", err)
+				c.removeAndCloseOnlineClient(userName, device)
 			}
 			return
 		}
-		var message clientSendMessage
-		err = json.Unmarshal(data, &message)
-		if err != nil {
-			logError("runReceiver-Unmarshal", err)
-			c.removeAndCloseOnlineClient(userName)
-			return
+
+		if frame.Version > protocolVersion {
+			frameErrorSender(conn, unsupportedVersion, frame)
+			continue
 		}
 
-		go c.messageHandler(message, conn, userName)
+		switch frame.Type {
+		case messageFrame:
+			go c.messageHandler(frame, conn, userName, device)
+
+		case ackFrame:
+			go c.ackHandler(frame.ID, userName, device)
+
+		case createGroupFrame:
+			go c.createGroupHandler(frame, conn, userName, device)
+
+		case renameGroupFrame:
+			go c.renameGroupHandler(frame, conn, userName, device)
+
+		case addMembersFrame:
+			go c.addMembersHandler(frame, conn, userName, device)
+
+		case removeMembersFrame:
+			go c.removeMembersHandler(frame, conn, userName, device)
+
+		case setRoleFrame:
+			go c.setRoleHandler(frame, conn, userName, device)
+
+		case leaveGroupFrame:
+			go c.leaveGroupHandler(frame, conn, userName, device)
+
+		case listMembersFrame:
+			go c.listMembersHandler(frame, conn, userName, device)
+
+		case createChannelFrame:
+			go c.createChannelHandler(frame, conn, userName, device)
+
+		case subscribeFrame:
+			go c.subscribeHandler(frame, conn, userName, device)
+
+		case unsubscribeFrame:
+			go c.unsubscribeHandler(frame, conn, userName, device)
+
+		case setChannelRoleFrame:
+			go c.setChannelRoleHandler(frame, conn, userName, device)
+
+		case setHistoryFrame:
+			go c.setHistoryHandler(frame, conn, userName, device)
+
+		case historyFrame:
+			go c.historyHandler(frame, conn, userName, device)
+
+		case searchFrame:
+			go c.searchHandler(frame, conn, userName, device)
+
+		case editFrame:
+			go c.editHandler(frame, conn, userName, device)
+
+		case unsendFrame:
+			go c.unsendHandler(frame, conn, userName, device)
+
+		case readFrame:
+			go c.readHandler(frame.ID, userName, device)
+
+		case getSettingsFrame:
+			go c.getSettingsHandler(frame, conn, userName, device)
+
+		case setSettingsFrame:
+			go c.setSettingsHandler(frame, conn, userName, device)
+
+		case getPresenceFrame:
+			go c.presenceHandler(frame, conn, userName, device)
+
+		case addContactsFrame:
+			go c.addToListHandler(frame, conn, userName, device, contactsList)
+
+		case removeContactsFrame:
+			go c.removeFromListHandler(frame, conn, userName, device, contactsList)
+
+		case getContactsFrame:
+			go c.getListHandler(frame, conn, userName, device, contactsList)
+
+		case blockFrame:
+			go c.addToListHandler(frame, conn, userName, device, blockedList)
+
+		case unblockFrame:
+			go c.removeFromListHandler(frame, conn, userName, device, blockedList)
+
+		case getBlockedFrame:
+			go c.getListHandler(frame, conn, userName, device, blockedList)
+
+		case publishKeysFrame:
+			go c.publishKeysHandler(frame, conn, userName, device)
+
+		case getKeysFrame:
+			// every answer gives out prekeys, so the getKeys frames that go over the limit are rejected.
+			if !keysLimiter.allow(time.Now()) {
+				frameErrorSender(conn, rateLimited, frame)
+				continue
+			}
+			go c.getKeysHandler(frame, conn, userName, device)
+
+		case typingFrame:
+			// the typing frames that go over the limit are dropped silently, because the next ones will fix the state.
+			if typingLimiter.allow(time.Now()) {
+				go c.typingHandler(frame, conn, userName, device)
+			}
+
+		default:
+			frameErrorSender(conn, unsupportedFrame, frame)
+		}
+	}
+}
+
+// payloadDecoder decodes the payload of the frame into v.
+// if the payload is not valid it sends an invalidFrame error to the client.
+// it gets the frame, v as the payload's struct pointer and the websocket connection of the client.
+// it returns True if decoding went alright and False if not.
+func payloadDecoder(frame envelope, v interface{}, conn *websocket.Conn) bool {
+
+	err := json.Unmarshal(frame.Payload, v)
+	if err == nil {
+		return true
+	}
+
+	frameErrorSender(conn, invalidFrame, frame)
+	return false
+}
+
+// frameErrorSender sends an error about the frame to the client.
+// it closes the connection if sending went wrong, so the receiver of the connection stops and removes the client.
+// it gets the websocket connection, the error flag and the frame.
+func frameErrorSender(conn *websocket.Conn, flag string, frame envelope) {
+
+	err := errorSender(conn, flag, frame)
+	if err != nil {
+		logError("frameErrorSender-errorSender", err)
+		_ = conn.Close()
 	}
 }
 
 // messageValidator validates a clientSendMessage in terms of data appearance.
-// it gets a clientSendMessage pointer for space trimming so the value will be change globally.
+// it gets a clientSendMessage pointer for space trimming and removing repeated recipients so the value will be change globally.
+// a message should have exactly one of recipients, a group or a channel.
 // it returns True if everything wend alright and False if not.
 func messageValidator(message *clientSendMessage) bool {
 
-	if message.TimeStamp.String() == "" || message.To == nil {
+	targets := 0
+	if message.To != nil {
+		targets++
+	}
+	if message.Group != "" {
+		targets++
+	}
+	if message.Channel != "" {
+		targets++
+	}
+
+	if message.TimeStamp.String() == "" || targets != 1 {
 		return false
 	}
 
+	if message.Group != "" && len(message.Group) != idLength {
+		return false
+	}
+
+	if message.Channel != "" && !validateChannelName(message.Channel) {
+		return false
+	}
+
+	seen := make(map[string]bool)
+	to := message.To[:0]
 	for _, user := range message.To {
 		if user == "" {
 			return false
 		}
+		if seen[user] {
+			continue
+		}
+		seen[user] = true
+		to = append(to, user)
 	}
+	message.To = to
+
+	if len(message.Attachments) > maxMessageAttachments {
+		return false
+	}
+	seen = make(map[string]bool)
+	attachments := message.Attachments[:0]
+	for _, ID := range message.Attachments {
+		if !validateID(ID) {
+			return false
+		}
+		if seen[ID] {
+			continue
+		}
+		seen[ID] = true
+		attachments = append(attachments, ID)
+	}
+	message.Attachments = attachments
 
 	message.Text = strings.TrimSpace(message.Text)
-	if message.Text == "" {
+	if len(message.Payloads) > 0 {
+		return validatePayloads(message)
+	}
+	if message.Text == "" && len(message.Attachments) == 0 {
 		return false
 	}
 
 	return true
 }
 
-// messageHandler is a controller pointer method that handles every single clientSendMessage that runReceiver receives.
-// it gets a clientSendMessage fro processing.
+// messageHandler is a controller pointer method that handles every single message frame that runReceiver receives.
+// it sends a result frame back to the sender with the status of every recipient.
+// the result frame has the same ID as the message frame, so clients can use their own IDs to match them.
+// it gets the message frame for processing.
 // it gets a websocket connection pointer as the user who has sent the message.
-// it gets the userName of the incoming websocket connection
-func (c *controller) messageHandler(message clientSendMessage, conn *websocket.Conn, userName string) {
+// it gets the userName and the device ID of the incoming websocket connection
+func (c *controller) messageHandler(frame envelope, conn *websocket.Conn, userName string, device string) {
+
+	var message clientSendMessage
+	if !payloadDecoder(frame, &message, conn) {
+		return
+	}
 
 	if !messageValidator(&message) {
+		frameErrorSender(conn, invalidFrame, frame)
+		return
+	}
+
+	attachments, canSend, err := c.checkAttachments(message.Attachments, userName)
+	if err != nil {
+		logError("messageHandler-checkAttachments", err)
+		c.removeAndCloseOnlineClient(userName, device)
+		return
+	}
+	if !canSend {
+		err = frameSender(conn, responseFrame, frame.ID, response{Value: unknownAttachment})
+		if err != nil {
+			logError("messageHandler-frameSender", err)
+			c.removeAndCloseOnlineClient(userName, device)
+		}
+		return
+	}
+
+	if message.Channel != "" {
+		c.postHandler(frame, message, attachments, conn, userName, device)
 		return
 	}
 
 	for _, user := range message.To {
 		if user == userName {
-			c.removeAndCloseOnlineClient(userName)
+			c.removeAndCloseOnlineClient(userName, device)
 			return
 		}
 	}
 
-	for _, user := range message.To {
+	recipients := message.To
+	if message.Group != "" {
+		var isMember bool
+		recipients, isMember, err = c.groupRecipients(message.Group, userName)
+		if err != nil {
+			logError("messageHandler-groupRecipients", err)
+			c.removeAndCloseOnlineClient(userName, device)
+			return
+		}
+		if !isMember {
+			err = frameSender(conn, responseFrame, frame.ID, response{Value: notMember})
+			if err != nil {
+				logError("messageHandler-frameSender", err)
+				c.removeAndCloseOnlineClient(userName, device)
+			}
+			return
+		}
+	}
+
+	data := messageData{
+		id:          newID(),
+		timeStamp:   message.TimeStamp,
+		text:        message.Text,
+		sender:      userName,
+		group:       message.Group,
+		attachments: attachments,
+	}
+	results := make([]recipientResult, len(recipients))
+	errs := make([]error, len(recipients))
+	sent := sentMessage{id: data.id, sender: userName, group: data.group, sentAt: time.Now().UTC()}
+	var payloads map[string]map[string][]byte
+	if len(message.Payloads) > 0 {
+		payloads = devicePayloads(message.Payloads)
+		sent.encrypted = true
+	}
+	var wg sync.WaitGroup
+
+	for i, user := range recipients {
+		results[i].UserName = user
+
 		isClientExist, err := c.dbConn.checkClientUserName(user)
 		if err != nil {
 			logError("messageHandler-checkClientUserName", err)
-			c.removeAndCloseOnlineClient(userName)
+			c.removeAndCloseOnlineClient(userName, device)
 			return
 		}
 		if !isClientExist {
-			if c.checkIsClientOnline(userName) {
-				err = responseSender(conn, noSuchUser)
-				if err != nil {
-					logError("messageHandler-responseSender", err)
-					c.removeAndCloseOnlineClient(userName)
-				}
-			}
+			results[i].Status = unknownUserStatus
+			continue
+		}
 
+		accepted, err := c.acceptsFrom(user, userName, message.Group == "")
+		if err != nil {
+			logError("messageHandler-acceptsFrom", err)
+			c.removeAndCloseOnlineClient(userName, device)
+			return
+		}
+		if !accepted {
+			results[i].Status = blockedStatus
 			continue
 		}
+		sent.recipients = append(sent.recipients, user)
+	}
 
-		go func(user string) {
-			if c.checkIsClientOnline(user) {
-				c.deliverMessage(user, clientReceiveMessage{
-					TimeStamp: message.TimeStamp,
-					Text:      message.Text,
-					Sender:    userName,
-				})
-
-			} else {
-				err := c.dbConn.insertMessage("tbl_"+user, messageData{
-					timeStamp: message.TimeStamp,
-					text:      message.Text,
-					sender:    userName,
-				})
-				if err != nil {
-					logError("messageHandler-insertMessage", err)
-					c.removeAndCloseOnlineClient(userName)
-				}
+	// the sent message is kept before dispatching, so the receipts of the recipients can find it.
+	err = c.dbConn.insertSentMessage(sent)
+	if err != nil {
+		logError("messageHandler-insertSentMessage", err)
+		c.removeAndCloseOnlineClient(userName, device)
+		return
+	}
+
+	// the attachments are linked before dispatching too, so the recipients can fetch them as soon as they get the message.
+	if len(attachments) > 0 {
+		access := []attachmentAccess{{group: message.Group}}
+		if message.Group == "" {
+			access = access[:0]
+			for _, recipient := range sent.recipients {
+				access = append(access, attachmentAccess{userName: recipient})
 			}
-		}(user)
+		}
 
-		if c.checkIsClientOnline(userName) {
-			err = responseSender(conn, received)
-			if err != nil {
-				logError("messageHandler-responseSender", err)
-				c.removeAndCloseOnlineClient(userName)
+		err = c.dbConn.linkAttachments(data.id, message.Attachments, access)
+		if err != nil {
+			logError("messageHandler-linkAttachments", err)
+			c.removeAndCloseOnlineClient(userName, device)
+			return
+		}
+	}
+
+	for i, user := range recipients {
+		if results[i].Status != "" {
+			continue
+		}
+
+		// every recipient of an encrypted message only gets the payloads of its own devices.
+		recipientData := data
+		if payloads != nil {
+			recipientData.payloads = payloads[user]
+		}
+
+		wg.Add(1)
+		go func(i int, user string) {
+			defer wg.Done()
+			results[i].Status, errs[i] = c.dispatchMessage(user, recipientData)
+		}(i, user)
+	}
+
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			logError("messageHandler-dispatchMessage", err)
+			c.removeAndCloseOnlineClient(userName, device)
+			return
+		}
+	}
+
+	// the encrypted messages are never kept in the history, because server can't read them.
+	if !sent.encrypted {
+		err = c.keepHistory(data, userName, results)
+		if err != nil {
+			logError("messageHandler-keepHistory", err)
+			c.removeAndCloseOnlineClient(userName, device)
+			return
+		}
+	}
+
+	if c.checkIsDeviceOnline(userName, device) {
+		err := frameSender(conn, resultFrame, frame.ID, sendResult{
+			MessageID:  data.id,
+			Recipients: results,
+		})
+		if err != nil {
+			logError("messageHandler-frameSender", err)
+			c.removeAndCloseOnlineClient(userName, device)
+			return
+		}
+	}
+
+	// the delivered receipts are sent after the result frame, so the sender knows the message ID when it gets them.
+	for _, result := range results {
+		if result.Status != deliveredStatus {
+			continue
+		}
+
+		err := c.sendReceipt(data.id, result.UserName, deliveredStatus)
+		if err != nil {
+			logError("messageHandler-sendReceipt", err)
+			c.removeAndCloseOnlineClient(userName, device)
+			return
+		}
+	}
+}
+
+// groupRecipients is a controller pointer method that returns the members of the group beside the sender.
+// it gets the ID of the group and the userName of the sender.
+// it returns the recipients and True if the sender is a member of the group and False if not.
+// returns error if something went wrong.
+func (c *controller) groupRecipients(groupID string, userName string) ([]string, bool, error) {
+
+	members, err := c.dbConn.getGroupMembers(groupID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	isMember := false
+	recipients := []string{}
+	for _, member := range members {
+		if member.userName == userName {
+			isMember = true
+			continue
+		}
+		recipients = append(recipients, member.userName)
+	}
+
+	return recipients, isMember, nil
+}
+
+// dispatchMessage is a controller pointer method that stores a message for the user and delivers it to the online devices of the user.
+// every message is kept in the database until every device of the receiver acknowledges it, even if the receiver is online.
+// it gets the userName of the recipient and the message.
+// the end-to-end encrypted messages that have no payload for any device of the user are not stored at all.
+// it returns the recipient status of the message and error if storing went wrong.
+func (c *controller) dispatchMessage(userName string, message messageData) (string, error) {
+
+	if message.payloads != nil {
+		devices, err := c.dbConn.getDevices(userName)
+		if err != nil {
+			return "", err
+		}
+
+		hasPayload := false
+		for _, device := range devices {
+			if _, ok := message.payloads[device]; ok {
+				hasPayload = true
+				break
 			}
 		}
+		if !hasPayload {
+			return noDeviceStatus, nil
+		}
 	}
+
+	err := c.dbConn.insertMessage(userName, message)
+	if err != nil {
+		return "", err
+	}
+
+	if c.deliverMessage(userName, message) {
+		return deliveredStatus, nil
+	}
+
+	return queuedStatus, nil
 }
 
-// deliverMessage is a controller pointer method that delivers a clientReceiveMessage to the userName.
-// it gets a websocket connection pointer and a userName as the users info for sending the message to.
-func (c *controller) deliverMessage(userName string, message clientReceiveMessage) {
+// ackHandler is a controller pointer method that handles every single ack frame that runReceiver receives.
+// it deletes the acknowledged message from the unseen messages of the device, the other devices still get it.
+// if the ID is a channel post, the channel cursor of the device is moved to it.
+// it gets the ID of the acknowledged message and the userName and the device ID of the user who has sent the ack.
+func (c *controller) ackHandler(ID string, userName string, device string) {
 
-	err := websocket.JSON.Send(c.getWebsocketConnection(userName), message)
+	err := c.dbConn.deleteMessage(userName, device, ID)
 	if err != nil {
-		err := c.dbConn.insertMessage("tbl_"+userName, messageData{
-			timeStamp: message.TimeStamp,
-			text:      message.Text,
-			sender:    message.Sender,
-		})
+		logError("ackHandler-deleteMessage", err)
+		c.removeAndCloseOnlineClient(userName, device)
+		return
+	}
+
+	err = c.dbConn.ackPost(userName, device, ID)
+	if err != nil {
+		logError("ackHandler-ackPost", err)
+		c.removeAndCloseOnlineClient(userName, device)
+	}
+}
+
+// deliverMessage is a controller pointer method that delivers a message to every online device of the userName.
+// it gets a userName as the users info for sending the message to.
+// the message is already in the database, so the devices that it fails for will get it on their next connect.
+// the devices that an encrypted message has no payload for don't get it.
+// it returns True if the message has been sent to at least one device and False if not.
+func (c *controller) deliverMessage(userName string, message messageData) bool {
+
+	delivered := false
+	for device, conn := range c.getWebsocketConnections(userName) {
+		received, ok := deviceMessage(message, device)
+		if !ok {
+			continue
+		}
+		if c.deliverToDevice(userName, device, conn, received) {
+			delivered = true
+		}
+	}
+
+	return delivered
+}
+
+// notifyUser is a controller pointer method that sends a frame to every online device of the userName.
+// it removes the devices that sending goes wrong for from the online clients.
+// it gets the userName, the type, the ID and the payload of the frame.
+// it returns True if the frame has been sent to at least one device and False if not.
+func (c *controller) notifyUser(userName string, frameType string, ID string, payload interface{}) bool {
+
+	sent := false
+	for device, conn := range c.getWebsocketConnections(userName) {
+		err := frameSender(conn, frameType, ID, payload)
 		if err != nil {
-			logError("deliverMessage-insertMessage", err)
+			c.removeAndCloseOnlineClient(userName, device)
+
+			logError("notifyUser", err)
+			continue
 		}
+		sent = true
+	}
 
-		c.removeAndCloseOnlineClient(userName)
+	return sent
+}
 
-		logError("deliverMessage", err)
+// deliverToDevice is a controller pointer method that delivers a clientReceiveMessage to a single device of the userName.
+// it removes the device from the online clients if sending went wrong.
+// it gets the userName, the device ID and the websocket connection of the device.
+// it returns True if the message has been sent and False if not.
+func (c *controller) deliverToDevice(userName string, device string, conn *websocket.Conn, message clientReceiveMessage) bool {
+
+	err := frameSender(conn, messageFrame, message.ID, message)
+	if err != nil {
+		c.removeAndCloseOnlineClient(userName, device)
+
+		logError("deliverToDevice", err)
+		return false
 	}
+
+	return true
 }
 
-// checkUnseenMessages is a controller pointer method that checks whether userName has unseen messages.
-// it gets a userName as user info to check.
+// checkUnseenMessages is a controller pointer method that checks whether a device of userName has unseen messages.
+// it gets a userName and a device ID as user info to check.
 // it returns a slice of messageData as the unseen messages.
 // returns nil if there is no unseen message in the database.
-func (c *controller) checkUnseenMessages(userName string) []messageData {
+func (c *controller) checkUnseenMessages(userName string, device string) []messageData {
 
-	messages, err := c.dbConn.getMessages("tbl_" + userName)
+	messages, err := c.dbConn.getMessages(userName, device)
 	if err != nil {
 		logError("checkUnseenMessages", err)
-		c.removeAndCloseOnlineClient(userName)
+		c.removeAndCloseOnlineClient(userName, device)
 	}
 
 	return messages
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// errNoMigrations is the error that we return when the storage backend doesn't have schema migrations.
+var errNoMigrations = errors.New("storage backend has no schema migrations")
+
+// migration is the struct that keeps a single versioned change of the database schema.
+// version is the unique and increasing number of the migration and migrations run in the order of it.
+// name is a short description of the migration.
+// up is the func that applies the migration in the given transaction.
+// down is the func that reverts the migration in the given transaction and it's nil if the migration can't be reverted.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *sql.Tx) error
+	down    func(tx *sql.Tx) error
+}
+
+// migrationState is the struct that we use to report the status of a migration.
+// appliedAt is the time that the migration has been applied and it's zero if the migration is pending.
+type migrationState struct {
+	version   int
+	name      string
+	appliedAt time.Time
+}
+
+// migrator is the interface of the storage backends that have a versioned schema.
+type migrator interface {
+
+	// migrateUp applies all the pending migrations and returns the number of applied ones.
+	migrateUp() (int, error)
+
+	// migrateDown reverts the last applied migration and returns it.
+	migrateDown() (migration, error)
+
+	// migrationStatus returns the state of every known migration.
+	migrationStatus() ([]migrationState, error)
+}
+
+// execStatements returns a migration func that executes the given statements one by one.
+func execStatements(statements ...string) func(tx *sql.Tx) error {
+
+	return func(tx *sql.Tx) error {
+		for _, statement := range statements {
+			_, err := tx.Exec(statement)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// prepareMigrationsTable creates the schema_migrations table that records the applied migrations.
+// returns error if something went wrong.
+func (dbConn sqlStore) prepareMigrationsTable() error {
+
+	_, err := dbConn.db.Exec("CREATE TABLE IF NOT EXISTS schema_migrations" +
+		" (version INT NOT NULL PRIMARY KEY," +
+		" name VARCHAR(100) NOT NULL," +
+		" appliedAt DATETIME NOT NULL)")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// appliedMigrations returns the applied migrations as a map and key of the map is the migration version.
+// returns error if something went wrong.
+func (dbConn sqlStore) appliedMigrations() (map[int]time.Time, error) {
+
+	err := dbConn.prepareMigrationsTable()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := dbConn.db.Query("SELECT version, appliedAt FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	result := make(map[int]time.Time)
+
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		err := rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, err
+		}
+		result[version] = appliedAt
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// migrateUp applies all the pending migrations in the order of their versions.
+// every migration runs in its own transaction together with its schema_migrations record,
+// but mysql commits every DDL statement on its own, so its migrations are written to be run again if they fail halfway.
+// returns the number of applied migrations and error if one of them went wrong.
+func (dbConn sqlStore) migrateUp() (int, error) {
+
+	applied, err := dbConn.appliedMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, m := range dbConn.dialect.migrations() {
+		if _, ok := applied[m.version]; ok {
+			continue
+		}
+
+		tx, err := dbConn.db.Begin()
+		if err != nil {
+			return count, err
+		}
+
+		err = m.up(tx)
+		if err != nil {
+			_ = tx.Rollback() //this will return error so we have to handle it
+			return count, err
+		}
+
+		_, err = tx.Exec("INSERT INTO schema_migrations (version, name, appliedAt) VALUES (?, ?, ?)",
+			m.version, m.name, time.Now().UTC())
+		if err != nil {
+			_ = tx.Rollback() //this will return error so we have to handle it
+			return count, err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// migrateDown reverts the last applied migration and removes its schema_migrations record.
+// returns the reverted migration and error if nothing is applied, the migration can't be reverted or something went wrong.
+func (dbConn sqlStore) migrateDown() (migration, error) {
+
+	applied, err := dbConn.appliedMigrations()
+	if err != nil {
+		return migration{}, err
+	}
+
+	migrations := dbConn.dialect.migrations()
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.version]; !ok {
+			continue
+		}
+		if m.down == nil {
+			return m, errors.New("migration " + m.name + " can't be reverted")
+		}
+
+		tx, err := dbConn.db.Begin()
+		if err != nil {
+			return m, err
+		}
+
+		err = m.down(tx)
+		if err != nil {
+			_ = tx.Rollback() //this will return error so we have to handle it
+			return m, err
+		}
+
+		_, err = tx.Exec("DELETE FROM schema_migrations WHERE version = ?", m.version)
+		if err != nil {
+			_ = tx.Rollback() //this will return error so we have to handle it
+			return m, err
+		}
+
+		return m, tx.Commit()
+	}
+
+	return migration{}, errors.New("there is no applied migration")
+}
+
+// migrationStatus returns the state of every migration of the dialect in the order of their versions.
+// returns error if something went wrong.
+func (dbConn sqlStore) migrationStatus() ([]migrationState, error) {
+
+	applied, err := dbConn.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []migrationState
+	for _, m := range dbConn.dialect.migrations() {
+		result = append(result, migrationState{
+			version:   m.version,
+			name:      m.name,
+			appliedAt: applied[m.version],
+		})
+	}
+
+	return result, nil
+}
+
+// moveLegacyTables returns a migration func that moves the offline messages of the old per user 'tbl_<userName>' tables
+// into the messages table and drops the old tables.
+// it gets the dialect for listing and quoting the tables.
+func moveLegacyTables(dialect sqlDialect) func(tx *sql.Tx) error {
+
+	return func(tx *sql.Tx) error {
+		tables, err := queryStrings(tx, dialect.listTables())
+		if err != nil {
+			return err
+		}
+		users, err := queryStrings(tx, "SELECT userName FROM tbl_users")
+		if err != nil {
+			return err
+		}
+
+		isTable := make(map[string]bool)
+		for _, table := range tables {
+			isTable[table] = true
+		}
+
+		for _, userName := range users {
+			table := "tbl_" + userName
+			if !isTable[table] {
+				continue
+			}
+
+			_, err = tx.Exec("INSERT INTO messages (recipient, timeStamp, text, sender)"+
+				" SELECT ?, timeStamp, text, sender FROM "+dialect.quote(table), userName)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Exec("DROP TABLE " + dialect.quote(table))
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// fillMessageIDs returns a migration func that gives a new ID to every message that doesn't have one yet.
+func fillMessageIDs() func(tx *sql.Tx) error {
+
+	return func(tx *sql.Tx) error {
+		rows, err := queryStrings(tx, "SELECT CAST(id AS CHAR) FROM messages WHERE messageID = ''")
+		if err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			_, err = tx.Exec("UPDATE messages SET messageID = ? WHERE id = ?", newID(), row)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
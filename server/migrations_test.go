@@ -0,0 +1,52 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLiteMigrations(t *testing.T) {
+
+	dbConn, err := createSQLiteConnection(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = dbConn.close() }()
+
+	total := len(dbConn.dialect.migrations())
+	count, err := dbConn.migrateUp()
+	if err != nil || count != total {
+		t.Fatalf("migrateUp: got %d %v, want %d applied migrations", count, err, total)
+	}
+	count, err = dbConn.migrateUp()
+	if err != nil || count != 0 {
+		t.Fatalf("repeated migrateUp: got %d %v, want nothing to apply", count, err)
+	}
+
+	states, err := dbConn.migrationStatus()
+	if err != nil || len(states) != total {
+		t.Fatalf("migrationStatus: got %d states %v, want %d", len(states), err, total)
+	}
+	for _, state := range states {
+		if state.appliedAt.IsZero() {
+			t.Fatalf("migration %d should be applied", state.version)
+		}
+	}
+
+	// the migrations are reverted one by one until one of them can't be reverted or nothing is left.
+	reverted := 0
+	for {
+		m, err := dbConn.migrateDown()
+		if err != nil {
+			break
+		}
+		if m.version != states[total-1-reverted].version {
+			t.Fatalf("migrateDown reverted %d, want %d", m.version, states[total-1-reverted].version)
+		}
+		reverted++
+	}
+	count, err = dbConn.migrateUp()
+	if err != nil || count != reverted {
+		t.Fatalf("migrateUp after migrateDown: got %d %v, want %d applied migrations", count, err, reverted)
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"database/sql"
+	"github.com/go-sql-driver/mysql"
+	"regexp"
+	"strings"
+)
+
+// duplicateErr is the error code of mysql for inserting duplicate value to the database.
+// this error can be produced when you have 'UNIQUE' fields.
+const duplicateErr = 1062
+
+// appliedSchemaErrs are the error codes of mysql for the DDL statements whose change is already made,
+// they are creating an existing table, column or index and dropping a missing one.
+var appliedSchemaErrs = map[uint16]bool{1050: true, 1051: true, 1060: true, 1061: true, 1091: true}
+
+// mysqlMinTokenSize is the default innodb_ft_min_token_size, the length of the shortest words that innodb indexes.
+const mysqlMinTokenSize = 3
+
+// mysqlDialect is the sqlDialect of mysql databases.
+type mysqlDialect struct{}
+
+// createDBConnection inits a new mysql connection and returns it via a sqlStore pointer.
+// And returns error if the init went wrong.
+// it also pings the mysql server to ensure that the server is alive and responding.
+// connString is connection string to the mysql server.
+func createDBConnection(connString string) (*sqlStore, error) {
+
+	dbConn, err := sql.Open("mysql", connString)
+	if err != nil {
+		return nil, err
+	}
+
+	if dbConn.Ping() != nil {
+		return nil, nil
+	}
+
+	return &sqlStore{db: dbConn, dialect: mysqlDialect{}}, nil
+}
+
+// isDuplicate checks whether the err is the mysql duplicate entry error.
+func (mysqlDialect) isDuplicate(err error) bool {
+
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == duplicateErr
+}
+
+// quote quotes the given table name with backticks.
+func (mysqlDialect) quote(table string) string {
+
+	return "`" + strings.ReplaceAll(table, "`", "``") + "`"
+}
+
+// listTables returns the mysql query that lists the tables of the current database.
+func (mysqlDialect) listTables() string {
+
+	return "SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE()"
+}
+
+// execDDL returns a migration func that executes the given DDL statements one by one
+// and skips the ones whose change is already made.
+// mysql commits every DDL statement on its own, so a migration that has failed halfway is partly applied
+// and skipping makes running it again finish the rest of it.
+func execDDL(statements ...string) func(tx *sql.Tx) error {
+
+	return func(tx *sql.Tx) error {
+		for _, statement := range statements {
+			_, err := tx.Exec(statement)
+			mysqlErr, ok := err.(*mysql.MySQLError)
+			if ok && appliedSchemaErrs[mysqlErr.Number] {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// migrations returns the schema migrations of mysql.
+// the first migrations use 'IF NOT EXISTS' so they also adopt the databases that have been created by hand before.
+// every statement runs with execDDL, so the migrations can be run again after failing halfway.
+func (d mysqlDialect) migrations() []migration {
+
+	return []migration{
+		{
+			version: 1,
+			name:    "create users table",
+			up: execDDL("CREATE TABLE IF NOT EXISTS tbl_users" +
+				" (userName VARCHAR(50) NOT NULL PRIMARY KEY," +
+				" ClientID BINARY(20) NOT NULL UNIQUE," +
+				" name VARCHAR(50) NOT NULL," +
+				" ip VARCHAR(45) NOT NULL)"),
+			down: execDDL("DROP TABLE tbl_users"),
+		},
+		{
+			version: 2,
+			name:    "create messages table",
+			up: func(tx *sql.Tx) error {
+				err := execDDL("CREATE TABLE IF NOT EXISTS messages" +
+					" (id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY," +
+					" recipient VARCHAR(50) NOT NULL," +
+					" timeStamp DATETIME NOT NULL," +
+					" text TEXT NOT NULL," +
+					" sender VARCHAR(50) NOT NULL," +
+					" INDEX idx_messages_recipient (recipient, id))")(tx)
+				if err != nil {
+					return err
+				}
+
+				return moveLegacyTables(d)(tx)
+			},
+		},
+		{
+			version: 3,
+			name:    "add message IDs",
+			up: func(tx *sql.Tx) error {
+				err := execDDL("ALTER TABLE messages" +
+					" ADD COLUMN messageID CHAR(32) NOT NULL DEFAULT '' AFTER id")(tx)
+				if err != nil {
+					return err
+				}
+
+				err = fillMessageIDs()(tx)
+				if err != nil {
+					return err
+				}
+
+				return execDDL("CREATE UNIQUE INDEX idx_messages_messageID ON messages (recipient, messageID)")(tx)
+			},
+			down: execDDL("DROP INDEX idx_messages_messageID ON messages",
+				"ALTER TABLE messages DROP COLUMN messageID"),
+		},
+		{
+			// the accounts that have enrolled a public key don't have a ClientID anymore, so this one can't be reverted.
+			version: 4,
+			name:    "add users public keys",
+			up: execDDL("ALTER TABLE tbl_users" +
+				" MODIFY ClientID BINARY(20) NULL," +
+				" ADD COLUMN publicKey BINARY(32) NULL UNIQUE AFTER ClientID"),
+		},
+		{
+			version: 5,
+			name:    "create sessions table",
+			up: execDDL("CREATE TABLE sessions" +
+				" (sessionID CHAR(32) NOT NULL PRIMARY KEY," +
+				" userName VARCHAR(50) NOT NULL," +
+				" tokenHash BINARY(32) NOT NULL UNIQUE," +
+				" createdAt DATETIME NOT NULL," +
+				" expiresAt DATETIME NOT NULL," +
+				" INDEX idx_sessions_userName (userName))"),
+			down: execDDL("DROP TABLE sessions"),
+		},
+		{
+			// the queued messages are copied for every device after this one, so it can't be reverted.
+			version: 6,
+			name:    "add devices",
+			up: execDDL("CREATE TABLE devices"+
+				" (userName VARCHAR(50) NOT NULL,"+
+				" deviceID VARCHAR(50) NOT NULL,"+
+				" PRIMARY KEY (userName, deviceID))",
+				"ALTER TABLE messages ADD COLUMN device VARCHAR(50) NOT NULL DEFAULT '' AFTER recipient",
+				"DROP INDEX idx_messages_messageID ON messages",
+				"CREATE UNIQUE INDEX idx_messages_messageID ON messages (recipient, device, messageID)"),
+		},
+		{
+			version: 7,
+			name:    "add groups",
+			up: execDDL("CREATE TABLE chat_groups"+
+				" (groupID CHAR(32) NOT NULL PRIMARY KEY,"+
+				" name VARCHAR(50) NOT NULL,"+
+				" createdAt DATETIME NOT NULL)",
+				"CREATE TABLE group_members"+
+					" (groupID CHAR(32) NOT NULL,"+
+					" userName VARCHAR(50) NOT NULL,"+
+					" role VARCHAR(10) NOT NULL,"+
+					" joinedAt DATETIME NOT NULL,"+
+					" PRIMARY KEY (groupID, userName),"+
+					" INDEX idx_group_members_userName (userName))",
+				"ALTER TABLE messages ADD COLUMN groupID CHAR(32) NOT NULL DEFAULT ''"),
+			down: execDDL("ALTER TABLE messages DROP COLUMN groupID",
+				"DROP TABLE group_members",
+				"DROP TABLE chat_groups"),
+		},
+		{
+			version: 8,
+			name:    "add channels",
+			up: execDDL("CREATE TABLE channels"+
+				" (name VARCHAR(50) NOT NULL PRIMARY KEY,"+
+				" createdAt DATETIME NOT NULL)",
+				"CREATE TABLE channel_members"+
+					" (channel VARCHAR(50) NOT NULL,"+
+					" userName VARCHAR(50) NOT NULL,"+
+					" role VARCHAR(10) NOT NULL,"+
+					" joinedID CHAR(32) NOT NULL,"+
+					" PRIMARY KEY (channel, userName),"+
+					" INDEX idx_channel_members_userName (userName))",
+				"CREATE TABLE channel_posts"+
+					" (postID CHAR(32) NOT NULL PRIMARY KEY,"+
+					" channel VARCHAR(50) NOT NULL,"+
+					" timeStamp DATETIME NOT NULL,"+
+					" text TEXT NOT NULL,"+
+					" sender VARCHAR(50) NOT NULL,"+
+					" INDEX idx_channel_posts_channel (channel, postID))",
+				"CREATE TABLE channel_cursors"+
+					" (channel VARCHAR(50) NOT NULL,"+
+					" userName VARCHAR(50) NOT NULL,"+
+					" device VARCHAR(50) NOT NULL,"+
+					" lastPostID CHAR(32) NOT NULL,"+
+					" PRIMARY KEY (channel, userName, device))"),
+			down: execDDL("DROP TABLE channel_cursors",
+				"DROP TABLE channel_posts",
+				"DROP TABLE channel_members",
+				"DROP TABLE channels"),
+		},
+		{
+			version: 9,
+			name:    "add history",
+			up: execDDL("CREATE TABLE history_settings"+
+				" (peer1 VARCHAR(50) NOT NULL,"+
+				" peer2 VARCHAR(50) NOT NULL,"+
+				" groupID CHAR(32) NOT NULL,"+
+				" PRIMARY KEY (peer1, peer2, groupID))",
+				"CREATE TABLE history"+
+					" (peer1 VARCHAR(50) NOT NULL,"+
+					" peer2 VARCHAR(50) NOT NULL,"+
+					" groupID CHAR(32) NOT NULL,"+
+					" messageID CHAR(32) NOT NULL,"+
+					" timeStamp DATETIME NOT NULL,"+
+					" text TEXT NOT NULL,"+
+					" sender VARCHAR(50) NOT NULL,"+
+					" storedAt DATETIME NOT NULL,"+
+					" PRIMARY KEY (peer1, peer2, groupID, messageID),"+
+					" INDEX idx_history_storedAt (storedAt))"),
+			down: execDDL("DROP TABLE history",
+				"DROP TABLE history_settings"),
+		},
+		{
+			version: 10,
+			name:    "add history search",
+			up:      execDDL("ALTER TABLE history ADD FULLTEXT INDEX idx_history_text (text)"),
+			down:    execDDL("ALTER TABLE history DROP INDEX idx_history_text"),
+		},
+		{
+			version: 11,
+			name:    "add sent messages",
+			up: execDDL("CREATE TABLE sent_messages"+
+				" (messageID CHAR(32) NOT NULL,"+
+				" sender VARCHAR(50) NOT NULL,"+
+				" recipient VARCHAR(50) NOT NULL,"+
+				" groupID CHAR(32) NOT NULL,"+
+				" sentAt DATETIME NOT NULL,"+
+				" PRIMARY KEY (messageID, recipient),"+
+				" INDEX idx_sent_messages_sentAt (sentAt))",
+				"CREATE INDEX idx_messages_byMessageID ON messages (messageID)",
+				"CREATE INDEX idx_history_messageID ON history (messageID)"),
+			down: execDDL("DROP INDEX idx_history_messageID ON history",
+				"DROP INDEX idx_messages_byMessageID ON messages",
+				"DROP TABLE sent_messages"),
+		},
+		{
+			version: 12,
+			name:    "add receipts and settings",
+			up: execDDL("ALTER TABLE sent_messages ADD COLUMN deliveredAt DATETIME NULL, ADD COLUMN readAt DATETIME NULL",
+				"CREATE TABLE receipts"+
+					" (id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,"+
+					" userName VARCHAR(50) NOT NULL,"+
+					" device VARCHAR(50) NOT NULL,"+
+					" messageID CHAR(32) NOT NULL,"+
+					" recipient VARCHAR(50) NOT NULL,"+
+					" status VARCHAR(10) NOT NULL,"+
+					" at DATETIME NOT NULL,"+
+					" INDEX idx_receipts_userName (userName, device, id))",
+				"CREATE TABLE user_settings"+
+					" (userName VARCHAR(50) NOT NULL PRIMARY KEY,"+
+					" readReceipts BOOL NOT NULL)"),
+			down: execDDL("DROP TABLE user_settings",
+				"DROP TABLE receipts",
+				"ALTER TABLE sent_messages DROP COLUMN deliveredAt, DROP COLUMN readAt"),
+		},
+		{
+			version: 13,
+			name:    "add presence",
+			up: execDDL("CREATE TABLE last_seen"+
+				" (userName VARCHAR(50) NOT NULL PRIMARY KEY,"+
+				" lastSeen DATETIME NOT NULL)",
+				"ALTER TABLE user_settings ADD COLUMN hidePresence BOOL NOT NULL DEFAULT FALSE"),
+			down: execDDL("ALTER TABLE user_settings DROP COLUMN hidePresence",
+				"DROP TABLE last_seen"),
+		},
+		{
+			version: 14,
+			name:    "add contacts and blocks",
+			up: execDDL("CREATE TABLE user_lists"+
+				" (userName VARCHAR(50) NOT NULL,"+
+				" list VARCHAR(10) NOT NULL,"+
+				" entry VARCHAR(50) NOT NULL,"+
+				" addedAt DATETIME NOT NULL,"+
+				" PRIMARY KEY (userName, list, entry),"+
+				" INDEX idx_user_lists_entry (entry, list))",
+				"ALTER TABLE user_settings ADD COLUMN contactsOnly BOOL NOT NULL DEFAULT FALSE"),
+			down: execDDL("ALTER TABLE user_settings DROP COLUMN contactsOnly",
+				"DROP TABLE user_lists"),
+		},
+		{
+			version: 15,
+			name:    "add attachments",
+			up: execDDL("CREATE TABLE attachments"+
+				" (attachmentID CHAR(32) NOT NULL PRIMARY KEY,"+
+				" owner VARCHAR(50) NOT NULL,"+
+				" mimeType VARCHAR(255) NOT NULL,"+
+				" size BIGINT NOT NULL,"+
+				" checksum CHAR(64) NOT NULL,"+
+				" createdAt DATETIME NOT NULL)",
+				"CREATE TABLE message_attachments"+
+					" (messageID CHAR(32) NOT NULL,"+
+					" position INT NOT NULL,"+
+					" attachmentID CHAR(32) NOT NULL,"+
+					" PRIMARY KEY (messageID, position))",
+				"CREATE TABLE attachment_access"+
+					" (attachmentID CHAR(32) NOT NULL,"+
+					" userName VARCHAR(50) NOT NULL,"+
+					" groupID VARCHAR(32) NOT NULL,"+
+					" channel VARCHAR(50) NOT NULL,"+
+					" PRIMARY KEY (attachmentID, userName, groupID, channel),"+
+					" INDEX idx_attachment_access_userName (userName))"),
+			down: execDDL("DROP TABLE attachment_access",
+				"DROP TABLE message_attachments",
+				"DROP TABLE attachments"),
+		},
+		{
+			version: 16,
+			name:    "add end-to-end encryption",
+			up: execDDL("CREATE TABLE identity_keys"+
+				" (userName VARCHAR(50) NOT NULL,"+
+				" device VARCHAR(50) NOT NULL,"+
+				" identityKey VARBINARY(128) NOT NULL,"+
+				" updatedAt DATETIME NOT NULL,"+
+				" PRIMARY KEY (userName, device))",
+				"CREATE TABLE prekeys"+
+					" (userName VARCHAR(50) NOT NULL,"+
+					" device VARCHAR(50) NOT NULL,"+
+					" keyID BIGINT NOT NULL,"+
+					" publicKey VARBINARY(128) NOT NULL,"+
+					" PRIMARY KEY (userName, device, keyID))",
+				"ALTER TABLE messages ADD COLUMN payload MEDIUMBLOB NULL",
+				"ALTER TABLE sent_messages ADD COLUMN encrypted BOOL NOT NULL DEFAULT FALSE"),
+			down: execDDL("ALTER TABLE sent_messages DROP COLUMN encrypted",
+				"ALTER TABLE messages DROP COLUMN payload",
+				"DROP TABLE prekeys",
+				"DROP TABLE identity_keys"),
+		},
+		{
+			// the queued messages that are encrypted can't be read without their key IDs, so this one can't be reverted.
+			version: 17,
+			name:    "add message key IDs",
+			up: execDDL("ALTER TABLE messages MODIFY text MEDIUMTEXT NOT NULL",
+				"ALTER TABLE messages ADD COLUMN keyID VARCHAR(32) NOT NULL DEFAULT ''"),
+		},
+		{
+			// the channel posts that are encrypted can't be read without their key IDs, so this one can't be reverted.
+			version: 18,
+			name:    "add channel post key IDs",
+			up: execDDL("ALTER TABLE channel_posts MODIFY text MEDIUMTEXT NOT NULL",
+				"ALTER TABLE channel_posts ADD COLUMN keyID VARCHAR(32) NOT NULL DEFAULT ''"),
+		},
+	}
+}
+
+// matchText returns the mysql FULLTEXT condition in boolean mode, every term is required and matches as a prefix.
+// innodb doesn't index the words that are shorter than innodb_ft_min_token_size, so the shorter terms
+// are matched with a regular expression at the start of the words instead.
+// the stopwords of innodb are not indexed either, so a term that is a stopword only matches the longer words.
+// the terms only have letters and digits because of splitWords, so they don't have the boolean mode operators,
+// but they are quoted in the regular expressions anyway.
+func (mysqlDialect) matchText(terms []string) (string, []interface{}) {
+
+	var conditions []string
+	var args []interface{}
+	var against []string
+	for _, term := range terms {
+		if len([]rune(term)) < mysqlMinTokenSize {
+			conditions = append(conditions, "text REGEXP ?")
+			args = append(args, "(^|[^[:alnum:]])"+regexp.QuoteMeta(term))
+			continue
+		}
+		against = append(against, "+"+term+"*")
+	}
+
+	if len(against) != 0 {
+		conditions = append(conditions, "MATCH (text) AGAINST (? IN BOOLEAN MODE)")
+		args = append(args, strings.Join(against, " "))
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"golang.org/x/net/websocket"
+	"time"
+)
+
+// maxPresenceQuery is the maximum number of users that a getPresence frame can ask for.
+const maxPresenceQuery = 100
+
+// presenceOf is a controller pointer method that makes the presenceStatus of a user.
+// the users that hide their presence are shown as offline with no last seen time.
+// it gets the userName and the settings of the user.
+// returns error if something went wrong.
+func (c *controller) presenceOf(userName string, userSettings userSettings) (presenceStatus, error) {
+
+	status := presenceStatus{UserName: userName}
+	if userSettings.hidePresence {
+		return status, nil
+	}
+
+	if c.checkIsClientOnline(userName) {
+		status.Online = true
+		return status, nil
+	}
+
+	lastSeen, err := c.dbConn.getLastSeen(userName)
+	if err != nil {
+		return presenceStatus{}, err
+	}
+	status.LastSeen = lastSeen
+
+	return status, nil
+}
+
+// broadcastPresence is a controller pointer method that sends the presence of a user to its online peers.
+// the peers are the users that share a group with the user or have it in their contacts and aren't blocked by it.
+// it gets the userName and the settings of the user.
+// returns error if something went wrong.
+func (c *controller) broadcastPresence(userName string, userSettings userSettings) error {
+
+	status, err := c.presenceOf(userName, userSettings)
+	if err != nil {
+		return err
+	}
+
+	peers, err := c.dbConn.getPeers(userName)
+	if err != nil {
+		return err
+	}
+
+	for _, peer := range peers {
+		if c.checkIsClientOnline(peer) {
+			c.notifyUser(peer, presenceFrame, "", presences{Users: []presenceStatus{status}})
+		}
+	}
+
+	return nil
+}
+
+// presenceChanged is a controller pointer method that is called when a user's first device comes online
+// or its last device goes offline.
+// it stores the last seen time if the user is offline and sends the presence to the peers if the user doesn't hide it.
+// the presence is read when it runs, so the peers always get the latest one even if the user reconnects meanwhile.
+// it gets the userName.
+func (c *controller) presenceChanged(userName string) {
+
+	if !c.checkIsClientOnline(userName) {
+		err := c.dbConn.setLastSeen(userName, time.Now())
+		if err != nil {
+			logError("presenceChanged-setLastSeen", err)
+			return
+		}
+	}
+
+	userSettings, err := c.dbConn.getSettings(userName)
+	if err != nil {
+		logError("presenceChanged-getSettings", err)
+		return
+	}
+	if userSettings.hidePresence {
+		return
+	}
+
+	err = c.broadcastPresence(userName, userSettings)
+	if err != nil {
+		logError("presenceChanged-broadcastPresence", err)
+	}
+}
+
+// presenceHandler is a controller pointer method that handles the getPresence frames.
+// it answers with a presence frame, the unknown users are shown as offline so that it doesn't tell who exists
+// and the users that have blocked the client are shown as if they hide their presence.
+// it gets the frame, the websocket connection, the userName and the device ID of the client.
+func (c *controller) presenceHandler(frame envelope, conn *websocket.Conn, userName string, device string) {
+
+	defer c.handlerRecover(userName, device)
+
+	var request presenceQuery
+	if !payloadDecoder(frame, &request, conn) {
+		return
+	}
+
+	if len(request.UserNames) == 0 || len(request.UserNames) > maxPresenceQuery {
+		frameErrorSender(conn, invalidFrame, frame)
+		return
+	}
+
+	result := presences{Users: []presenceStatus{}}
+	seen := make(map[string]bool)
+	for _, user := range request.UserNames {
+		if seen[user] {
+			continue
+		}
+		seen[user] = true
+
+		userSettings, err := c.dbConn.getSettings(user)
+		if err != nil {
+			panic(errScope{scope: "presenceHandler-getSettings", err: err})
+		}
+
+		isBlocked, err := c.dbConn.checkListEntry(user, blockedList, userName)
+		if err != nil {
+			panic(errScope{scope: "presenceHandler-checkListEntry", err: err})
+		}
+		if isBlocked {
+			userSettings.hidePresence = true
+		}
+
+		status, err := c.presenceOf(user, userSettings)
+		if err != nil {
+			panic(errScope{scope: "presenceHandler-presenceOf", err: err})
+		}
+		result.Users = append(result.Users, status)
+	}
+
+	err := frameSender(conn, presenceFrame, frame.ID, result)
+	if err != nil {
+		panic(errScope{scope: "presenceHandler-frameSender", err: err})
+	}
+}
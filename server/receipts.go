@@ -0,0 +1,89 @@
+package main
+
+import (
+	"golang.org/x/net/websocket"
+	"time"
+)
+
+// sendReceipt is a controller pointer method that marks a sent message as delivered to or read by the recipient,
+// and sends a receipt about it to every online device of the sender, the receipt is queued if the sender is offline.
+// every recipient causes a receipt of each status only once, and the messages that are not in the sent messages anymore
+// don't cause any receipt.
+// it gets the ID of the message, the userName of the recipient and the status of the receipt.
+// returns error if something went wrong.
+func (c *controller) sendReceipt(ID string, recipient string, status string) error {
+
+	at := time.Now().UTC()
+	var sender string
+	var err error
+	if status == readStatus {
+		sender, err = c.dbConn.markRead(ID, recipient, at)
+	} else {
+		sender, err = c.dbConn.markDelivered(ID, recipient, at)
+	}
+	if err != nil || sender == "" {
+		return err
+	}
+
+	if c.notifyUser(sender, receiptFrame, ID, receipt{ID: ID, UserName: recipient, Status: status, At: at}) {
+		return nil
+	}
+
+	return c.dbConn.insertReceipt(sender, receiptData{messageID: ID, recipient: recipient, status: status, at: at})
+}
+
+// readHandler is a controller pointer method that handles every single read frame that runReceiver receives.
+// the frame's ID is the ID of the read message, and nothing is sent if the user has turned the read receipts off.
+// it gets the ID of the read message and the userName and the device ID of the user who has sent the frame.
+func (c *controller) readHandler(ID string, userName string, device string) {
+
+	settings, err := c.dbConn.getSettings(userName)
+	if err != nil {
+		logError("readHandler-getSettings", err)
+		c.removeAndCloseOnlineClient(userName, device)
+		return
+	}
+	if !settings.readReceipts {
+		return
+	}
+
+	err = c.sendReceipt(ID, userName, readStatus)
+	if err != nil {
+		logError("readHandler-sendReceipt", err)
+		c.removeAndCloseOnlineClient(userName, device)
+	}
+}
+
+// deliverReceipts is a controller pointer method that delivers the queued receipts of a device of the userName.
+// every receipt is deleted after it has been sent, and the rest stay for the next connect if sending went wrong.
+// it gets the userName, the device ID and the websocket connection of the device.
+func (c *controller) deliverReceipts(userName string, device string, conn *websocket.Conn) {
+
+	receipts, err := c.dbConn.getReceipts(userName, device)
+	if err != nil {
+		logError("deliverReceipts-getReceipts", err)
+		c.removeAndCloseOnlineClient(userName, device)
+		return
+	}
+
+	for _, data := range receipts {
+		err := frameSender(conn, receiptFrame, data.messageID, receipt{
+			ID:       data.messageID,
+			UserName: data.recipient,
+			Status:   data.status,
+			At:       data.at,
+		})
+		if err != nil {
+			logError("deliverReceipts-frameSender", err)
+			c.removeAndCloseOnlineClient(userName, device)
+			return
+		}
+
+		err = c.dbConn.deleteReceipt(userName, device, data)
+		if err != nil {
+			logError("deliverReceipts-deleteReceipt", err)
+			c.removeAndCloseOnlineClient(userName, device)
+			return
+		}
+	}
+}
@@ -1,11 +1,9 @@
 package main
 
 import (
-	"bytes"
-	"crypto/sha1"
+	"crypto/ed25519"
 	"encoding/json"
 	"fmt"
-	"github.com/go-sql-driver/mysql"
 	"golang.org/x/net/websocket"
 	"strings"
 )
@@ -21,13 +19,16 @@ func (c *controller) register(conn *websocket.Conn) {
 		}
 	}()
 
-	var data []byte
-	err := websocket.Message.Receive(conn, &data)
+	frame, err := frameReceiver(conn)
 	if err != nil {
-		panic(errScope{scope: "register-Receive", err: err})
+		panic(errScope{scope: "register-frameReceiver", err: err})
+	}
+	if frame.Type != registerFrame {
+		_ = conn.Close()
+		return
 	}
 	var reg registration
-	err = json.Unmarshal(data, &reg)
+	err = json.Unmarshal(frame.Payload, &reg)
 	if err != nil {
 		panic(errScope{scope: "register-Unmarshal", err: err})
 	}
@@ -37,9 +38,9 @@ func (c *controller) register(conn *websocket.Conn) {
 		return
 	}
 
-	isClientExist, err := c.dbConn.checkClientID(reg.ClientID)
+	isClientExist, err := c.dbConn.checkPublicKey(reg.PublicKey)
 	if err != nil {
-		panic(errScope{scope: "register-checkClientID", err: err})
+		panic(errScope{scope: "register-checkPublicKey", err: err})
 	}
 	if isClientExist {
 		err = responseSender(conn, alreadyReg)
@@ -66,14 +67,14 @@ func (c *controller) register(conn *websocket.Conn) {
 	}
 
 	userIP := conn.Request().RemoteAddr[:strings.IndexByte(conn.Request().RemoteAddr, ':')]
-	err = c.dbConn.insertUserAndCreateTable(userData{
-		userName: reg.UserName,
-		clientID: reg.ClientID,
-		name:     strings.TrimSpace(reg.Name),
-		ip:       userIP,
-	}, "tbl_"+reg.UserName)
+	err = c.dbConn.insertUser(userData{
+		userName:  reg.UserName,
+		publicKey: reg.PublicKey,
+		name:      strings.TrimSpace(reg.Name),
+		ip:        userIP,
+	})
 	if err != nil {
-		if err.(*mysql.MySQLError).Number == duplicateErr {
+		if err == errDuplicate {
 			err = responseSender(conn, invalidUserName)
 			if err != nil {
 				panic(errScope{scope: "register-duplicate-responseSender", err: err})
@@ -83,7 +84,7 @@ func (c *controller) register(conn *websocket.Conn) {
 			return
 		}
 
-		panic(errScope{scope: "register-insertUserAndCreateTable", err: err})
+		panic(errScope{scope: "register-insertUser", err: err})
 	}
 
 	err = responseSender(conn, approved)
@@ -101,18 +102,12 @@ func (c *controller) register(conn *websocket.Conn) {
 // it returns True if everything went alright and False if not.
 func validateRegistration(reg registration) bool {
 
-	emptyHash := sha1.New()
-	emptyHash.Write([]byte(""))
-	emptyClientID := emptyHash.Sum(nil)
-
 	if strings.TrimSpace(reg.UserName) == "" ||
-		reg.ClientID == nil ||
-		bytes.Compare(reg.ClientID, emptyClientID) == 0 {
+		len(reg.PublicKey) != ed25519.PublicKeySize {
 		return false
 	}
 
-	if len(reg.ClientID) != sha1.Size ||
-		len(reg.Name) > 50 ||
+	if len(reg.Name) > 50 ||
 		len(reg.UserName) > 50 {
 		return false
 	}
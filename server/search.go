@@ -0,0 +1,110 @@
+package main
+
+import (
+	"golang.org/x/net/websocket"
+	"strings"
+	"unicode"
+)
+
+// these are the limits of the searches.
+// defaultSearchLimit is the number of results of a page if the client doesn't ask for a limit.
+// maxSearchLimit is the maximum number of results that a page can have.
+// maxSearchTerms is the maximum number of words that a search query can have.
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	maxSearchTerms     = 10
+)
+
+// splitWords splits the text into lower case words, anything but letters and digits separates them.
+func splitWords(text string) []string {
+
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// searchTerms splits the search query into lower case words with splitWords and removes the repeated words.
+// it returns the words and True if they are valid and False if there are too many of them.
+func searchTerms(query string) ([]string, bool) {
+
+	words := splitWords(query)
+
+	seen := make(map[string]bool)
+	var result []string
+	for _, word := range words {
+		if seen[word] {
+			continue
+		}
+		seen[word] = true
+		result = append(result, word)
+	}
+
+	return result, len(result) <= maxSearchTerms
+}
+
+// searchHandler is a controller pointer method that handles the search frames.
+// only the history of the direct conversations of the user and its current groups is searched.
+// it answers with a searchResults frame.
+// it gets the frame, the websocket connection, the userName and the device ID of the client.
+func (c *controller) searchHandler(frame envelope, conn *websocket.Conn, userName string, device string) {
+
+	defer c.handlerRecover(userName, device)
+
+	var request searchRequest
+	if !payloadDecoder(frame, &request, conn) {
+		return
+	}
+
+	terms, ok := searchTerms(request.Query)
+	if request.Limit == 0 {
+		request.Limit = defaultSearchLimit
+	}
+	if !ok || request.Limit < 0 || request.Limit > maxSearchLimit ||
+		(len(terms) == 0 && request.Sender == "" && request.From.IsZero() && request.To.IsZero()) ||
+		(!request.From.IsZero() && !request.To.IsZero() && !request.From.Before(request.To)) ||
+		(request.Before != "" && len(request.Before) != idLength) {
+		frameErrorSender(conn, invalidFrame, frame)
+		return
+	}
+
+	// one more result is asked to find out whether there are more results beyond the page or not.
+	matches, err := c.dbConn.searchHistory(userName, searchFilter{
+		terms:  terms,
+		sender: request.Sender,
+		from:   request.From,
+		to:     request.To,
+		before: request.Before,
+	}, request.Limit+1)
+	if err != nil {
+		panic(errScope{scope: "searchHandler-searchHistory", err: err})
+	}
+
+	results := searchResults{Results: []searchResult{}, HasMore: len(matches) > request.Limit}
+	if results.HasMore {
+		matches = matches[:request.Limit]
+	}
+	messages := make([]messageData, len(matches))
+	for i, match := range matches {
+		messages[i] = match.message
+	}
+	err = c.loadAttachments(messages)
+	if err != nil {
+		panic(errScope{scope: "searchHandler-loadAttachments", err: err})
+	}
+	for i, match := range matches {
+		result := searchResult{Message: receivedMessage(messages[i])}
+		if match.conv.group == "" {
+			result.With = match.conv.peer1
+			if result.With == userName {
+				result.With = match.conv.peer2
+			}
+		}
+		results.Results = append(results.Results, result)
+	}
+
+	err = frameSender(conn, searchResultsFrame, frame.ID, results)
+	if err != nil {
+		panic(errScope{scope: "searchHandler-frameSender", err: err})
+	}
+}
@@ -4,25 +4,47 @@ import (
 	"fmt"
 	"golang.org/x/net/websocket"
 	"net/http"
+	"os"
 )
 
 // everything starts from here.
-// database connection, controller, servers mux, mux handlers and finally server starts to listen.
+// config, storage connection, blob storage, controller, servers mux, mux handlers and finally server starts to listen.
 func main() {
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(migrateCommand(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "rotate-keys" {
+		os.Exit(rotateKeysCommand(os.Args[2:]))
+	}
+
 	defer fmt.Println("Server stopped working!")
 
-	dbConn, err := createDBConnection("mahdi:123@/redFokDB?parseTime=true")
-	if err != nil || dbConn == nil {
-		logError("createDBConnection", err)
+	conf, _, err := loadConfig(os.Args[1:])
+	if err != nil {
+		logError("loadConfig", err)
+		return
+	}
+
+	dbConn, err := openStore(conf)
+	if err != nil {
+		logError("openStore", err)
 		return
 	}
-	defer func() { _ = dbConn.db.Close() }()
+	defer func() { _ = dbConn.close() }()
 
-	controller := initNewController(*dbConn)
+	blobs, err := openBlobStore(conf)
+	if err != nil {
+		logError("openBlobStore", err)
+		return
+	}
+
+	controller := initNewController(dbConn, blobs, conf)
 	mux := http.NewServeMux()
 	gate := &processGate{isGateOpen: true}
 	go func() { gate.dbConnWatcher(controller) }()
+	go controller.janitor()
 
 	mux.Handle("/api/", websocket.Handler(
 		func(conn *websocket.Conn) {
@@ -41,11 +63,24 @@ func main() {
 
 			case "/api/deletion":
 				controller.deleter(conn)
+
+			case "/api/sessions":
+				controller.sessionManager(conn)
 			}
 		}))
 
+	mux.HandleFunc(attachmentsPath, func(w http.ResponseWriter, r *http.Request) {
+
+		if !gate.pGateCheck() {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+
+		controller.attachmentsHandler(w, r)
+	})
+
 	server := http.Server{
-		Addr:    ":13013",
+		Addr:    conf.addr,
 		Handler: mux,
 	}
 
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"golang.org/x/net/websocket"
+	"sync"
+	"time"
+)
+
+// sessionTokenSize is the size of the random session tokens before encoding.
+const sessionTokenSize = 32
+
+// sessionConnections is the struct that we use to keep the connections of the sessions with its mutex together.
+// mapLock is the mutex that we use to lock conns map to prevent race problems.
+// conns is the map of the open connections of every session and key of the map is the session ID,
+// every session has a map of its connections with the userName of the session as value.
+type sessionConnections struct {
+	mapLock sync.Mutex
+	conns   map[string]map[*websocket.Conn]string
+}
+
+// hashToken returns the SHA-256 hash of a session token.
+// server only keeps the hashes, so the tokens can't be used by anyone who reads the database.
+func hashToken(token string) []byte {
+
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+// issueSession is a controller method that issues a new session for the given userName.
+// it stores the session and sends its token to the client in a session frame.
+// it gets a websocket connection as the authenticated client and its userName.
+// it returns the ID of the session.
+// it panics with an errScope if something went wrong.
+func (c *controller) issueSession(conn *websocket.Conn, userName string) string {
+
+	raw := make([]byte, sessionTokenSize)
+	_, err := rand.Read(raw)
+	if err != nil {
+		panic(errScope{scope: "issueSession-Read", err: err})
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	now := time.Now().UTC()
+	session := sessionData{
+		id:        newID(),
+		userName:  userName,
+		tokenHash: hashToken(token),
+		createdAt: now,
+		expiresAt: now.Add(c.conf.sessionTTL),
+	}
+	err = c.dbConn.insertSession(session)
+	if err != nil {
+		panic(errScope{scope: "issueSession-insertSession", err: err})
+	}
+
+	err = frameSender(conn, sessionFrame, "", sessionToken{
+		ID:        session.id,
+		Token:     token,
+		ExpiresAt: session.expiresAt,
+	})
+	if err != nil {
+		panic(errScope{scope: "issueSession-frameSender", err: err})
+	}
+
+	return session.id
+}
+
+// checkSessionToken is a controller method that checks the session token of an authentication.
+// the expired sessions are deleted when they are seen.
+// it gets the authentication that client has sent.
+// it returns the session ID and True if the token belongs to a valid session of the userName and False if not.
+// it panics with an errScope if something went wrong.
+func (c *controller) checkSessionToken(auth authentication) (string, bool) {
+
+	session, err := c.dbConn.getSession(hashToken(auth.Token))
+	if err != nil {
+		panic(errScope{scope: "checkSessionToken-getSession", err: err})
+	}
+	if session == nil || session.userName != auth.UserName {
+		return "", false
+	}
+
+	if !time.Now().Before(session.expiresAt) {
+		err = c.dbConn.deleteSession(session.userName, session.id)
+		if err != nil {
+			panic(errScope{scope: "checkSessionToken-deleteSession", err: err})
+		}
+		return "", false
+	}
+
+	return session.id, true
+}
+
+// trackSession is a controller pointer method that keeps a connection that a session has authenticated,
+// so it can be closed when the session is revoked.
+// the connection is forgotten when its handler returns.
+// it gets the session ID, the userName of the session and the websocket connection.
+func (c *controller) trackSession(ID string, userName string, conn *websocket.Conn) {
+
+	c.sessionConns.mapLock.Lock()
+	conns, ok := c.sessionConns.conns[ID]
+	if !ok {
+		conns = make(map[*websocket.Conn]string)
+		c.sessionConns.conns[ID] = conns
+	}
+	conns[conn] = userName
+	c.sessionConns.mapLock.Unlock()
+
+	go func() {
+		<-conn.Request().Context().Done()
+
+		c.sessionConns.mapLock.Lock()
+		defer c.sessionConns.mapLock.Unlock()
+		delete(c.sessionConns.conns[ID], conn)
+		if len(c.sessionConns.conns[ID]) == 0 {
+			delete(c.sessionConns.conns, ID)
+		}
+	}()
+}
+
+// closeSessionConnections is a controller pointer method that closes the open connections of a session of the userName.
+// the handlers of the connections clean up after them when their reads fail.
+// it gets the userName and the session ID.
+func (c *controller) closeSessionConnections(userName string, ID string) {
+
+	c.sessionConns.mapLock.Lock()
+	defer c.sessionConns.mapLock.Unlock()
+	for conn, owner := range c.sessionConns.conns[ID] {
+		if owner == userName {
+			_ = conn.Close()
+		}
+	}
+}
+
+// sessionManager is a controller pointer method that lets clients list and revoke their sessions and devices.
+// after authentication client can send listSessions frames to get its active sessions
+// and revokeSession frames to revoke one of them, the connection can be closed whenever client wants.
+// listDevices and retireDevice frames do the same for the devices, a retired device loses its queued messages
+// and its keys and it's added again as a new device if it connects later.
+// it gets a websocket connection pointer and uses it as the user connection.
+func (c *controller) sessionManager(conn *websocket.Conn) {
+
+	defer func() { _ = conn.Close() }()
+
+	userName, _ := c.checkAuthentication(conn)
+	if userName == "" {
+		return
+	}
+
+	err := responseSender(conn, approved)
+	if err != nil {
+		logError("sessionManager-responseSender", err)
+		return
+	}
+
+	for {
+		frame, err := frameReceiver(conn)
+		if err != nil {
+			return
+		}
+
+		if frame.Version > protocolVersion {
+			frameErrorSender(conn, unsupportedVersion, frame)
+			continue
+		}
+
+		switch frame.Type {
+		case listSessionsFrame:
+			sessions, err := c.dbConn.getSessions(userName, time.Now())
+			if err != nil {
+				logError("sessionManager-getSessions", err)
+				return
+			}
+
+			list := sessionsList{Sessions: []sessionInfo{}}
+			for _, session := range sessions {
+				list.Sessions = append(list.Sessions, sessionInfo{
+					ID:        session.id,
+					CreatedAt: session.createdAt,
+					ExpiresAt: session.expiresAt,
+				})
+			}
+
+			err = frameSender(conn, sessionsFrame, frame.ID, list)
+			if err != nil {
+				logError("sessionManager-frameSender", err)
+				return
+			}
+
+		case revokeSessionFrame:
+			var revoke revokeSession
+			if !payloadDecoder(frame, &revoke, conn) {
+				continue
+			}
+
+			err = c.dbConn.deleteSession(userName, revoke.ID)
+			if err != nil {
+				logError("sessionManager-deleteSession", err)
+				return
+			}
+
+			// the response is sent before closing, because this connection may belong to the revoked session.
+			err = frameSender(conn, responseFrame, frame.ID, response{Value: approved})
+			if err != nil {
+				logError("sessionManager-frameSender", err)
+				return
+			}
+			c.closeSessionConnections(userName, revoke.ID)
+
+		case listDevicesFrame:
+			devices, err := c.dbConn.getDevices(userName)
+			if err != nil {
+				logError("sessionManager-getDevices", err)
+				return
+			}
+
+			list := devicesList{Devices: []string{}}
+			list.Devices = append(list.Devices, devices...)
+
+			err = frameSender(conn, devicesFrame, frame.ID, list)
+			if err != nil {
+				logError("sessionManager-frameSender", err)
+				return
+			}
+
+		case retireDeviceFrame:
+			var retire retireDevice
+			if !payloadDecoder(frame, &retire, conn) {
+				continue
+			}
+
+			if retire.Device == "" {
+				frameErrorSender(conn, invalidFrame, frame)
+				continue
+			}
+
+			// the messaging connection of the device is closed, so it doesn't go on with the retired device.
+			if c.checkIsDeviceOnline(userName, retire.Device) {
+				c.removeAndCloseOnlineClient(userName, retire.Device)
+			}
+
+			err = c.dbConn.deleteDevice(userName, retire.Device)
+			if err != nil {
+				logError("sessionManager-deleteDevice", err)
+				return
+			}
+
+			err = frameSender(conn, responseFrame, frame.ID, response{Value: approved})
+			if err != nil {
+				logError("sessionManager-frameSender", err)
+				return
+			}
+
+		default:
+			frameErrorSender(conn, unsupportedFrame, frame)
+		}
+	}
+}
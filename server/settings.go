@@ -0,0 +1,77 @@
+package main
+
+import "golang.org/x/net/websocket"
+
+// settingsSender sends a settings frame describing the settings of the user.
+// it panics with an errScope if sending went wrong.
+func settingsSender(conn *websocket.Conn, frame envelope, userSettings userSettings) {
+
+	err := frameSender(conn, settingsFrame, frame.ID, settings{
+		ReadReceipts: userSettings.readReceipts,
+		HidePresence: userSettings.hidePresence,
+		ContactsOnly: userSettings.contactsOnly,
+	})
+	if err != nil {
+		panic(errScope{scope: "settingsSender-frameSender", err: err})
+	}
+}
+
+// getSettingsHandler is a controller pointer method that handles the getSettings frames.
+// it answers with a settings frame.
+// it gets the frame, the websocket connection, the userName and the device ID of the client.
+func (c *controller) getSettingsHandler(frame envelope, conn *websocket.Conn, userName string, device string) {
+
+	defer c.handlerRecover(userName, device)
+
+	userSettings, err := c.dbConn.getSettings(userName)
+	if err != nil {
+		panic(errScope{scope: "getSettingsHandler-getSettings", err: err})
+	}
+
+	settingsSender(conn, frame, userSettings)
+}
+
+// setSettingsHandler is a controller pointer method that handles the setSettings frames.
+// only the sent fields are changed and it answers with a settings frame containing all the settings.
+// it gets the frame, the websocket connection, the userName and the device ID of the client.
+func (c *controller) setSettingsHandler(frame envelope, conn *websocket.Conn, userName string, device string) {
+
+	defer c.handlerRecover(userName, device)
+
+	var request settingsUpdate
+	if !payloadDecoder(frame, &request, conn) {
+		return
+	}
+
+	userSettings, err := c.dbConn.getSettings(userName)
+	if err != nil {
+		panic(errScope{scope: "setSettingsHandler-getSettings", err: err})
+	}
+
+	if request.ReadReceipts != nil {
+		userSettings.readReceipts = *request.ReadReceipts
+	}
+	if request.ContactsOnly != nil {
+		userSettings.contactsOnly = *request.ContactsOnly
+	}
+	presenceChanged := false
+	if request.HidePresence != nil && *request.HidePresence != userSettings.hidePresence {
+		userSettings.hidePresence = *request.HidePresence
+		presenceChanged = true
+	}
+
+	err = c.dbConn.setSettings(userName, userSettings)
+	if err != nil {
+		panic(errScope{scope: "setSettingsHandler-setSettings", err: err})
+	}
+
+	// the peers should see the user going offline when it hides its presence and online when it shows it again.
+	if presenceChanged {
+		err = c.broadcastPresence(userName, userSettings)
+		if err != nil {
+			panic(errScope{scope: "setSettingsHandler-broadcastPresence", err: err})
+		}
+	}
+
+	settingsSender(conn, frame, userSettings)
+}
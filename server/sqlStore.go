@@ -0,0 +1,2059 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// sqlDialect is the interface that keeps the differences between the sql databases that sqlStore can work with.
+type sqlDialect interface {
+
+	// isDuplicate checks whether the err is the driver error of violating a 'UNIQUE' field.
+	isDuplicate(err error) bool
+
+	// quote quotes the given table name so it can be used in a statement safely.
+	quote(table string) string
+
+	// listTables returns the query that lists the names of all the tables in the database.
+	listTables() string
+
+	// migrations returns the schema migrations of the database in the order of their versions.
+	migrations() []migration
+
+	// matchText returns the condition that matches the history messages which have a word starting with every given term
+	// with the arguments of its placeholders.
+	matchText(terms []string) (string, []interface{})
+}
+
+// sqlStore is the store implementation that keeps everything in a sql database.
+// db is the database connection pool that we use by initializing it via a specific driver.
+// dialect is the sqlDialect of the database that db is connected to.
+// keys is the keyRing that the texts of the queued messages are encrypted with and they are plain text if it's nil.
+type sqlStore struct {
+	db      *sql.DB
+	dialect sqlDialect
+	keys    *keyRing
+}
+
+// querier is the common interface of sql.DB and sql.Tx that we use to run queries in or out of a transaction.
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// queryStrings runs the given query which selects a single string column and returns the column values as a slice.
+// returns error if something went wrong.
+func queryStrings(q querier, query string, args ...interface{}) ([]string, error) {
+
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var result []string
+
+	for rows.Next() {
+		var value string
+		err := rows.Scan(&value)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, value)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// getCredentials gets a userName and returns the credentials of that user.
+// returns nil if the user is not existing, and error if something went wrong.
+func (dbConn sqlStore) getCredentials(userName string) (*credentials, error) {
+
+	row := dbConn.db.QueryRow(
+		"SELECT ClientID, publicKey FROM tbl_users WHERE userName = ?", userName)
+	var creds credentials
+	err := row.Scan(&creds.clientID, &creds.publicKey)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &creds, nil
+}
+
+// setPublicKey enrolls the public key for the given userName and removes its old ClientID.
+// returns errDuplicate if the public key belongs to another user and error if something else went wrong.
+func (dbConn sqlStore) setPublicKey(userName string, key []byte) error {
+
+	_, err := dbConn.db.Exec("UPDATE tbl_users SET publicKey = ?, ClientID = NULL WHERE userName = ?",
+		key, userName)
+	if err != nil {
+		if dbConn.dialect.isDuplicate(err) {
+			return errDuplicate
+		}
+		return err
+	}
+
+	return nil
+}
+
+// checkPublicKey checks whether the public key exists in the database or not.
+// returns True if exists and False if not, error if something went wrong.
+func (dbConn sqlStore) checkPublicKey(key []byte) (bool, error) {
+
+	row := dbConn.db.QueryRow(
+		"SELECT EXISTS (SELECT * FROM tbl_users WHERE publicKey = ?)", key)
+	var result bool
+	err := row.Scan(&result)
+	if err != nil {
+		return false, err
+	}
+
+	return result, nil
+}
+
+// checkClientUserName checks whether the userName exists in the database or not.
+// returns True if exists and False if not, error if something went wrong.
+func (dbConn sqlStore) checkClientUserName(userName string) (bool, error) {
+
+	row := dbConn.db.QueryRow(
+		"SELECT EXISTS (SELECT * FROM tbl_users WHERE userName = ?)", userName)
+	var result bool
+	err := row.Scan(&result)
+	if err != nil {
+		return false, err
+	}
+
+	return result, nil
+}
+
+// insertDevice inserts the device of the given userName into the devices table.
+// the first device of the user takes the messages and the receipts that have been queued with an empty device
+// before the user had any device, so every device has its own copies of the queued rows.
+// returns error if something went wrong, but an already known device is not an error.
+func (dbConn sqlStore) insertDevice(userName string, device string) error {
+
+	tx, err := dbConn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO devices (userName, deviceID) VALUES (?, ?)", userName, device)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		if dbConn.dialect.isDuplicate(err) {
+			return nil
+		}
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE messages SET device = ? WHERE recipient = ? AND device = ''", device, userName)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE receipts SET device = ? WHERE userName = ? AND device = ''", device, userName)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// getDevices gets the IDs of the devices of the given userName from the devices table sorted by the ID.
+// returns error if something went wrong.
+func (dbConn sqlStore) getDevices(userName string) ([]string, error) {
+
+	rows, err := dbConn.db.Query("SELECT deviceID FROM devices WHERE userName = ? ORDER BY deviceID", userName)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var result []string
+
+	for rows.Next() {
+		var device string
+		err := rows.Scan(&device)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, device)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// deleteDevice deletes the device of the given userName from the devices table
+// and its rows from the messages, receipts, channel_cursors, identity_keys and prekeys tables.
+// returns error if something went wrong.
+func (dbConn sqlStore) deleteDevice(userName string, device string) error {
+
+	tx, err := dbConn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	queries := []string{
+		"DELETE FROM devices WHERE userName = ? AND deviceID = ?",
+		"DELETE FROM messages WHERE recipient = ? AND device = ?",
+		"DELETE FROM receipts WHERE userName = ? AND device = ?",
+		"DELETE FROM channel_cursors WHERE userName = ? AND device = ?",
+		"DELETE FROM identity_keys WHERE userName = ? AND device = ?",
+		"DELETE FROM prekeys WHERE userName = ? AND device = ?",
+	}
+	for _, query := range queries {
+		_, err = tx.Exec(query, userName, device)
+		if err != nil {
+			_ = tx.Rollback() //this will return error so we have to handle it
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// insertMessage inserts a copy of the messageData into the messages table for every device of the given userName.
+// if the user has no device yet, a single copy with an empty device is inserted that the first device will take.
+// the encrypted messages are only inserted for the existing devices that they have a payload for.
+// the text is encrypted with the current key of the keyRing and its key ID is kept with it.
+// returns error if something went wrong.
+func (dbConn sqlStore) insertMessage(userName string, message messageData) error {
+
+	keyID, text, err := dbConn.keys.seal(message.id, message.text)
+	if err != nil {
+		return err
+	}
+
+	tx, err := dbConn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if message.payloads != nil {
+		for device, payload := range message.payloads {
+			_, err = tx.Exec("INSERT INTO messages"+
+				" (messageID, recipient, device, timeStamp, text, sender, groupID, payload, keyID)"+
+				" SELECT ?, userName, deviceID, ?, ?, ?, ?, ?, ? FROM devices WHERE userName = ? AND deviceID = ?",
+				message.id, message.timeStamp, text, message.sender, message.group, payload, keyID, userName, device)
+			if err != nil {
+				_ = tx.Rollback() //this will return error so we have to handle it
+				return err
+			}
+		}
+
+		return tx.Commit()
+	}
+
+	result, err := tx.Exec("INSERT INTO messages (messageID, recipient, device, timeStamp, text, sender, groupID, keyID)"+
+		" SELECT ?, userName, deviceID, ?, ?, ?, ?, ? FROM devices WHERE userName = ?",
+		message.id, message.timeStamp, text, message.sender, message.group, keyID, userName)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	if count == 0 {
+		_, err = tx.Exec("INSERT INTO messages (messageID, recipient, device, timeStamp, text, sender, groupID, keyID)"+
+			" VALUES (?, ?, '', ?, ?, ?, ?, ?)",
+			message.id, userName, message.timeStamp, text, message.sender, message.group, keyID)
+		if err != nil {
+			_ = tx.Rollback() //this will return error so we have to handle it
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// getMessages gets all the messageData of the given device of the given userName from the messages table
+// and returns them as a slice.
+// messages are in the same order that they have been inserted and their texts are decrypted with the keyRing.
+// returns error if something went wrong.
+func (dbConn sqlStore) getMessages(userName string, device string) ([]messageData, error) {
+
+	rows, err := dbConn.db.Query("SELECT messageID, timeStamp, text, sender, groupID, payload, keyID FROM messages"+
+		" WHERE recipient = ? AND device = ? ORDER BY id", userName, device)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var result []messageData
+
+	for rows.Next() {
+		var data messageData
+		var payload []byte
+		var keyID string
+		err := rows.Scan(&data.id, &data.timeStamp, &data.text, &data.sender, &data.group, &payload, &keyID)
+		if err != nil {
+			return nil, err
+		}
+		data.text, err = dbConn.keys.open(keyID, data.id, data.text)
+		if err != nil {
+			return nil, err
+		}
+		if payload != nil {
+			data.payloads = map[string][]byte{device: payload}
+		}
+		result = append(result, data)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// deleteMessage deletes the message with the given ID of the given device of the given userName from the messages table.
+// returns error if something went wrong.
+func (dbConn sqlStore) deleteMessage(userName string, device string, ID string) error {
+
+	_, err := dbConn.db.Exec(
+		"DELETE FROM messages WHERE recipient = ? AND device = ? AND messageID = ?", userName, device, ID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// insertSentMessage inserts a row into the sent_messages table for every recipient of the message.
+// returns error if something went wrong.
+func (dbConn sqlStore) insertSentMessage(message sentMessage) error {
+
+	tx, err := dbConn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, recipient := range message.recipients {
+		_, err = tx.Exec("INSERT INTO sent_messages (messageID, sender, recipient, groupID, sentAt, encrypted)"+
+			" VALUES (?, ?, ?, ?, ?, ?)",
+			message.id, message.sender, recipient, message.group, message.sentAt, message.encrypted)
+		if err != nil {
+			_ = tx.Rollback() //this will return error so we have to handle it
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// getSentMessage gets the message with the given ID from the sent_messages table.
+// returns nil if there is no such message and error if something went wrong.
+func (dbConn sqlStore) getSentMessage(ID string) (*sentMessage, error) {
+
+	rows, err := dbConn.db.Query("SELECT sender, recipient, groupID, sentAt, encrypted FROM sent_messages"+
+		" WHERE messageID = ?", ID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var result *sentMessage
+
+	for rows.Next() {
+		message := sentMessage{id: ID}
+		var recipient string
+		err := rows.Scan(&message.sender, &recipient, &message.group, &message.sentAt, &message.encrypted)
+		if err != nil {
+			return nil, err
+		}
+		if result == nil {
+			result = &message
+		}
+		result.recipients = append(result.recipients, recipient)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// editMessage changes the text of the message with the given ID in the messages and the history tables.
+// returns error if something went wrong.
+func (dbConn sqlStore) editMessage(ID string, text string) error {
+
+	keyID, sealed, err := dbConn.keys.seal(ID, text)
+	if err != nil {
+		return err
+	}
+
+	tx, err := dbConn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE messages SET text = ?, keyID = ? WHERE messageID = ?", sealed, keyID, ID)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE history SET text = ? WHERE messageID = ?", text, ID)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// unsendMessage deletes the message with the given ID from the messages, the history and the sent_messages tables.
+// returns error if something went wrong.
+func (dbConn sqlStore) unsendMessage(ID string) error {
+
+	tx, err := dbConn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM messages WHERE messageID = ?", ID)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM history WHERE messageID = ?", ID)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM sent_messages WHERE messageID = ?", ID)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// rotateBatchSize is the number of the rows that rotateMessageKeys re-encrypts in each transaction.
+const rotateBatchSize = 500
+
+// sealedTable is a table that its texts are encrypted with the keyRing.
+// rowID is the unique column that rotateMessageKeys goes through the table in its order,
+// and sealID is the column that the texts are sealed with.
+type sealedTable struct {
+	name   string
+	rowID  string
+	sealID string
+}
+
+// sealedTables are the tables that rotateMessageKeys re-encrypts.
+var sealedTables = []sealedTable{
+	{name: "messages", rowID: "id", sealID: "messageID"},
+	{name: "channel_posts", rowID: "postID", sealID: "postID"},
+}
+
+// rotateMessageKeys re-encrypts the texts of the rows of the sealedTables that aren't encrypted with the current key
+// of the keyRing, it goes through the tables in batches so the rows are not locked for a long time.
+// it returns the number of the rotated rows.
+// returns errUnknownKey if a row is encrypted with a key that is not in the keyRing and error if something went wrong.
+func (dbConn sqlStore) rotateMessageKeys() (int, error) {
+
+	current := dbConn.keys.currentKey()
+	count := 0
+	for _, table := range sealedTables {
+		// the row IDs are kept as strings, both databases compare them with the integer IDs as numbers.
+		lastID := ""
+		if table.rowID == "id" {
+			lastID = "0"
+		}
+		for {
+			rotated, last, err := dbConn.rotateBatch(table, lastID, current)
+			if err != nil {
+				return count, err
+			}
+			if last == lastID {
+				break
+			}
+			count += rotated
+			lastID = last
+		}
+	}
+
+	return count, nil
+}
+
+// rotateBatch re-encrypts the next batch of rows of the table after the given row ID for rotateMessageKeys.
+// it gets the sealedTable, the row ID that the last batch has ended at and the current key ID.
+// it returns the number of the rotated rows and the row ID that this batch has ended at.
+// returns error if something went wrong.
+func (dbConn sqlStore) rotateBatch(table sealedTable, afterID string, current string) (int, string, error) {
+
+	tx, err := dbConn.db.Begin()
+	if err != nil {
+		return 0, afterID, err
+	}
+
+	rows, err := tx.Query("SELECT "+table.rowID+", "+table.sealID+", text, keyID FROM "+table.name+
+		" WHERE "+table.rowID+" > ? AND keyID <> ? ORDER BY "+table.rowID+" LIMIT ?", afterID, current, rotateBatchSize)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return 0, afterID, err
+	}
+
+	type sealedRow struct {
+		id     string
+		sealID string
+		text   string
+		keyID  string
+	}
+	var batch []sealedRow
+	for rows.Next() {
+		var row sealedRow
+		err := rows.Scan(&row.id, &row.sealID, &row.text, &row.keyID)
+		if err != nil {
+			_ = rows.Close()
+			_ = tx.Rollback() //this will return error so we have to handle it
+			return 0, afterID, err
+		}
+		batch = append(batch, row)
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return 0, afterID, err
+	}
+
+	count := 0
+	for _, row := range batch {
+		text, err := dbConn.keys.open(row.keyID, row.sealID, row.text)
+		if err != nil {
+			_ = tx.Rollback() //this will return error so we have to handle it
+			return 0, afterID, err
+		}
+
+		keyID, sealed, err := dbConn.keys.seal(row.sealID, text)
+		if err != nil {
+			_ = tx.Rollback() //this will return error so we have to handle it
+			return 0, afterID, err
+		}
+
+		// the row is only changed if it has the key that it has been read with, so an edit meanwhile is not lost.
+		result, err := tx.Exec("UPDATE "+table.name+" SET text = ?, keyID = ? WHERE "+table.rowID+" = ? AND keyID = ?",
+			sealed, keyID, row.id, row.keyID)
+		if err != nil {
+			_ = tx.Rollback() //this will return error so we have to handle it
+			return 0, afterID, err
+		}
+		updated, err := result.RowsAffected()
+		if err != nil {
+			_ = tx.Rollback() //this will return error so we have to handle it
+			return 0, afterID, err
+		}
+		count += int(updated)
+		afterID = row.id
+	}
+
+	return count, afterID, tx.Commit()
+}
+
+// markDelivered sets the deliveredAt of the recipient's row of the message in the sent_messages table if it's not set yet.
+// it returns the sender of the message if the row has been changed and an empty string if not.
+// returns error if something went wrong.
+func (dbConn sqlStore) markDelivered(ID string, recipient string, at time.Time) (string, error) {
+
+	return dbConn.markSentMessage("UPDATE sent_messages SET deliveredAt = ?"+
+		" WHERE messageID = ? AND recipient = ? AND deliveredAt IS NULL", ID, recipient, at)
+}
+
+// markRead sets the readAt of the recipient's row of the message in the sent_messages table if it's not set yet.
+// the deliveredAt is also set if it's not set yet, because a read message has been delivered too.
+// it returns the sender of the message if the row has been changed and an empty string if not.
+// returns error if something went wrong.
+func (dbConn sqlStore) markRead(ID string, recipient string, at time.Time) (string, error) {
+
+	return dbConn.markSentMessage("UPDATE sent_messages SET readAt = ?, deliveredAt = COALESCE(deliveredAt, ?)"+
+		" WHERE messageID = ? AND recipient = ? AND readAt IS NULL", ID, recipient, at, at)
+}
+
+// markSentMessage runs the update query of markDelivered or markRead that gets the times, the ID and the recipient.
+// it returns the sender of the message if the row has been changed and an empty string if not.
+// returns error if something went wrong.
+func (dbConn sqlStore) markSentMessage(query string, ID string, recipient string, times ...time.Time) (string, error) {
+
+	var args []interface{}
+	for _, at := range times {
+		args = append(args, at)
+	}
+	result, err := dbConn.db.Exec(query, append(args, ID, recipient)...)
+	if err != nil {
+		return "", err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if count == 0 {
+		return "", nil
+	}
+
+	row := dbConn.db.QueryRow("SELECT sender FROM sent_messages WHERE messageID = ? AND recipient = ?", ID, recipient)
+	var sender string
+	err = row.Scan(&sender)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return sender, nil
+}
+
+// insertReceipt inserts a copy of the receiptData into the receipts table for every device of the given userName.
+// if the user has no device yet, a single copy with an empty device is inserted that the first device will take.
+// returns error if something went wrong.
+func (dbConn sqlStore) insertReceipt(userName string, receipt receiptData) error {
+
+	tx, err := dbConn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec("INSERT INTO receipts (userName, device, messageID, recipient, status, at)"+
+		" SELECT userName, deviceID, ?, ?, ?, ? FROM devices WHERE userName = ?",
+		receipt.messageID, receipt.recipient, receipt.status, receipt.at, userName)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	if count == 0 {
+		_, err = tx.Exec("INSERT INTO receipts (userName, device, messageID, recipient, status, at)"+
+			" VALUES (?, '', ?, ?, ?, ?)",
+			userName, receipt.messageID, receipt.recipient, receipt.status, receipt.at)
+		if err != nil {
+			_ = tx.Rollback() //this will return error so we have to handle it
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// getReceipts gets all the receiptData of the given device of the given userName from the receipts table.
+// receipts are in the same order that they have been inserted.
+// returns error if something went wrong.
+func (dbConn sqlStore) getReceipts(userName string, device string) ([]receiptData, error) {
+
+	rows, err := dbConn.db.Query("SELECT messageID, recipient, status, at FROM receipts"+
+		" WHERE userName = ? AND device = ? ORDER BY id", userName, device)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var result []receiptData
+
+	for rows.Next() {
+		var data receiptData
+		err := rows.Scan(&data.messageID, &data.recipient, &data.status, &data.at)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, data)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// deleteReceipt deletes the receiptData of the given device of the given userName from the receipts table.
+// returns error if something went wrong.
+func (dbConn sqlStore) deleteReceipt(userName string, device string, receipt receiptData) error {
+
+	_, err := dbConn.db.Exec("DELETE FROM receipts WHERE userName = ? AND device = ?"+
+		" AND messageID = ? AND recipient = ? AND status = ?",
+		userName, device, receipt.messageID, receipt.recipient, receipt.status)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// getSettings gets the settings of the given userName from the user_settings table.
+// returns the defaultSettings if the user hasn't changed them and error if something went wrong.
+func (dbConn sqlStore) getSettings(userName string) (userSettings, error) {
+
+	row := dbConn.db.QueryRow("SELECT readReceipts, hidePresence, contactsOnly FROM user_settings WHERE userName = ?",
+		userName)
+	var settings userSettings
+	err := row.Scan(&settings.readReceipts, &settings.hidePresence, &settings.contactsOnly)
+	if err == sql.ErrNoRows {
+		return defaultSettings, nil
+	}
+	if err != nil {
+		return userSettings{}, err
+	}
+
+	return settings, nil
+}
+
+// setSettings replaces the settings of the given userName in the user_settings table.
+// returns error if something went wrong.
+func (dbConn sqlStore) setSettings(userName string, settings userSettings) error {
+
+	tx, err := dbConn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM user_settings WHERE userName = ?", userName)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO user_settings (userName, readReceipts, hidePresence, contactsOnly) VALUES (?, ?, ?, ?)",
+		userName, settings.readReceipts, settings.hidePresence, settings.contactsOnly)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// deleteOldSentMessages deletes the rows of the sent_messages table that have been sent before the given time.
+// returns error if something went wrong.
+func (dbConn sqlStore) deleteOldSentMessages(before time.Time) error {
+
+	_, err := dbConn.db.Exec("DELETE FROM sent_messages WHERE sentAt < ?", before)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// setLastSeen replaces the last seen time of the given userName in the last_seen table.
+// the time is only inserted if the user is in tbl_users.
+// returns error if something went wrong.
+func (dbConn sqlStore) setLastSeen(userName string, at time.Time) error {
+
+	tx, err := dbConn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM last_seen WHERE userName = ?", userName)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO last_seen (userName, lastSeen) SELECT userName, ? FROM tbl_users WHERE userName = ?",
+		at.UTC(), userName)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// getLastSeen gets the last seen time of the given userName from the last_seen table.
+// returns nil if it's not stored, and error if something went wrong.
+func (dbConn sqlStore) getLastSeen(userName string) (*time.Time, error) {
+
+	row := dbConn.db.QueryRow("SELECT lastSeen FROM last_seen WHERE userName = ?", userName)
+	var lastSeen time.Time
+	err := row.Scan(&lastSeen)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &lastSeen, nil
+}
+
+// getPeers returns the userNames of the other members of the groups that the given userName is a member of
+// from the group_members table and the users that have it in their contactsList from the user_lists table.
+// the users in the blockedList of the given userName are left out.
+// returns error if something went wrong.
+func (dbConn sqlStore) getPeers(userName string) ([]string, error) {
+
+	return queryStrings(dbConn.db, "SELECT userName FROM"+
+		" (SELECT peers.userName FROM group_members AS members"+
+		" JOIN group_members AS peers ON peers.groupID = members.groupID"+
+		" WHERE members.userName = ? AND peers.userName <> ?"+
+		" UNION SELECT userName FROM user_lists WHERE list = ? AND entry = ?) AS peers"+
+		" WHERE userName NOT IN (SELECT entry FROM user_lists WHERE userName = ? AND list = ?)"+
+		" ORDER BY userName",
+		userName, userName, contactsList, userName, userName, blockedList)
+}
+
+// insertListEntries inserts the entries of the list of the given userName into the user_lists table.
+// the entries that are already existing are ignored.
+// returns error if something went wrong.
+func (dbConn sqlStore) insertListEntries(userName string, list string, entries []string) error {
+
+	tx, err := dbConn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	for _, entry := range entries {
+		_, err = tx.Exec("INSERT INTO user_lists (userName, list, entry, addedAt) VALUES (?, ?, ?, ?)",
+			userName, list, entry, now)
+		if err != nil && !dbConn.dialect.isDuplicate(err) {
+			_ = tx.Rollback() //this will return error so we have to handle it
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// deleteListEntries deletes the entries of the list of the given userName from the user_lists table.
+// returns error if something went wrong.
+func (dbConn sqlStore) deleteListEntries(userName string, list string, entries []string) error {
+
+	tx, err := dbConn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		_, err = tx.Exec("DELETE FROM user_lists WHERE userName = ? AND list = ? AND entry = ?", userName, list, entry)
+		if err != nil {
+			_ = tx.Rollback() //this will return error so we have to handle it
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// getList returns the entries of the list of the given userName from the user_lists table.
+// returns error if something went wrong.
+func (dbConn sqlStore) getList(userName string, list string) ([]string, error) {
+
+	return queryStrings(dbConn.db, "SELECT entry FROM user_lists WHERE userName = ? AND list = ? ORDER BY entry",
+		userName, list)
+}
+
+// checkListEntry checks whether the entry is in the list of the given userName in the user_lists table or not.
+// returns error if something went wrong.
+func (dbConn sqlStore) checkListEntry(userName string, list string, entry string) (bool, error) {
+
+	row := dbConn.db.QueryRow("SELECT COUNT(*) FROM user_lists WHERE userName = ? AND list = ? AND entry = ?",
+		userName, list, entry)
+	var count int
+	err := row.Scan(&count)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// insertAttachment inserts an attachmentData into the attachments table.
+// returns error if something went wrong.
+func (dbConn sqlStore) insertAttachment(attachment attachmentData) error {
+
+	_, err := dbConn.db.Exec("INSERT INTO attachments (attachmentID, owner, mimeType, size, checksum, createdAt)"+
+		" VALUES (?, ?, ?, ?, ?, ?)",
+		attachment.id, attachment.owner, attachment.mimeType, attachment.size, attachment.checksum,
+		attachment.createdAt.UTC())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// getAttachment gets the attachmentData with the given ID from the attachments table.
+// returns nil if there is no such attachment, and error if something went wrong.
+func (dbConn sqlStore) getAttachment(ID string) (*attachmentData, error) {
+
+	row := dbConn.db.QueryRow("SELECT attachmentID, owner, mimeType, size, checksum, createdAt FROM attachments"+
+		" WHERE attachmentID = ?", ID)
+	var attachment attachmentData
+	err := row.Scan(&attachment.id, &attachment.owner, &attachment.mimeType, &attachment.size, &attachment.checksum,
+		&attachment.createdAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &attachment, nil
+}
+
+// linkAttachments inserts the attachments of the message into the message_attachments table
+// and the conversations that can fetch them into the attachment_access table.
+// returns error if something went wrong.
+func (dbConn sqlStore) linkAttachments(messageID string, IDs []string, access []attachmentAccess) error {
+
+	tx, err := dbConn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for i, ID := range IDs {
+		_, err = tx.Exec("INSERT INTO message_attachments (messageID, position, attachmentID) VALUES (?, ?, ?)",
+			messageID, i, ID)
+		if err != nil {
+			_ = tx.Rollback() //this will return error so we have to handle it
+			return err
+		}
+
+		for _, conv := range access {
+			_, err = tx.Exec("INSERT INTO attachment_access (attachmentID, userName, groupID, channel) VALUES (?, ?, ?, ?)",
+				ID, conv.userName, conv.group, conv.channel)
+			if err != nil && !dbConn.dialect.isDuplicate(err) {
+				_ = tx.Rollback() //this will return error so we have to handle it
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// getMessageAttachments gets the attachments of the given messages from the message_attachments
+// and the attachments tables.
+// returns error if something went wrong.
+func (dbConn sqlStore) getMessageAttachments(messageIDs []string) (map[string][]attachmentData, error) {
+
+	result := make(map[string][]attachmentData)
+	if len(messageIDs) == 0 {
+		return result, nil
+	}
+
+	args := make([]interface{}, len(messageIDs))
+	for i, ID := range messageIDs {
+		args[i] = ID
+	}
+
+	rows, err := dbConn.db.Query("SELECT links.messageID, attachments.attachmentID, attachments.owner,"+
+		" attachments.mimeType, attachments.size, attachments.checksum, attachments.createdAt"+
+		" FROM message_attachments AS links JOIN attachments ON attachments.attachmentID = links.attachmentID"+
+		" WHERE links.messageID IN (?"+strings.Repeat(", ?", len(messageIDs)-1)+")"+
+		" ORDER BY links.messageID, links.position", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var messageID string
+		var attachment attachmentData
+		err := rows.Scan(&messageID, &attachment.id, &attachment.owner, &attachment.mimeType, &attachment.size,
+			&attachment.checksum, &attachment.createdAt)
+		if err != nil {
+			return nil, err
+		}
+		result[messageID] = append(result[messageID], attachment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// checkAttachmentAccess checks whether the given userName is the recipient, a member of the group or a member
+// of the channel of a row of the attachment_access table or not.
+// returns error if something went wrong.
+func (dbConn sqlStore) checkAttachmentAccess(ID string, userName string) (bool, error) {
+
+	row := dbConn.db.QueryRow("SELECT COUNT(*) FROM attachment_access WHERE attachmentID = ? AND"+
+		" (userName = ?"+
+		" OR groupID IN (SELECT groupID FROM group_members WHERE userName = ?)"+
+		" OR channel IN (SELECT channel FROM channel_members WHERE userName = ?))",
+		ID, userName, userName, userName)
+	var count int
+	err := row.Scan(&count)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// setIdentityKey replaces the identity key of the device in the identity_keys table
+// and deletes the prekeys of the device from the prekeys table if the key has changed.
+// returns error if something went wrong.
+func (dbConn sqlStore) setIdentityKey(userName string, device string, key []byte) error {
+
+	tx, err := dbConn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	row := tx.QueryRow("SELECT identityKey FROM identity_keys WHERE userName = ? AND device = ?", userName, device)
+	var oldKey []byte
+	err = row.Scan(&oldKey)
+	if err != nil && err != sql.ErrNoRows {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+	if err == nil && bytes.Equal(oldKey, key) {
+		return tx.Commit()
+	}
+
+	_, err = tx.Exec("DELETE FROM prekeys WHERE userName = ? AND device = ?", userName, device)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM identity_keys WHERE userName = ? AND device = ?", userName, device)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO identity_keys (userName, device, identityKey, updatedAt) VALUES (?, ?, ?, ?)",
+		userName, device, key, time.Now().UTC())
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertPrekeys inserts the prekeys of the device into the prekeys table, the existing prekey IDs are ignored.
+// returns error if something went wrong.
+func (dbConn sqlStore) insertPrekeys(userName string, device string, prekeys []prekeyData) error {
+
+	tx, err := dbConn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, prekey := range prekeys {
+		_, err = tx.Exec("INSERT INTO prekeys (userName, device, keyID, publicKey) VALUES (?, ?, ?, ?)",
+			userName, device, prekey.id, prekey.key)
+		if err != nil && !dbConn.dialect.isDuplicate(err) {
+			_ = tx.Rollback() //this will return error so we have to handle it
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// countPrekeys counts the prekeys of the device in the prekeys table.
+// returns error if something went wrong.
+func (dbConn sqlStore) countPrekeys(userName string, device string) (int, error) {
+
+	row := dbConn.db.QueryRow("SELECT COUNT(*) FROM prekeys WHERE userName = ? AND device = ?", userName, device)
+	var count int
+	err := row.Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// claimKeys gets the identity keys of the devices of the given userName from the identity_keys table
+// and deletes the oldest prekey of each device from the prekeys table and returns it with them.
+// returns error if something went wrong.
+func (dbConn sqlStore) claimKeys(userName string) ([]deviceKeys, error) {
+
+	tx, err := dbConn.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query("SELECT device, identityKey FROM identity_keys WHERE userName = ? ORDER BY device", userName)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return nil, err
+	}
+
+	var result []deviceKeys
+	for rows.Next() {
+		var keys deviceKeys
+		err := rows.Scan(&keys.device, &keys.identityKey)
+		if err != nil {
+			_ = rows.Close()
+			_ = tx.Rollback() //this will return error so we have to handle it
+			return nil, err
+		}
+		result = append(result, keys)
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return nil, err
+	}
+
+	for i := range result {
+		row := tx.QueryRow("SELECT keyID, publicKey FROM prekeys WHERE userName = ? AND device = ?"+
+			" ORDER BY keyID LIMIT 1", userName, result[i].device)
+		var prekey prekeyData
+		err = row.Scan(&prekey.id, &prekey.key)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			_ = tx.Rollback() //this will return error so we have to handle it
+			return nil, err
+		}
+
+		deleted, err := tx.Exec("DELETE FROM prekeys WHERE userName = ? AND device = ? AND keyID = ?",
+			userName, result[i].device, prekey.id)
+		if err != nil {
+			_ = tx.Rollback() //this will return error so we have to handle it
+			return nil, err
+		}
+
+		// another claim may have taken it meanwhile, so it's only returned if this one has deleted it.
+		count, err := deleted.RowsAffected()
+		if err != nil {
+			_ = tx.Rollback() //this will return error so we have to handle it
+			return nil, err
+		}
+		if count == 1 {
+			result[i].prekey = &prekey
+		}
+
+		row = tx.QueryRow("SELECT COUNT(*) FROM prekeys WHERE userName = ? AND device = ?", userName, result[i].device)
+		err = row.Scan(&result[i].prekeysLeft)
+		if err != nil {
+			_ = tx.Rollback() //this will return error so we have to handle it
+			return nil, err
+		}
+	}
+
+	return result, tx.Commit()
+}
+
+// insertUser inserts a userData into the tbl_users.
+// returns errDuplicate if the user is already existing and error if something else went wrong.
+func (dbConn sqlStore) insertUser(user userData) error {
+
+	_, err := dbConn.db.Exec("INSERT INTO tbl_users (userName, publicKey, name, ip) VALUES (?, ?, ?, ?)",
+		user.userName, user.publicKey, user.name, user.ip)
+	if err != nil {
+		if dbConn.dialect.isDuplicate(err) {
+			return errDuplicate
+		}
+		return err
+	}
+
+	return nil
+}
+
+// insertGroup inserts a groupData into the chat_groups table and its members into the group_members table.
+// returns error if something went wrong.
+func (dbConn sqlStore) insertGroup(group groupData, members []groupMember) error {
+
+	tx, err := dbConn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO chat_groups (groupID, name, createdAt) VALUES (?, ?, ?)",
+		group.id, group.name, group.createdAt)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	err = insertGroupMembers(tx, group.id, members)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// getGroup gets the ID of a group and returns its groupData.
+// returns nil if there is no such group, and error if something went wrong.
+func (dbConn sqlStore) getGroup(ID string) (*groupData, error) {
+
+	row := dbConn.db.QueryRow("SELECT groupID, name, createdAt FROM chat_groups WHERE groupID = ?", ID)
+	var group groupData
+	err := row.Scan(&group.id, &group.name, &group.createdAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &group, nil
+}
+
+// renameGroup changes the name of the group with the given ID in the chat_groups table.
+// returns error if something went wrong.
+func (dbConn sqlStore) renameGroup(ID string, name string) error {
+
+	_, err := dbConn.db.Exec("UPDATE chat_groups SET name = ? WHERE groupID = ?", name, ID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// getGroupMembers gets all the members of the group with the given ID from the group_members table.
+// members are in the order that they have joined the group.
+// returns error if something went wrong.
+func (dbConn sqlStore) getGroupMembers(ID string) ([]groupMember, error) {
+
+	rows, err := dbConn.db.Query("SELECT userName, role, joinedAt FROM group_members"+
+		" WHERE groupID = ? ORDER BY joinedAt, userName", ID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var result []groupMember
+
+	for rows.Next() {
+		var member groupMember
+		err := rows.Scan(&member.userName, &member.role, &member.joinedAt)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, member)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// insertGroupMembers inserts the members of the group with the given ID into the group_members table.
+// returns error if something went wrong.
+func (dbConn sqlStore) insertGroupMembers(ID string, members []groupMember) error {
+
+	tx, err := dbConn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = insertGroupMembers(tx, ID, members)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertGroupMembers inserts the members of the group with the given ID into the group_members table within the tx.
+// returns error if something went wrong.
+func insertGroupMembers(tx *sql.Tx, ID string, members []groupMember) error {
+
+	for _, member := range members {
+		_, err := tx.Exec("INSERT INTO group_members (groupID, userName, role, joinedAt) VALUES (?, ?, ?, ?)",
+			ID, member.userName, member.role, member.joinedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteGroupMembers deletes the given userNames of the group with the given ID from the group_members table.
+// the group and its history are also deleted if it has no members anymore.
+// returns error if something went wrong.
+func (dbConn sqlStore) deleteGroupMembers(ID string, userNames []string) error {
+
+	tx, err := dbConn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, userName := range userNames {
+		_, err = tx.Exec("DELETE FROM group_members WHERE groupID = ? AND userName = ?", ID, userName)
+		if err != nil {
+			_ = tx.Rollback() //this will return error so we have to handle it
+			return err
+		}
+	}
+
+	_, err = tx.Exec("DELETE FROM chat_groups WHERE groupID = ? AND groupID NOT IN (SELECT groupID FROM group_members)", ID)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM history WHERE groupID = ? AND groupID NOT IN (SELECT groupID FROM group_members)", ID)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM history_settings WHERE groupID = ? AND groupID NOT IN (SELECT groupID FROM group_members)", ID)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// setGroupRole changes the role of the given userName in the group with the given ID in the group_members table.
+// returns error if something went wrong.
+func (dbConn sqlStore) setGroupRole(ID string, userName string, role string) error {
+
+	_, err := dbConn.db.Exec("UPDATE group_members SET role = ? WHERE groupID = ? AND userName = ?", role, ID, userName)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// insertChannel inserts a channel into the channels table and its owner into the channel_members table.
+// returns errDuplicate if the name is already taken and error if something else went wrong.
+func (dbConn sqlStore) insertChannel(name string, owner channelMember) error {
+
+	tx, err := dbConn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO channels (name, createdAt) VALUES (?, ?)", name, time.Now().UTC())
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		if dbConn.dialect.isDuplicate(err) {
+			return errDuplicate
+		}
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO channel_members (channel, userName, role, joinedID) VALUES (?, ?, ?, ?)",
+		name, owner.userName, owner.role, owner.joinedID)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// checkChannel checks whether the channel exists in the channels table or not.
+// returns error if something went wrong.
+func (dbConn sqlStore) checkChannel(name string) (bool, error) {
+
+	row := dbConn.db.QueryRow("SELECT EXISTS(SELECT * FROM channels WHERE name = ?)", name)
+	var result bool
+	err := row.Scan(&result)
+	if err != nil {
+		return false, err
+	}
+
+	return result, nil
+}
+
+// getChannelRole returns the role of the given userName in the channel from the channel_members table.
+// returns an empty string if the user is not subscribed, and error if something went wrong.
+func (dbConn sqlStore) getChannelRole(name string, userName string) (string, error) {
+
+	row := dbConn.db.QueryRow("SELECT role FROM channel_members WHERE channel = ? AND userName = ?", name, userName)
+	var role string
+	err := row.Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return role, nil
+}
+
+// insertChannelMember inserts a channelMember into the channel_members table.
+// returns errDuplicate if the user is already subscribed and error if something else went wrong.
+func (dbConn sqlStore) insertChannelMember(name string, member channelMember) error {
+
+	_, err := dbConn.db.Exec("INSERT INTO channel_members (channel, userName, role, joinedID) VALUES (?, ?, ?, ?)",
+		name, member.userName, member.role, member.joinedID)
+	if err != nil {
+		if dbConn.dialect.isDuplicate(err) {
+			return errDuplicate
+		}
+		return err
+	}
+
+	return nil
+}
+
+// deleteChannelMember deletes the given userName of the channel from the channel_members table
+// and its cursors from the channel_cursors table.
+// returns error if something went wrong.
+func (dbConn sqlStore) deleteChannelMember(name string, userName string) error {
+
+	tx, err := dbConn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM channel_members WHERE channel = ? AND userName = ?", name, userName)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM channel_cursors WHERE channel = ? AND userName = ?", name, userName)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// setChannelRole changes the role of the given userName in the channel in the channel_members table.
+// returns error if something went wrong.
+func (dbConn sqlStore) setChannelRole(name string, userName string, role string) error {
+
+	_, err := dbConn.db.Exec("UPDATE channel_members SET role = ? WHERE channel = ? AND userName = ?", role, name, userName)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// getChannelSubscribers returns the userNames of all the members of the channel from the channel_members table.
+// returns error if something went wrong.
+func (dbConn sqlStore) getChannelSubscribers(name string) ([]string, error) {
+
+	return queryStrings(dbConn.db, "SELECT userName FROM channel_members WHERE channel = ?", name)
+}
+
+// getUserChannels returns the names of the channels that the given userName is subscribed to from the channel_members table.
+// returns error if something went wrong.
+func (dbConn sqlStore) getUserChannels(userName string) ([]string, error) {
+
+	return queryStrings(dbConn.db, "SELECT channel FROM channel_members WHERE userName = ? ORDER BY channel", userName)
+}
+
+// insertPost inserts a messageData into the channel_posts table for the channel.
+// the text is encrypted with the current key of the keyRing and its key ID is kept with it.
+// returns error if something went wrong.
+func (dbConn sqlStore) insertPost(name string, post messageData) error {
+
+	keyID, text, err := dbConn.keys.seal(post.id, post.text)
+	if err != nil {
+		return err
+	}
+
+	_, err = dbConn.db.Exec("INSERT INTO channel_posts (postID, channel, timeStamp, text, sender, keyID)"+
+		" VALUES (?, ?, ?, ?, ?, ?)", post.id, name, post.timeStamp, text, post.sender, keyID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// getMissedPosts gets the last posts of the channel from the channel_posts table that are after both the subscription
+// of the user and the cursor of the device, the user's own posts are not included.
+// posts are in the same order that they have been posted and their texts are decrypted with the keyRing.
+// returns error if something went wrong.
+func (dbConn sqlStore) getMissedPosts(name string, userName string, device string, limit int) ([]messageData, error) {
+
+	rows, err := dbConn.db.Query("SELECT p.postID, p.timeStamp, p.text, p.sender, p.keyID FROM channel_posts p"+
+		" JOIN channel_members m ON m.channel = p.channel AND m.userName = ?"+
+		" LEFT JOIN channel_cursors c ON c.channel = p.channel AND c.userName = m.userName AND c.device = ?"+
+		" WHERE p.channel = ? AND p.sender <> m.userName AND p.postID > m.joinedID"+
+		" AND (c.lastPostID IS NULL OR p.postID > c.lastPostID)"+
+		" ORDER BY p.postID DESC LIMIT ?", userName, device, name, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var result []messageData
+
+	for rows.Next() {
+		data := messageData{channel: name}
+		var keyID string
+		err := rows.Scan(&data.id, &data.timeStamp, &data.text, &data.sender, &keyID)
+		if err != nil {
+			return nil, err
+		}
+		data.text, err = dbConn.keys.open(keyID, data.id, data.text)
+		if err != nil {
+			return nil, err
+		}
+		result = append([]messageData{data}, result...)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// ackPost moves the cursor of the given device of the user in the channel_cursors table to the post with the given ID.
+// the cursor never moves back, and nothing happens if the ID is not a post of a channel that the user is subscribed to.
+// returns error if something went wrong.
+func (dbConn sqlStore) ackPost(userName string, device string, ID string) error {
+
+	channels, err := queryStrings(dbConn.db, "SELECT p.channel FROM channel_posts p"+
+		" JOIN channel_members m ON m.channel = p.channel AND m.userName = ? WHERE p.postID = ?", userName, ID)
+	if err != nil || len(channels) == 0 {
+		return err
+	}
+
+	result, err := dbConn.db.Exec("UPDATE channel_cursors SET lastPostID = ?"+
+		" WHERE channel = ? AND userName = ? AND device = ? AND lastPostID < ?", ID, channels[0], userName, device, ID)
+	if err != nil {
+		return err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil || count > 0 {
+		return err
+	}
+
+	_, err = dbConn.db.Exec("INSERT INTO channel_cursors (channel, userName, device, lastPostID) VALUES (?, ?, ?, ?)",
+		channels[0], userName, device, ID)
+	if err != nil && !dbConn.dialect.isDuplicate(err) {
+		return err
+	}
+
+	return nil
+}
+
+// setKeepHistory inserts the conversation into the history_settings table if enabled is True and deletes it if not.
+// returns error if something went wrong.
+func (dbConn sqlStore) setKeepHistory(conv conversation, enabled bool) error {
+
+	if !enabled {
+		_, err := dbConn.db.Exec("DELETE FROM history_settings WHERE peer1 = ? AND peer2 = ? AND groupID = ?",
+			conv.peer1, conv.peer2, conv.group)
+		return err
+	}
+
+	_, err := dbConn.db.Exec("INSERT INTO history_settings (peer1, peer2, groupID) VALUES (?, ?, ?)",
+		conv.peer1, conv.peer2, conv.group)
+	if err != nil && !dbConn.dialect.isDuplicate(err) {
+		return err
+	}
+
+	return nil
+}
+
+// checkKeepHistory checks whether the conversation exists in the history_settings table or not.
+// returns error if something went wrong.
+func (dbConn sqlStore) checkKeepHistory(conv conversation) (bool, error) {
+
+	row := dbConn.db.QueryRow("SELECT EXISTS(SELECT * FROM history_settings WHERE peer1 = ? AND peer2 = ? AND groupID = ?)",
+		conv.peer1, conv.peer2, conv.group)
+	var result bool
+	err := row.Scan(&result)
+	if err != nil {
+		return false, err
+	}
+
+	return result, nil
+}
+
+// insertHistory inserts a messageData into the history table for the conversation.
+// the timeStamp is kept in UTC so the date ranges of the searches compare it right.
+// returns error if something went wrong.
+func (dbConn sqlStore) insertHistory(conv conversation, message messageData) error {
+
+	_, err := dbConn.db.Exec("INSERT INTO history (peer1, peer2, groupID, messageID, timeStamp, text, sender, storedAt)"+
+		" VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		conv.peer1, conv.peer2, conv.group, message.id, message.timeStamp.UTC(), message.text, message.sender, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// getHistory gets at most limit messages of the conversation from the history table before or after the given IDs.
+// messages are in the same order that they have been sent.
+// returns error if something went wrong.
+func (dbConn sqlStore) getHistory(conv conversation, before string, after string, limit int) ([]messageData, error) {
+
+	query := "SELECT messageID, timeStamp, text, sender, groupID FROM history WHERE peer1 = ? AND peer2 = ? AND groupID = ?"
+	args := []interface{}{conv.peer1, conv.peer2, conv.group}
+	switch {
+	case after != "":
+		query += " AND messageID > ? ORDER BY messageID LIMIT ?"
+		args = append(args, after, limit)
+	case before != "":
+		query += " AND messageID < ? ORDER BY messageID DESC LIMIT ?"
+		args = append(args, before, limit)
+	default:
+		query += " ORDER BY messageID DESC LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := dbConn.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var result []messageData
+
+	for rows.Next() {
+		var data messageData
+		err := rows.Scan(&data.id, &data.timeStamp, &data.text, &data.sender, &data.group)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, data)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if after == "" {
+		for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+			result[i], result[j] = result[j], result[i]
+		}
+	}
+
+	return result, nil
+}
+
+// deleteOldHistory deletes the messages of the history table that have been stored before the given time.
+// returns error if something went wrong.
+func (dbConn sqlStore) deleteOldHistory(before time.Time) error {
+
+	_, err := dbConn.db.Exec("DELETE FROM history WHERE storedAt < ?", before)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// searchHistory searches the history table for the messages of the conversations that the userName belongs to.
+// the direct conversations of the user and the conversations of the groups that the user is a member of are searched.
+// the matches are in the reverse order that they have been sent.
+// returns error if something went wrong.
+func (dbConn sqlStore) searchHistory(userName string, filter searchFilter, limit int) ([]searchMatch, error) {
+
+	query := "SELECT peer1, peer2, groupID, messageID, timeStamp, text, sender FROM history" +
+		" WHERE (peer1 = ? OR peer2 = ? OR groupID IN (SELECT groupID FROM group_members WHERE userName = ?))"
+	args := []interface{}{userName, userName, userName}
+	if len(filter.terms) != 0 {
+		condition, matchArgs := dbConn.dialect.matchText(filter.terms)
+		query += " AND " + condition
+		args = append(args, matchArgs...)
+	}
+	if filter.sender != "" {
+		query += " AND sender = ?"
+		args = append(args, filter.sender)
+	}
+	if !filter.from.IsZero() {
+		query += " AND timeStamp >= ?"
+		args = append(args, filter.from.UTC())
+	}
+	if !filter.to.IsZero() {
+		query += " AND timeStamp < ?"
+		args = append(args, filter.to.UTC())
+	}
+	if filter.before != "" {
+		query += " AND messageID < ?"
+		args = append(args, filter.before)
+	}
+	query += " ORDER BY messageID DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := dbConn.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var result []searchMatch
+
+	for rows.Next() {
+		var match searchMatch
+		err := rows.Scan(&match.conv.peer1, &match.conv.peer2, &match.conv.group,
+			&match.message.id, &match.message.timeStamp, &match.message.text, &match.message.sender)
+		if err != nil {
+			return nil, err
+		}
+		match.message.group = match.conv.group
+		result = append(result, match)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// changeIP changes the ip of the given userName by the given ip.
+// returns error if something went wrong.
+func (dbConn sqlStore) changeIP(userName string, ip string) error {
+
+	_, err := dbConn.db.Exec("UPDATE tbl_users SET ip = ? WHERE userName = ?",
+		ip, userName)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// adminGroupsOf gets the IDs of the groups that the given userName is an admin of from the group_members table.
+// returns error if something went wrong.
+func adminGroupsOf(tx *sql.Tx, userName string) ([]string, error) {
+
+	rows, err := tx.Query("SELECT groupID FROM group_members WHERE userName = ? AND role = ?", userName, adminRole)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var result []string
+
+	for rows.Next() {
+		var groupID string
+		err := rows.Scan(&groupID)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, groupID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// promoteOldestMember makes the oldest member of the group with the given ID its admin if the group has no admin,
+// just like ensureGroupAdmin, but in the given transaction.
+// returns error if something went wrong.
+func promoteOldestMember(tx *sql.Tx, groupID string) error {
+
+	var admins int
+	err := tx.QueryRow("SELECT COUNT(*) FROM group_members WHERE groupID = ? AND role = ?", groupID, adminRole).
+		Scan(&admins)
+	if err != nil || admins > 0 {
+		return err
+	}
+
+	var userName string
+	err = tx.QueryRow("SELECT userName FROM group_members WHERE groupID = ? ORDER BY joinedAt, userName LIMIT 1",
+		groupID).Scan(&userName)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE group_members SET role = ? WHERE groupID = ? AND userName = ?", adminRole, groupID, userName)
+	return err
+}
+
+// deleteUser deletes the user record from tbl_users and every row that belongs to the user in one transaction,
+// that is its queued messages, sessions, devices, settings, lists, keys and the direct conversations history.
+// the user leaves its groups and channels, the oldest member of a group becomes its admin if the user was its only admin,
+// and the groups that are left empty are deleted with their history.
+// if one of the executions got error then the transaction will rollback.
+// returns error if one of the executions went wrong.
+func (dbConn sqlStore) deleteUser(userName string) error {
+
+	tx, err := dbConn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM tbl_users WHERE userName = ?", userName)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM messages WHERE recipient = ?", userName)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM sessions WHERE userName = ?", userName)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM devices WHERE userName = ?", userName)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	adminGroups, err := adminGroupsOf(tx, userName)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM group_members WHERE userName = ?", userName)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	for _, groupID := range adminGroups {
+		err = promoteOldestMember(tx, groupID)
+		if err != nil {
+			_ = tx.Rollback() //this will return error so we have to handle it
+			return err
+		}
+	}
+
+	_, err = tx.Exec("DELETE FROM chat_groups WHERE groupID NOT IN (SELECT groupID FROM group_members)")
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	// the direct conversations have an empty groupID, their history is deleted below by the peers.
+	_, err = tx.Exec("DELETE FROM history WHERE groupID <> '' AND groupID NOT IN (SELECT groupID FROM group_members)")
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM history_settings WHERE groupID <> '' AND groupID NOT IN (SELECT groupID FROM group_members)")
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM channel_members WHERE userName = ?", userName)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM channel_cursors WHERE userName = ?", userName)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM receipts WHERE userName = ? OR recipient = ?", userName, userName)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM user_settings WHERE userName = ?", userName)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM last_seen WHERE userName = ?", userName)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM user_lists WHERE userName = ? OR entry = ?", userName, userName)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM attachment_access WHERE userName = ?", userName)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM identity_keys WHERE userName = ?", userName)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM prekeys WHERE userName = ?", userName)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM sent_messages WHERE sender = ? OR recipient = ?", userName, userName)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM history WHERE peer1 = ? OR peer2 = ?", userName, userName)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM history_settings WHERE peer1 = ? OR peer2 = ?", userName, userName)
+	if err != nil {
+		_ = tx.Rollback() //this will return error so we have to handle it
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertSession inserts a sessionData into the sessions table.
+// returns error if something went wrong.
+func (dbConn sqlStore) insertSession(session sessionData) error {
+
+	_, err := dbConn.db.Exec("INSERT INTO sessions (sessionID, userName, tokenHash, createdAt, expiresAt)"+
+		" VALUES (?, ?, ?, ?, ?)",
+		session.id, session.userName, session.tokenHash, session.createdAt, session.expiresAt)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// getSession gets the hash of a session token and returns its sessionData.
+// returns nil if there is no such session, and error if something went wrong.
+func (dbConn sqlStore) getSession(tokenHash []byte) (*sessionData, error) {
+
+	row := dbConn.db.QueryRow("SELECT sessionID, userName, tokenHash, createdAt, expiresAt"+
+		" FROM sessions WHERE tokenHash = ?", tokenHash)
+	var session sessionData
+	err := row.Scan(&session.id, &session.userName, &session.tokenHash, &session.createdAt, &session.expiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// getSessions returns the sessions of the given userName that are not expired at the given time.
+// returns error if something went wrong.
+func (dbConn sqlStore) getSessions(userName string, now time.Time) ([]sessionData, error) {
+
+	rows, err := dbConn.db.Query("SELECT sessionID, userName, tokenHash, createdAt, expiresAt"+
+		" FROM sessions WHERE userName = ? AND expiresAt > ? ORDER BY createdAt", userName, now)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var result []sessionData
+
+	for rows.Next() {
+		var session sessionData
+		err := rows.Scan(&session.id, &session.userName, &session.tokenHash, &session.createdAt, &session.expiresAt)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// deleteSession deletes the session with the given ID of the given userName from the sessions table.
+// returns error if something went wrong.
+func (dbConn sqlStore) deleteSession(userName string, ID string) error {
+
+	_, err := dbConn.db.Exec("DELETE FROM sessions WHERE userName = ? AND sessionID = ?", userName, ID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// deleteExpiredSessions deletes the sessions that are expired at the given time from the sessions table.
+// returns error if something went wrong.
+func (dbConn sqlStore) deleteExpiredSessions(now time.Time) error {
+
+	_, err := dbConn.db.Exec("DELETE FROM sessions WHERE expiresAt <= ?", now)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ping simply pings the database service provider and returns error if no answer.
+// if we got error then it means that the database is not alive and responding.
+func (dbConn sqlStore) ping() error {
+
+	err := dbConn.db.Ping()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// close closes the database connection pool.
+func (dbConn sqlStore) close() error {
+
+	return dbConn.db.Close()
+}
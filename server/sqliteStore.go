@@ -0,0 +1,375 @@
+package main
+
+import (
+	"database/sql"
+	"github.com/mattn/go-sqlite3"
+	"strings"
+)
+
+// sqliteDialect is the sqlDialect of embedded sqlite databases.
+type sqliteDialect struct{}
+
+// createSQLiteConnection opens the sqlite database file at the given path and returns it via a sqlStore pointer.
+// the file will be created if it's not existing yet and the schema will be created by the migrations.
+// returns error if something went wrong.
+func createSQLiteConnection(path string) (*sqlStore, error) {
+
+	dbConn, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=5000&_foreign_keys=on")
+	if err != nil {
+		return nil, err
+	}
+
+	// sqlite allows only one writer at a time, so we don't let the pool open concurrent connections.
+	dbConn.SetMaxOpenConns(1)
+
+	return &sqlStore{db: dbConn, dialect: sqliteDialect{}}, nil
+}
+
+// isDuplicate checks whether the err is the sqlite unique constraint error.
+func (sqliteDialect) isDuplicate(err error) bool {
+
+	sqliteErr, ok := err.(sqlite3.Error)
+	return ok && (sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique ||
+		sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey)
+}
+
+// quote quotes the given table name with double quotes.
+func (sqliteDialect) quote(table string) string {
+
+	return "\"" + strings.ReplaceAll(table, "\"", "\"\"") + "\""
+}
+
+// listTables returns the sqlite query that lists the tables of the database.
+func (sqliteDialect) listTables() string {
+
+	return "SELECT name FROM sqlite_master WHERE type = 'table'"
+}
+
+// migrations returns the schema migrations of sqlite.
+func (d sqliteDialect) migrations() []migration {
+
+	return []migration{
+		{
+			version: 1,
+			name:    "create users table",
+			up: execStatements("CREATE TABLE IF NOT EXISTS tbl_users" +
+				" (userName VARCHAR(50) NOT NULL PRIMARY KEY," +
+				" ClientID BLOB NOT NULL UNIQUE," +
+				" name VARCHAR(50) NOT NULL," +
+				" ip VARCHAR(45) NOT NULL)"),
+			down: execStatements("DROP TABLE tbl_users"),
+		},
+		{
+			version: 2,
+			name:    "create messages table",
+			up: func(tx *sql.Tx) error {
+				err := execStatements("CREATE TABLE IF NOT EXISTS messages"+
+					" (id INTEGER PRIMARY KEY AUTOINCREMENT,"+
+					" recipient VARCHAR(50) NOT NULL,"+
+					" timeStamp DATETIME NOT NULL,"+
+					" text TEXT NOT NULL,"+
+					" sender VARCHAR(50) NOT NULL)",
+					"CREATE INDEX IF NOT EXISTS idx_messages_recipient ON messages (recipient, id)")(tx)
+				if err != nil {
+					return err
+				}
+
+				return moveLegacyTables(d)(tx)
+			},
+		},
+		{
+			// the bundled sqlite can't drop columns, so this one can't be reverted.
+			version: 3,
+			name:    "add message IDs",
+			up: func(tx *sql.Tx) error {
+				err := execStatements("ALTER TABLE messages" +
+					" ADD COLUMN messageID CHAR(32) NOT NULL DEFAULT ''")(tx)
+				if err != nil {
+					return err
+				}
+
+				err = fillMessageIDs()(tx)
+				if err != nil {
+					return err
+				}
+
+				return execStatements("CREATE UNIQUE INDEX idx_messages_messageID ON messages (recipient, messageID)")(tx)
+			},
+		},
+		{
+			// sqlite can't change a column to nullable, so the table is rebuilt.
+			// the accounts that have enrolled a public key don't have a ClientID anymore, so this one can't be reverted.
+			version: 4,
+			name:    "add users public keys",
+			up: execStatements("CREATE TABLE tbl_users_new"+
+				" (userName VARCHAR(50) NOT NULL PRIMARY KEY,"+
+				" ClientID BLOB NULL UNIQUE,"+
+				" publicKey BLOB NULL UNIQUE,"+
+				" name VARCHAR(50) NOT NULL,"+
+				" ip VARCHAR(45) NOT NULL)",
+				"INSERT INTO tbl_users_new (userName, ClientID, name, ip) SELECT userName, ClientID, name, ip FROM tbl_users",
+				"DROP TABLE tbl_users",
+				"ALTER TABLE tbl_users_new RENAME TO tbl_users"),
+		},
+		{
+			version: 5,
+			name:    "create sessions table",
+			up: execStatements("CREATE TABLE sessions"+
+				" (sessionID CHAR(32) NOT NULL PRIMARY KEY,"+
+				" userName VARCHAR(50) NOT NULL,"+
+				" tokenHash BLOB NOT NULL UNIQUE,"+
+				" createdAt DATETIME NOT NULL,"+
+				" expiresAt DATETIME NOT NULL)",
+				"CREATE INDEX idx_sessions_userName ON sessions (userName)"),
+			down: execStatements("DROP TABLE sessions"),
+		},
+		{
+			// the queued messages are copied for every device after this one, so it can't be reverted.
+			version: 6,
+			name:    "add devices",
+			up: execStatements("CREATE TABLE devices"+
+				" (userName VARCHAR(50) NOT NULL,"+
+				" deviceID VARCHAR(50) NOT NULL,"+
+				" PRIMARY KEY (userName, deviceID))",
+				"ALTER TABLE messages ADD COLUMN device VARCHAR(50) NOT NULL DEFAULT ''",
+				"DROP INDEX idx_messages_messageID",
+				"CREATE UNIQUE INDEX idx_messages_messageID ON messages (recipient, device, messageID)"),
+		},
+		{
+			// the bundled sqlite can't drop columns, so this one can't be reverted.
+			version: 7,
+			name:    "add groups",
+			up: execStatements("CREATE TABLE chat_groups"+
+				" (groupID CHAR(32) NOT NULL PRIMARY KEY,"+
+				" name VARCHAR(50) NOT NULL,"+
+				" createdAt DATETIME NOT NULL)",
+				"CREATE TABLE group_members"+
+					" (groupID CHAR(32) NOT NULL,"+
+					" userName VARCHAR(50) NOT NULL,"+
+					" role VARCHAR(10) NOT NULL,"+
+					" joinedAt DATETIME NOT NULL,"+
+					" PRIMARY KEY (groupID, userName))",
+				"CREATE INDEX idx_group_members_userName ON group_members (userName)",
+				"ALTER TABLE messages ADD COLUMN groupID CHAR(32) NOT NULL DEFAULT ''"),
+		},
+		{
+			version: 8,
+			name:    "add channels",
+			up: execStatements("CREATE TABLE channels"+
+				" (name VARCHAR(50) NOT NULL PRIMARY KEY,"+
+				" createdAt DATETIME NOT NULL)",
+				"CREATE TABLE channel_members"+
+					" (channel VARCHAR(50) NOT NULL,"+
+					" userName VARCHAR(50) NOT NULL,"+
+					" role VARCHAR(10) NOT NULL,"+
+					" joinedID CHAR(32) NOT NULL,"+
+					" PRIMARY KEY (channel, userName))",
+				"CREATE INDEX idx_channel_members_userName ON channel_members (userName)",
+				"CREATE TABLE channel_posts"+
+					" (postID CHAR(32) NOT NULL PRIMARY KEY,"+
+					" channel VARCHAR(50) NOT NULL,"+
+					" timeStamp DATETIME NOT NULL,"+
+					" text TEXT NOT NULL,"+
+					" sender VARCHAR(50) NOT NULL)",
+				"CREATE INDEX idx_channel_posts_channel ON channel_posts (channel, postID)",
+				"CREATE TABLE channel_cursors"+
+					" (channel VARCHAR(50) NOT NULL,"+
+					" userName VARCHAR(50) NOT NULL,"+
+					" device VARCHAR(50) NOT NULL,"+
+					" lastPostID CHAR(32) NOT NULL,"+
+					" PRIMARY KEY (channel, userName, device))"),
+			down: execStatements("DROP TABLE channel_cursors",
+				"DROP TABLE channel_posts",
+				"DROP TABLE channel_members",
+				"DROP TABLE channels"),
+		},
+		{
+			version: 9,
+			name:    "add history",
+			up: execStatements("CREATE TABLE history_settings"+
+				" (peer1 VARCHAR(50) NOT NULL,"+
+				" peer2 VARCHAR(50) NOT NULL,"+
+				" groupID CHAR(32) NOT NULL,"+
+				" PRIMARY KEY (peer1, peer2, groupID))",
+				"CREATE TABLE history"+
+					" (peer1 VARCHAR(50) NOT NULL,"+
+					" peer2 VARCHAR(50) NOT NULL,"+
+					" groupID CHAR(32) NOT NULL,"+
+					" messageID CHAR(32) NOT NULL,"+
+					" timeStamp DATETIME NOT NULL,"+
+					" text TEXT NOT NULL,"+
+					" sender VARCHAR(50) NOT NULL,"+
+					" storedAt DATETIME NOT NULL,"+
+					" PRIMARY KEY (peer1, peer2, groupID, messageID))",
+				"CREATE INDEX idx_history_storedAt ON history (storedAt)"),
+			down: execStatements("DROP TABLE history",
+				"DROP TABLE history_settings"),
+		},
+		{
+			// the history table is made again with an INTEGER PRIMARY KEY,
+			// because the FTS4 index refers to the rows by their rowid and the other rowids may change by a VACUUM.
+			version: 10,
+			name:    "add history search",
+			up: execStatements("ALTER TABLE history RENAME TO old_history",
+				"DROP INDEX idx_history_storedAt",
+				"CREATE TABLE history"+
+					" (id INTEGER PRIMARY KEY AUTOINCREMENT,"+
+					" peer1 VARCHAR(50) NOT NULL,"+
+					" peer2 VARCHAR(50) NOT NULL,"+
+					" groupID CHAR(32) NOT NULL,"+
+					" messageID CHAR(32) NOT NULL,"+
+					" timeStamp DATETIME NOT NULL,"+
+					" text TEXT NOT NULL,"+
+					" sender VARCHAR(50) NOT NULL,"+
+					" storedAt DATETIME NOT NULL,"+
+					" UNIQUE (peer1, peer2, groupID, messageID))",
+				"INSERT INTO history (peer1, peer2, groupID, messageID, timeStamp, text, sender, storedAt)"+
+					" SELECT peer1, peer2, groupID, messageID, timeStamp, text, sender, storedAt FROM old_history ORDER BY messageID",
+				"DROP TABLE old_history",
+				"CREATE INDEX idx_history_storedAt ON history (storedAt)",
+				"CREATE VIRTUAL TABLE history_fts USING fts4(content=\"history\", text)",
+				"INSERT INTO history_fts (history_fts) VALUES ('rebuild')",
+				"CREATE TRIGGER history_fts_insert AFTER INSERT ON history BEGIN"+
+					" INSERT INTO history_fts (docid, text) VALUES (new.id, new.text); END",
+				"CREATE TRIGGER history_fts_delete BEFORE DELETE ON history BEGIN"+
+					" DELETE FROM history_fts WHERE docid = old.id; END",
+				"CREATE TRIGGER history_fts_update_old BEFORE UPDATE ON history BEGIN"+
+					" DELETE FROM history_fts WHERE docid = old.id; END",
+				"CREATE TRIGGER history_fts_update_new AFTER UPDATE ON history BEGIN"+
+					" INSERT INTO history_fts (docid, text) VALUES (new.id, new.text); END"),
+			down: execStatements("DROP TRIGGER history_fts_insert",
+				"DROP TRIGGER history_fts_delete",
+				"DROP TRIGGER history_fts_update_old",
+				"DROP TRIGGER history_fts_update_new",
+				"DROP TABLE history_fts"),
+		},
+		{
+			version: 11,
+			name:    "add sent messages",
+			up: execStatements("CREATE TABLE sent_messages"+
+				" (messageID CHAR(32) NOT NULL,"+
+				" sender VARCHAR(50) NOT NULL,"+
+				" recipient VARCHAR(50) NOT NULL,"+
+				" groupID CHAR(32) NOT NULL,"+
+				" sentAt DATETIME NOT NULL,"+
+				" PRIMARY KEY (messageID, recipient))",
+				"CREATE INDEX idx_sent_messages_sentAt ON sent_messages (sentAt)",
+				"CREATE INDEX idx_messages_byMessageID ON messages (messageID)",
+				"CREATE INDEX idx_history_messageID ON history (messageID)"),
+			down: execStatements("DROP INDEX idx_history_messageID",
+				"DROP INDEX idx_messages_byMessageID",
+				"DROP TABLE sent_messages"),
+		},
+		{
+			// the bundled sqlite can't drop columns, so this one can't be reverted.
+			version: 12,
+			name:    "add receipts and settings",
+			up: execStatements("ALTER TABLE sent_messages ADD COLUMN deliveredAt DATETIME NULL",
+				"ALTER TABLE sent_messages ADD COLUMN readAt DATETIME NULL",
+				"CREATE TABLE receipts"+
+					" (id INTEGER PRIMARY KEY AUTOINCREMENT,"+
+					" userName VARCHAR(50) NOT NULL,"+
+					" device VARCHAR(50) NOT NULL,"+
+					" messageID CHAR(32) NOT NULL,"+
+					" recipient VARCHAR(50) NOT NULL,"+
+					" status VARCHAR(10) NOT NULL,"+
+					" at DATETIME NOT NULL)",
+				"CREATE INDEX idx_receipts_userName ON receipts (userName, device, id)",
+				"CREATE TABLE user_settings"+
+					" (userName VARCHAR(50) NOT NULL PRIMARY KEY,"+
+					" readReceipts BOOLEAN NOT NULL)"),
+		},
+		{
+			// the bundled sqlite can't drop columns, so this one can't be reverted.
+			version: 13,
+			name:    "add presence",
+			up: execStatements("CREATE TABLE last_seen"+
+				" (userName VARCHAR(50) NOT NULL PRIMARY KEY,"+
+				" lastSeen DATETIME NOT NULL)",
+				"ALTER TABLE user_settings ADD COLUMN hidePresence BOOLEAN NOT NULL DEFAULT FALSE"),
+		},
+		{
+			// the bundled sqlite can't drop columns, so this one can't be reverted.
+			version: 14,
+			name:    "add contacts and blocks",
+			up: execStatements("CREATE TABLE user_lists"+
+				" (userName VARCHAR(50) NOT NULL,"+
+				" list VARCHAR(10) NOT NULL,"+
+				" entry VARCHAR(50) NOT NULL,"+
+				" addedAt DATETIME NOT NULL,"+
+				" PRIMARY KEY (userName, list, entry))",
+				"CREATE INDEX idx_user_lists_entry ON user_lists (entry, list)",
+				"ALTER TABLE user_settings ADD COLUMN contactsOnly BOOLEAN NOT NULL DEFAULT FALSE"),
+		},
+		{
+			version: 15,
+			name:    "add attachments",
+			up: execStatements("CREATE TABLE attachments"+
+				" (attachmentID CHAR(32) NOT NULL PRIMARY KEY,"+
+				" owner VARCHAR(50) NOT NULL,"+
+				" mimeType VARCHAR(255) NOT NULL,"+
+				" size BIGINT NOT NULL,"+
+				" checksum CHAR(64) NOT NULL,"+
+				" createdAt DATETIME NOT NULL)",
+				"CREATE TABLE message_attachments"+
+					" (messageID CHAR(32) NOT NULL,"+
+					" position INT NOT NULL,"+
+					" attachmentID CHAR(32) NOT NULL,"+
+					" PRIMARY KEY (messageID, position))",
+				"CREATE TABLE attachment_access"+
+					" (attachmentID CHAR(32) NOT NULL,"+
+					" userName VARCHAR(50) NOT NULL,"+
+					" groupID VARCHAR(32) NOT NULL,"+
+					" channel VARCHAR(50) NOT NULL,"+
+					" PRIMARY KEY (attachmentID, userName, groupID, channel))",
+				"CREATE INDEX idx_attachment_access_userName ON attachment_access (userName)"),
+			down: execStatements("DROP INDEX idx_attachment_access_userName",
+				"DROP TABLE attachment_access",
+				"DROP TABLE message_attachments",
+				"DROP TABLE attachments"),
+		},
+		{
+			// the bundled sqlite can't drop columns, so this one can't be reverted.
+			version: 16,
+			name:    "add end-to-end encryption",
+			up: execStatements("CREATE TABLE identity_keys"+
+				" (userName VARCHAR(50) NOT NULL,"+
+				" device VARCHAR(50) NOT NULL,"+
+				" identityKey BLOB NOT NULL,"+
+				" updatedAt DATETIME NOT NULL,"+
+				" PRIMARY KEY (userName, device))",
+				"CREATE TABLE prekeys"+
+					" (userName VARCHAR(50) NOT NULL,"+
+					" device VARCHAR(50) NOT NULL,"+
+					" keyID INTEGER NOT NULL,"+
+					" publicKey BLOB NOT NULL,"+
+					" PRIMARY KEY (userName, device, keyID))",
+				"ALTER TABLE messages ADD COLUMN payload BLOB NULL",
+				"ALTER TABLE sent_messages ADD COLUMN encrypted BOOLEAN NOT NULL DEFAULT FALSE"),
+		},
+		{
+			// the bundled sqlite can't drop columns, so this one can't be reverted.
+			version: 17,
+			name:    "add message key IDs",
+			up:      execStatements("ALTER TABLE messages ADD COLUMN keyID VARCHAR(32) NOT NULL DEFAULT ''"),
+		},
+		{
+			// the bundled sqlite can't drop columns, so this one can't be reverted.
+			version: 18,
+			name:    "add channel post key IDs",
+			up:      execStatements("ALTER TABLE channel_posts ADD COLUMN keyID VARCHAR(32) NOT NULL DEFAULT ''"),
+		},
+	}
+}
+
+// matchText returns the sqlite FTS4 condition, every term is required and matches as a prefix.
+// FTS5 is not used because the bundled sqlite is not built with it.
+func (sqliteDialect) matchText(terms []string) (string, []interface{}) {
+
+	var match []string
+	for _, term := range terms {
+		match = append(match, term+"*")
+	}
+
+	return "id IN (SELECT docid FROM history_fts WHERE history_fts MATCH ?)", []interface{}{strings.Join(match, " ")}
+}
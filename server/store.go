@@ -0,0 +1,485 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// errDuplicate is the error that stores return when an insert violates a 'UNIQUE' field.
+// every store implementation translates its own driver error to this one so the controller stays driver independent.
+var errDuplicate = errors.New("duplicate entry")
+
+// store is the interface that every storage backend has to implement.
+// controller only talks to the storage through this interface so we can use mysql or any other backend side by side.
+type store interface {
+
+	// checkPublicKey checks whether the public key exists in the storage or not.
+	checkPublicKey(key []byte) (bool, error)
+
+	// getCredentials gets a userName and returns the credentials of that user, it returns nil if the user is not existing.
+	getCredentials(userName string) (*credentials, error)
+
+	// setPublicKey enrolls the public key for the given userName and removes its old ClientID.
+	// it returns errDuplicate if the public key belongs to another user.
+	setPublicKey(userName string, key []byte) error
+
+	// checkClientUserName checks whether the userName exists in the storage or not.
+	checkClientUserName(userName string) (bool, error)
+
+	// insertDevice adds the device to the devices of the given userName, it does nothing if the device is already known.
+	// the first device of the user takes the messages and the receipts that have been kept for it.
+	insertDevice(userName string, device string) error
+
+	// getDevices returns the IDs of the devices of the given userName sorted by the ID.
+	getDevices(userName string) ([]string, error)
+
+	// deleteDevice retires the device of the given userName with its offline messages, receipts, channel cursors and keys.
+	deleteDevice(userName string, device string) error
+
+	// insertMessage inserts a messageData into the offline messages of every device of the given userName.
+	// if the user has no device yet, the message is kept for the first device that connects.
+	insertMessage(userName string, message messageData) error
+
+	// getMessages gets all the offline messageData of the given device of the given userName.
+	getMessages(userName string, device string) ([]messageData, error)
+
+	// deleteMessage deletes the message with the given ID from the offline messages of the given device of the given userName.
+	deleteMessage(userName string, device string, ID string) error
+
+	// insertSentMessage keeps who a message has been sent to, so its sender can edit or unsend it.
+	insertSentMessage(message sentMessage) error
+
+	// getSentMessage returns the sent message with the given ID, it returns nil if there is no such message.
+	getSentMessage(ID string) (*sentMessage, error)
+
+	// editMessage changes the text of the message with the given ID in the offline messages and the history.
+	editMessage(ID string, text string) error
+
+	// unsendMessage deletes the message with the given ID from the offline messages, the history and the sent messages.
+	unsendMessage(ID string) error
+
+	// markDelivered marks the sent message with the given ID as delivered to the recipient at the given time.
+	// it returns the sender of the message if it has been marked now, and an empty string if it had been marked before
+	// or there is no such sent message for the recipient.
+	markDelivered(ID string, recipient string, at time.Time) (string, error)
+
+	// markRead marks the sent message with the given ID as read by the recipient at the given time, just like markDelivered.
+	markRead(ID string, recipient string, at time.Time) (string, error)
+
+	// insertReceipt inserts a receipt into the offline receipts of every device of the given userName.
+	// if the user has no device yet, the receipt is kept for the first device that connects.
+	insertReceipt(userName string, receipt receiptData) error
+
+	// getReceipts gets all the offline receipts of the given device of the given userName in the order that they have been inserted.
+	getReceipts(userName string, device string) ([]receiptData, error)
+
+	// deleteReceipt deletes the receipt from the offline receipts of the given device of the given userName.
+	deleteReceipt(userName string, device string, receipt receiptData) error
+
+	// getSettings returns the settings of the given userName, the users that haven't changed them get the defaultSettings.
+	getSettings(userName string) (userSettings, error)
+
+	// setSettings changes the settings of the given userName.
+	setSettings(userName string, settings userSettings) error
+
+	// deleteOldSentMessages deletes the sent messages that have been sent before the given time,
+	// so they can't be changed and don't get receipts anymore.
+	deleteOldSentMessages(before time.Time) error
+
+	// setLastSeen stores the time that the given userName has been online for the last time.
+	// nothing is stored if the user is not existing, so a deleted user doesn't get it back.
+	setLastSeen(userName string, at time.Time) error
+
+	// getLastSeen returns the time that the given userName has been online for the last time and nil if it's not stored.
+	getLastSeen(userName string) (*time.Time, error)
+
+	// getPeers returns the userNames of the other users that share a group with the given userName or have it
+	// in their contactsList, beside the ones that the given userName has blocked.
+	getPeers(userName string) ([]string, error)
+
+	// insertListEntries adds the entries to the list of the given userName, the existing entries are ignored.
+	insertListEntries(userName string, list string, entries []string) error
+
+	// deleteListEntries removes the entries from the list of the given userName.
+	deleteListEntries(userName string, list string, entries []string) error
+
+	// getList returns the entries of the list of the given userName in sorted order.
+	getList(userName string, list string) ([]string, error)
+
+	// checkListEntry checks whether the entry is in the list of the given userName or not.
+	checkListEntry(userName string, list string, entry string) (bool, error)
+
+	// insertAttachment inserts the metadata of an uploaded attachment.
+	insertAttachment(attachment attachmentData) error
+
+	// getAttachment returns the metadata of the attachment with the given ID and nil if it's not existing.
+	getAttachment(ID string) (*attachmentData, error)
+
+	// linkAttachments attaches the attachments to the message in the given order
+	// and lets the given conversations fetch them, the existing accesses are ignored.
+	linkAttachments(messageID string, IDs []string, access []attachmentAccess) error
+
+	// getMessageAttachments returns the attachments of the given messages in their order
+	// and key of the map is the message ID, the messages with no attachment are not in the map.
+	getMessageAttachments(messageIDs []string) (map[string][]attachmentData, error)
+
+	// checkAttachmentAccess checks whether the given userName is in a conversation that can fetch the attachment or not.
+	checkAttachmentAccess(ID string, userName string) (bool, error)
+
+	// setIdentityKey replaces the identity key of the given device and deletes its prekeys if the key has changed,
+	// because they belong to the old identity.
+	setIdentityKey(userName string, device string, key []byte) error
+
+	// insertPrekeys adds the one-time prekeys of the given device, the existing prekey IDs are ignored.
+	insertPrekeys(userName string, device string, prekeys []prekeyData) error
+
+	// countPrekeys returns the number of the one-time prekeys of the given device that are left.
+	countPrekeys(userName string, device string) (int, error)
+
+	// claimKeys returns the keys of every device of the given userName that has an identity key
+	// and deletes the one-time prekey that is returned for each device, so it's never given out twice.
+	claimKeys(userName string) ([]deviceKeys, error)
+
+	// insertUser inserts a userData.
+	// it returns errDuplicate if the user is already existing.
+	insertUser(user userData) error
+
+	// deleteUser deletes the user record, the devices, the offline messages, the sent messages, the receipts, the settings,
+	// the sessions, the group memberships, the channel subscriptions and the direct conversations history of the user.
+	// the groups that lose their only admin get their oldest member as the new admin, and the groups that are left empty
+	// are deleted with their history.
+	deleteUser(userName string) error
+
+	// insertSession inserts a new session.
+	insertSession(session sessionData) error
+
+	// getSession gets the hash of a session token and returns its session, it returns nil if there is no such session.
+	getSession(tokenHash []byte) (*sessionData, error)
+
+	// getSessions returns the sessions of the given userName that are not expired at the given time.
+	getSessions(userName string, now time.Time) ([]sessionData, error)
+
+	// deleteSession deletes the session with the given ID of the given userName.
+	deleteSession(userName string, ID string) error
+
+	// deleteExpiredSessions deletes the sessions that are expired at the given time.
+	deleteExpiredSessions(now time.Time) error
+
+	// insertGroup inserts a new group with its first members.
+	insertGroup(group groupData, members []groupMember) error
+
+	// getGroup returns the group with the given ID, it returns nil if there is no such group.
+	getGroup(ID string) (*groupData, error)
+
+	// renameGroup changes the name of the group with the given ID.
+	renameGroup(ID string, name string) error
+
+	// getGroupMembers returns the members of the group with the given ID in the order that they have joined.
+	getGroupMembers(ID string) ([]groupMember, error)
+
+	// insertGroupMembers adds the members to the group with the given ID, the members should not be in the group already.
+	insertGroupMembers(ID string, members []groupMember) error
+
+	// deleteGroupMembers removes the given userNames from the group with the given ID.
+	// the group itself is deleted when its last member is removed.
+	deleteGroupMembers(ID string, userNames []string) error
+
+	// setGroupRole changes the role of the given userName in the group with the given ID.
+	setGroupRole(ID string, userName string, role string) error
+
+	// insertChannel inserts a new channel with its owner.
+	// it returns errDuplicate if the name is already taken.
+	insertChannel(name string, owner channelMember) error
+
+	// checkChannel checks whether the channel exists in the storage or not.
+	checkChannel(name string) (bool, error)
+
+	// getChannelRole returns the role of the given userName in the channel, it returns an empty string if the user is not subscribed.
+	getChannelRole(name string, userName string) (string, error)
+
+	// insertChannelMember subscribes a user to the channel.
+	// it returns errDuplicate if the user is already subscribed.
+	insertChannelMember(name string, member channelMember) error
+
+	// deleteChannelMember unsubscribes the given userName from the channel.
+	deleteChannelMember(name string, userName string) error
+
+	// setChannelRole changes the role of the given userName in the channel.
+	setChannelRole(name string, userName string, role string) error
+
+	// getChannelSubscribers returns the userNames of all the members of the channel.
+	getChannelSubscribers(name string) ([]string, error)
+
+	// getUserChannels returns the names of the channels that the given userName is subscribed to.
+	getUserChannels(userName string) ([]string, error)
+
+	// insertPost inserts a post into the channel.
+	insertPost(name string, post messageData) error
+
+	// getMissedPosts returns the last posts of the channel that the given device of the user hasn't acknowledged yet.
+	// only the posts of the others after the subscription are returned, at most limit of them, in the order that they have been posted.
+	getMissedPosts(name string, userName string, device string, limit int) ([]messageData, error)
+
+	// ackPost marks the post with the given ID and the posts before it as seen by the given device of the user.
+	// it does nothing if the ID is not a channel post.
+	ackPost(userName string, device string, ID string) error
+
+	// setKeepHistory turns the history of the conversation on or off.
+	setKeepHistory(conv conversation, enabled bool) error
+
+	// checkKeepHistory checks whether the history of the conversation is turned on or not.
+	checkKeepHistory(conv conversation) (bool, error)
+
+	// insertHistory inserts a message into the history of the conversation.
+	insertHistory(conv conversation, message messageData) error
+
+	// getHistory returns at most limit messages of the conversation in the order that they have been sent.
+	// if before is set, the messages just before that ID are returned, if after is set, the messages just after that ID
+	// are returned, and the last messages are returned if none of them is set.
+	getHistory(conv conversation, before string, after string, limit int) ([]messageData, error)
+
+	// searchHistory returns at most limit messages of the history that match the filter, the newest ones first.
+	// only the direct conversations of the given userName and the conversations of its groups are searched.
+	searchHistory(userName string, filter searchFilter, limit int) ([]searchMatch, error)
+
+	// deleteOldHistory deletes the history messages that have been stored before the given time.
+	deleteOldHistory(before time.Time) error
+
+	// changeIP changes the ip of the given userName by the given ip.
+	changeIP(userName string, ip string) error
+
+	// ping checks whether the storage is alive and responding.
+	ping() error
+
+	// close releases the resources of the storage.
+	close() error
+}
+
+// messageData is the struct that we use to insert messages into database.
+// id is the unique ID that server has given to the message.
+// timeStamp is the time that user has sent the message.
+// text is user's text message.
+// sender is the user's 'userName' that has sent the message.
+// group is the ID of the group that the message has been sent to and it's empty for the direct messages.
+// channel is the name of the channel that the message has been posted to and it's empty for the other messages.
+// attachments are the attachments of the message, the stores don't fill it and loadAttachments should be used for that.
+// payloads are the end-to-end encrypted payloads of the message for the devices of the recipient and key of the map
+// is the device ID, it's nil for the messages that are not encrypted and text is empty for the ones that are.
+// the stores only keep the payloads of the existing devices and only return the payload of the asked device.
+type messageData struct {
+	id          string
+	timeStamp   time.Time
+	text        string
+	sender      string
+	group       string
+	channel     string
+	attachments []attachmentData
+	payloads    map[string][]byte
+}
+
+// sentMessage is the struct that we use to keep who a message has been sent to.
+// id is the unique ID that server has given to the message.
+// sender is the user's 'userName' that has sent the message.
+// group is the ID of the group that the message has been sent to and it's empty for the direct messages.
+// recipients is a slice containing the userNames that the message has been sent to.
+// sentAt is the time that server has got the message.
+// encrypted is True if the message is end-to-end encrypted, so server can't edit it.
+type sentMessage struct {
+	id         string
+	sender     string
+	group      string
+	recipients []string
+	sentAt     time.Time
+	encrypted  bool
+}
+
+// receiptData is the struct that we use to keep the receipts that couldn't be sent to the offline senders.
+// messageID is the ID of the message that the receipt is about.
+// recipient is the userName of the recipient who has got or read the message.
+// status is either deliveredStatus or readStatus.
+// at is the time that the message has been delivered or read.
+type receiptData struct {
+	messageID string
+	recipient string
+	status    string
+	at        time.Time
+}
+
+// userSettings is the struct that we use to keep the settings of a user.
+// readReceipts is the option that lets the senders know when the user reads their messages.
+// hidePresence is the option that hides the online status and the last seen time of the user from the others.
+// contactsOnly is the option that rejects the direct messages of the users that are not in the user's contacts.
+type userSettings struct {
+	readReceipts bool
+	hidePresence bool
+	contactsOnly bool
+}
+
+// defaultSettings is the settings of the users that haven't changed them.
+var defaultSettings = userSettings{readReceipts: true}
+
+// userData is the struct that we use to insert new user's data into database.
+// userName is the unique identifier that we use to detect different users from each other.
+// publicKey is the Ed25519 public key that user proves its identity with.
+// name is the optional name that user can choose for profile.
+// ip is the user's connection ip and it's for tracking user's connection and filtering stuffs.
+type userData struct {
+	userName  string
+	publicKey []byte
+	name      string
+	ip        string
+}
+
+// credentials is the struct that keeps what a user can authenticate with.
+// clientID is the old SHA-1 secret of the accounts that haven't enrolled a public key yet, and it's nil for the others.
+// publicKey is the Ed25519 public key of the user, and it's nil for the accounts that haven't enrolled one yet.
+type credentials struct {
+	clientID  []byte
+	publicKey []byte
+}
+
+// sessionData is the struct that we use to keep sessions in the database.
+// id is the unique ID of the session.
+// userName is the user who owns the session.
+// tokenHash is the SHA-256 hash of the session token, so a leaked database doesn't leak the tokens.
+// createdAt is the time that the session has been issued.
+// expiresAt is the time that the session will be expired after it.
+type sessionData struct {
+	id        string
+	userName  string
+	tokenHash []byte
+	createdAt time.Time
+	expiresAt time.Time
+}
+
+// groupData is the struct that we use to keep groups in the database.
+// id is the unique ID of the group that clients address their group messages to.
+// name is the name of the group.
+// createdAt is the time that the group has been created.
+type groupData struct {
+	id        string
+	name      string
+	createdAt time.Time
+}
+
+// groupMember is the struct that we use to keep the members of a group in the database.
+// userName is the member's userName.
+// role is either adminRole or memberRole.
+// joinedAt is the time that the member has been added to the group.
+type groupMember struct {
+	userName string
+	role     string
+	joinedAt time.Time
+}
+
+// channelMember is the struct that we use to keep the members of a channel in the database.
+// userName is the member's userName.
+// role is either ownerRole, adminRole or subscriberRole.
+// joinedID is an ID that has been made at the subscription time, so the posts with bigger IDs are the ones after it.
+type channelMember struct {
+	userName string
+	role     string
+	joinedID string
+}
+
+// attachmentData is the struct that we use to keep the metadata of an uploaded attachment.
+// id is the unique ID that server has given to the attachment and its blob.
+// owner is the userName of the uploader.
+// mimeType is the MIME type of the attachment.
+// size is the size of the attachment in bytes.
+// checksum is the hex encoded SHA-256 of the attachment.
+// createdAt is the time that the attachment has been uploaded.
+type attachmentData struct {
+	id        string
+	owner     string
+	mimeType  string
+	size      int64
+	checksum  string
+	createdAt time.Time
+}
+
+// attachmentAccess is the struct that we use to keep a conversation that can fetch an attachment.
+// only one of the fields is set.
+// userName is the recipient of a direct message.
+// group is the ID of a group and its current members can fetch the attachment.
+// channel is the name of a channel and its current members can fetch the attachment.
+type attachmentAccess struct {
+	userName string
+	group    string
+	channel  string
+}
+
+// prekeyData is the struct that we use to keep a one-time prekey of a device.
+// id is the ID that the device has given to the prekey, so it can find the private key.
+// key is the public key.
+type prekeyData struct {
+	id  int64
+	key []byte
+}
+
+// deviceKeys is the struct that we use to return the keys of a device from the key directory.
+// device is the device ID.
+// identityKey is the public identity key of the device.
+// prekey is the one-time prekey that has been claimed and it's nil if the device has no prekey left.
+// prekeysLeft is the number of the prekeys of the device that are left after the claim.
+type deviceKeys struct {
+	device      string
+	identityKey []byte
+	prekey      *prekeyData
+	prekeysLeft int
+}
+
+// these are the lists of userNames that users keep on the server.
+// contactsList is the list of the contacts of the user.
+// blockedList is the list of the users that the user doesn't accept messages from.
+const (
+	contactsList = "contacts"
+	blockedList  = "blocked"
+)
+
+// conversation is the struct that we use to identify a conversation in the history.
+// peer1 and peer2 are the userNames of a direct conversation in sorted order and they are empty for the groups.
+// group is the ID of the group and it's empty for the direct conversations.
+// use directConversation and groupConversation to make one.
+type conversation struct {
+	peer1 string
+	peer2 string
+	group string
+}
+
+// directConversation returns the conversation of the two given userNames, no matter which one is the sender.
+func directConversation(userName1 string, userName2 string) conversation {
+
+	if userName2 < userName1 {
+		userName1, userName2 = userName2, userName1
+	}
+
+	return conversation{peer1: userName1, peer2: userName2}
+}
+
+// groupConversation returns the conversation of the group with the given ID.
+func groupConversation(ID string) conversation {
+
+	return conversation{group: ID}
+}
+
+// searchFilter is the struct that we use to search the history.
+// terms are the lower case words that the messages should contain all of them, and no term matches every message.
+// sender is the userName that the messages should be sent by and it's empty for any sender.
+// from and to are the time range that the messages should be sent in, and the zero times leave the range open.
+// before is the message ID that the messages should be sent before it and it's empty for the newest messages.
+type searchFilter struct {
+	terms  []string
+	sender string
+	from   time.Time
+	to     time.Time
+	before string
+}
+
+// searchMatch is the struct that we use to return a message that has matched a search.
+// conv is the conversation that the message has been found in.
+// message is the messageData.
+type searchMatch struct {
+	conv    conversation
+	message messageData
+}
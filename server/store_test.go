@@ -0,0 +1,664 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testStores returns a new memoryStore and a new migrated sqlite store for running the same test on both of them.
+// the stores are closed when the test ends.
+func testStores(t *testing.T) map[string]store {
+
+	t.Helper()
+
+	dbConn, err := createSQLiteConnection(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = dbConn.migrateUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stores := map[string]store{memoryBackend: newMemoryStore(), sqliteBackend: dbConn}
+	t.Cleanup(func() {
+		for _, db := range stores {
+			_ = db.close()
+		}
+	})
+
+	return stores
+}
+
+// insertTestUsers inserts a user with a unique public key for every given userName.
+func insertTestUsers(t *testing.T, db store, userNames ...string) {
+
+	t.Helper()
+
+	for _, userName := range userNames {
+		err := db.insertUser(userData{userName: userName, publicKey: []byte("key-" + userName), name: userName, ip: "127.0.0.1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// messageTexts returns the texts of the given messages in their order.
+func messageTexts(messages []messageData) []string {
+
+	result := []string{}
+	for _, message := range messages {
+		result = append(result, message.text)
+	}
+	return result
+}
+
+// messageIDs returns the IDs of the given messages in their order.
+func messageIDs(messages []messageData) []string {
+
+	result := []string{}
+	for _, message := range messages {
+		result = append(result, message.id)
+	}
+	return result
+}
+
+// equalStrings checks whether the two slices have the same strings in the same order or not.
+func equalStrings(a []string, b []string) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestStoreUsers(t *testing.T) {
+
+	for name, db := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			insertTestUsers(t, db, "alice")
+
+			err := db.insertUser(userData{userName: "alice", publicKey: []byte("other"), name: "a", ip: "127.0.0.1"})
+			if err != errDuplicate {
+				t.Fatalf("inserting a repeated userName: got %v, want errDuplicate", err)
+			}
+			err = db.insertUser(userData{userName: "bob", publicKey: []byte("key-alice"), name: "b", ip: "127.0.0.1"})
+			if err != errDuplicate {
+				t.Fatalf("inserting a repeated public key: got %v, want errDuplicate", err)
+			}
+
+			creds, err := db.getCredentials("alice")
+			if err != nil || creds == nil || string(creds.publicKey) != "key-alice" {
+				t.Fatalf("getCredentials: got %v %v", creds, err)
+			}
+			creds, err = db.getCredentials("ghost")
+			if err != nil || creds != nil {
+				t.Fatalf("getCredentials of an unknown user: got %v %v", creds, err)
+			}
+
+			err = db.deleteUser("alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			exists, err := db.checkClientUserName("alice")
+			if err != nil || exists {
+				t.Fatalf("checkClientUserName after deleteUser: got %v %v", exists, err)
+			}
+		})
+	}
+}
+
+func TestStoreMessages(t *testing.T) {
+
+	for name, db := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			insertTestUsers(t, db, "alice", "bob")
+			err := db.insertDevice("bob", "phone")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			now := time.Now().UTC()
+			first := messageData{id: newID(), timeStamp: now, text: "first", sender: "alice"}
+			second := messageData{id: newID(), timeStamp: now.Add(time.Second), text: "second", sender: "alice"}
+			for _, message := range []messageData{first, second} {
+				err = db.insertMessage("bob", message)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			messages, err := db.getMessages("bob", "phone")
+			if err != nil || !equalStrings(messageTexts(messages), []string{"first", "second"}) {
+				t.Fatalf("getMessages: got %v %v", messageTexts(messages), err)
+			}
+			other, err := db.getMessages("alice", "phone")
+			if err != nil || len(other) != 0 {
+				t.Fatalf("getMessages of another user: got %v %v", messageTexts(other), err)
+			}
+
+			err = db.deleteMessage("bob", "phone", first.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			messages, err = db.getMessages("bob", "phone")
+			if err != nil || len(messages) != 1 || messages[0].id != second.id {
+				t.Fatalf("getMessages after deleteMessage: got %v %v", messageTexts(messages), err)
+			}
+		})
+	}
+}
+
+func TestStoreDeviceQueues(t *testing.T) {
+
+	for name, db := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			insertTestUsers(t, db, "alice", "bob")
+
+			early := messageData{id: newID(), timeStamp: time.Now().UTC(), text: "before devices", sender: "alice"}
+			err := db.insertMessage("bob", early)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = db.insertReceipt("bob", receiptData{messageID: early.id, recipient: "alice", status: readStatus, at: early.timeStamp})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the first device takes the rows that have been queued before it, the later ones don't share them.
+			for _, device := range []string{"phone", "desk", "phone"} {
+				err = db.insertDevice("bob", device)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			late := messageData{id: newID(), timeStamp: time.Now().UTC(), text: "after devices", sender: "alice"}
+			err = db.insertMessage("bob", late)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			phone, err := db.getMessages("bob", "phone")
+			if err != nil || !equalStrings(messageIDs(phone), []string{early.id, late.id}) {
+				t.Fatalf("phone messages: got %v %v", messageIDs(phone), err)
+			}
+			desk, err := db.getMessages("bob", "desk")
+			if err != nil || !equalStrings(messageIDs(desk), []string{late.id}) {
+				t.Fatalf("desk messages: got %v %v", messageIDs(desk), err)
+			}
+			receipts, err := db.getReceipts("bob", "phone")
+			if err != nil || len(receipts) != 1 || receipts[0].messageID != early.id {
+				t.Fatalf("phone receipts: got %v %v", receipts, err)
+			}
+
+			// acknowledging a message on one device leaves the copy of the other device.
+			err = db.deleteMessage("bob", "phone", late.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			desk, err = db.getMessages("bob", "desk")
+			if err != nil || !equalStrings(messageIDs(desk), []string{late.id}) {
+				t.Fatalf("desk messages after the phone ack: got %v %v", messageIDs(desk), err)
+			}
+
+			devices, err := db.getDevices("bob")
+			if err != nil || !equalStrings(devices, []string{"desk", "phone"}) {
+				t.Fatalf("getDevices: got %v %v", devices, err)
+			}
+
+			err = db.deleteDevice("bob", "phone")
+			if err != nil {
+				t.Fatal(err)
+			}
+			devices, err = db.getDevices("bob")
+			if err != nil || !equalStrings(devices, []string{"desk"}) {
+				t.Fatalf("getDevices after deleteDevice: got %v %v", devices, err)
+			}
+			phone, err = db.getMessages("bob", "phone")
+			if err != nil || len(phone) != 0 {
+				t.Fatalf("messages of a retired device: got %v %v", messageIDs(phone), err)
+			}
+			receipts, err = db.getReceipts("bob", "phone")
+			if err != nil || len(receipts) != 0 {
+				t.Fatalf("receipts of a retired device: got %v %v", receipts, err)
+			}
+		})
+	}
+}
+
+func TestStoreGroups(t *testing.T) {
+
+	for name, db := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			insertTestUsers(t, db, "alice", "bob", "carol")
+			now := time.Now().UTC()
+			group := groupData{id: newID(), name: "team", createdAt: now}
+			err := db.insertGroup(group, []groupMember{
+				{userName: "alice", role: adminRole, joinedAt: now},
+				{userName: "bob", role: memberRole, joinedAt: now.Add(time.Second)},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = db.renameGroup(group.id, "crew")
+			if err != nil {
+				t.Fatal(err)
+			}
+			stored, err := db.getGroup(group.id)
+			if err != nil || stored == nil || stored.name != "crew" {
+				t.Fatalf("getGroup after renameGroup: got %v %v", stored, err)
+			}
+
+			err = db.insertGroupMembers(group.id, []groupMember{{userName: "carol", role: memberRole, joinedAt: now.Add(2 * time.Second)}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = db.setGroupRole(group.id, "carol", adminRole)
+			if err != nil {
+				t.Fatal(err)
+			}
+			members, err := db.getGroupMembers(group.id)
+			if err != nil || len(members) != 3 || members[2].userName != "carol" || members[2].role != adminRole {
+				t.Fatalf("getGroupMembers: got %v %v", members, err)
+			}
+
+			// the group itself is deleted with its last member.
+			err = db.deleteGroupMembers(group.id, []string{"alice", "bob", "carol"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			stored, err = db.getGroup(group.id)
+			if err != nil || stored != nil {
+				t.Fatalf("getGroup after removing every member: got %v %v", stored, err)
+			}
+		})
+	}
+}
+
+func TestStoreChannels(t *testing.T) {
+
+	for name, db := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			insertTestUsers(t, db, "alice", "bob")
+			err := db.insertChannel("news", channelMember{userName: "alice", role: ownerRole, joinedID: newID()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = db.insertChannel("news", channelMember{userName: "bob", role: ownerRole, joinedID: newID()})
+			if err != errDuplicate {
+				t.Fatalf("inserting a taken channel name: got %v, want errDuplicate", err)
+			}
+
+			// the posts before the subscription are not missed by the subscriber.
+			before := messageData{id: newID(), timeStamp: time.Now().UTC(), text: "before", sender: "alice", channel: "news"}
+			err = db.insertPost("news", before)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = db.insertChannelMember("news", channelMember{userName: "bob", role: subscriberRole, joinedID: newID()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var posts []messageData
+			for _, text := range []string{"first", "second"} {
+				post := messageData{id: newID(), timeStamp: time.Now().UTC(), text: text, sender: "alice", channel: "news"}
+				err = db.insertPost("news", post)
+				if err != nil {
+					t.Fatal(err)
+				}
+				posts = append(posts, post)
+			}
+
+			missed, err := db.getMissedPosts("news", "bob", "phone", 10)
+			if err != nil || !equalStrings(messageIDs(missed), messageIDs(posts)) {
+				t.Fatalf("getMissedPosts: got %v %v", messageIDs(missed), err)
+			}
+			missed, err = db.getMissedPosts("news", "bob", "phone", 1)
+			if err != nil || !equalStrings(messageIDs(missed), []string{posts[1].id}) {
+				t.Fatalf("getMissedPosts with a limit: got %v %v", messageIDs(missed), err)
+			}
+
+			// every device has its own cursor.
+			err = db.ackPost("bob", "phone", posts[0].id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			missed, err = db.getMissedPosts("news", "bob", "phone", 10)
+			if err != nil || !equalStrings(messageIDs(missed), []string{posts[1].id}) {
+				t.Fatalf("getMissedPosts after ackPost: got %v %v", messageIDs(missed), err)
+			}
+			missed, err = db.getMissedPosts("news", "bob", "desk", 10)
+			if err != nil || !equalStrings(messageIDs(missed), messageIDs(posts)) {
+				t.Fatalf("getMissedPosts of another device: got %v %v", messageIDs(missed), err)
+			}
+		})
+	}
+}
+
+func TestStoreHistory(t *testing.T) {
+
+	for name, db := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			insertTestUsers(t, db, "alice", "bob")
+			conv := directConversation("bob", "alice")
+
+			enabled, err := db.checkKeepHistory(conv)
+			if err != nil || enabled {
+				t.Fatalf("checkKeepHistory before setKeepHistory: got %v %v", enabled, err)
+			}
+			err = db.setKeepHistory(conv, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			enabled, err = db.checkKeepHistory(directConversation("alice", "bob"))
+			if err != nil || !enabled {
+				t.Fatalf("checkKeepHistory after setKeepHistory: got %v %v", enabled, err)
+			}
+
+			var IDs []string
+			for _, text := range []string{"one", "two", "three"} {
+				message := messageData{id: newID(), timeStamp: time.Now().UTC(), text: text, sender: "alice"}
+				err = db.insertHistory(conv, message)
+				if err != nil {
+					t.Fatal(err)
+				}
+				IDs = append(IDs, message.id)
+			}
+
+			tests := []struct {
+				before string
+				after  string
+				want   []string
+			}{
+				{"", "", IDs[1:]},
+				{IDs[2], "", IDs[:2]},
+				{"", IDs[0], IDs[1:]},
+				{IDs[0], "", []string{}},
+			}
+			for _, test := range tests {
+				messages, err := db.getHistory(conv, test.before, test.after, 2)
+				if err != nil || !equalStrings(messageIDs(messages), test.want) {
+					t.Errorf("getHistory before %q after %q: got %v %v, want %v", test.before, test.after, messageIDs(messages), err, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestStoreEncryptedMessages(t *testing.T) {
+
+	for name, db := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			insertTestUsers(t, db, "alice", "bob")
+			for _, device := range []string{"phone", "desk"} {
+				err := db.insertDevice("bob", device)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			message := messageData{id: newID(), timeStamp: time.Now().UTC(), sender: "alice",
+				payloads: map[string][]byte{"phone": []byte("for phone"), "gone": []byte("for gone")}}
+			err := db.insertMessage("bob", message)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			phone, err := db.getMessages("bob", "phone")
+			if err != nil || len(phone) != 1 || string(phone[0].payloads["phone"]) != "for phone" || len(phone[0].payloads) != 1 {
+				t.Fatalf("phone messages: got %v %v", phone, err)
+			}
+			desk, err := db.getMessages("bob", "desk")
+			if err != nil || len(desk) != 0 {
+				t.Fatalf("desk messages: got %v %v", messageIDs(desk), err)
+			}
+		})
+	}
+}
+
+func TestStoreMarks(t *testing.T) {
+
+	for name, db := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			insertTestUsers(t, db, "alice", "bob")
+			now := time.Now().UTC()
+			sent := sentMessage{id: newID(), sender: "alice", recipients: []string{"bob"}, sentAt: now}
+			err := db.insertSentMessage(sent)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sender, err := db.markDelivered(sent.id, "bob", now)
+			if err != nil || sender != "alice" {
+				t.Fatalf("first markDelivered: got %q %v", sender, err)
+			}
+			sender, err = db.markDelivered(sent.id, "bob", now)
+			if err != nil || sender != "" {
+				t.Fatalf("repeated markDelivered: got %q %v", sender, err)
+			}
+			sender, err = db.markRead(sent.id, "carol", now)
+			if err != nil || sender != "" {
+				t.Fatalf("markRead of another recipient: got %q %v", sender, err)
+			}
+
+			// the sent messages that are kept for the receipts still get them after the edit window.
+			err = db.deleteOldSentMessages(now.Add(-time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sender, err = db.markRead(sent.id, "bob", now)
+			if err != nil || sender != "alice" {
+				t.Fatalf("markRead: got %q %v", sender, err)
+			}
+
+			err = db.deleteOldSentMessages(now.Add(time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			message, err := db.getSentMessage(sent.id)
+			if err != nil || message != nil {
+				t.Fatalf("getSentMessage after deleteOldSentMessages: got %v %v", message, err)
+			}
+		})
+	}
+}
+
+func TestStoreDeleteGroupAdmin(t *testing.T) {
+
+	for name, db := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			insertTestUsers(t, db, "alice", "bob", "carol")
+			now := time.Now().UTC()
+			group := groupData{id: newID(), name: "team", createdAt: now}
+			err := db.insertGroup(group, []groupMember{
+				{userName: "alice", role: adminRole, joinedAt: now},
+				{userName: "bob", role: memberRole, joinedAt: now.Add(time.Second)},
+				{userName: "carol", role: memberRole, joinedAt: now.Add(2 * time.Second)},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = db.deleteUser("alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			members, err := db.getGroupMembers(group.id)
+			if err != nil || len(members) != 2 {
+				t.Fatalf("getGroupMembers: got %v %v", members, err)
+			}
+			if members[0].userName != "bob" || members[0].role != adminRole || members[1].role != memberRole {
+				t.Fatalf("the oldest member should be the new admin: got %v", members)
+			}
+		})
+	}
+}
+
+func TestStoreDeleteUserEmptyGroup(t *testing.T) {
+
+	for name, db := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			insertTestUsers(t, db, "alice")
+			now := time.Now().UTC()
+			group := groupData{id: newID(), name: "solo", createdAt: now}
+			err := db.insertGroup(group, []groupMember{{userName: "alice", role: adminRole, joinedAt: now}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			conv := groupConversation(group.id)
+			err = db.setKeepHistory(conv, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = db.insertHistory(conv, messageData{id: newID(), timeStamp: now, text: "hello", sender: "alice", group: group.id})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = db.deleteUser("alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the group is left empty, so it's deleted with its history.
+			found, err := db.getGroup(group.id)
+			if err != nil || found != nil {
+				t.Fatalf("getGroup of an empty group: got %v %v", found, err)
+			}
+			enabled, err := db.checkKeepHistory(conv)
+			if err != nil || enabled {
+				t.Fatalf("checkKeepHistory of a deleted group: got %v %v", enabled, err)
+			}
+			messages, err := db.getHistory(conv, "", "", 10)
+			if err != nil || len(messages) != 0 {
+				t.Fatalf("getHistory of a deleted group: got %v %v", messageIDs(messages), err)
+			}
+		})
+	}
+}
+
+func TestStoreSearchHistory(t *testing.T) {
+
+	for name, db := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			insertTestUsers(t, db, "alice", "bob")
+			conv := directConversation("alice", "bob")
+			first := messageData{id: newID(), timeStamp: time.Now().UTC(), text: "Shipping the release tonight", sender: "alice"}
+			second := messageData{id: newID(), timeStamp: time.Now().UTC(), text: "relief, it shipped", sender: "bob"}
+			for _, message := range []messageData{first, second} {
+				err := db.insertHistory(conv, message)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			tests := []struct {
+				terms []string
+				want  []string
+			}{
+				{[]string{"ship"}, []string{second.id, first.id}},
+				{[]string{"rel", "tonight"}, []string{first.id}},
+				{[]string{"hipping"}, []string{}},
+				{[]string{"ease"}, []string{}},
+			}
+			for _, test := range tests {
+				matches, err := db.searchHistory("bob", searchFilter{terms: test.terms}, 10)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := []string{}
+				for _, match := range matches {
+					got = append(got, match.message.id)
+				}
+				if !equalStrings(got, test.want) {
+					t.Errorf("search %v: got %v, want %v", test.terms, got, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestStoreLastSeen(t *testing.T) {
+
+	for name, db := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			insertTestUsers(t, db, "alice")
+			at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+			for _, userName := range []string{"alice", "ghost"} {
+				err := db.setLastSeen(userName, at)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			lastSeen, err := db.getLastSeen("alice")
+			if err != nil || lastSeen == nil || !lastSeen.Equal(at) {
+				t.Fatalf("getLastSeen: got %v %v, want %v", lastSeen, err, at)
+			}
+			// the users that are not existing, like the deleted ones, don't get a last seen time.
+			lastSeen, err = db.getLastSeen("ghost")
+			if err != nil || lastSeen != nil {
+				t.Fatalf("getLastSeen of an unknown user: got %v %v", lastSeen, err)
+			}
+		})
+	}
+}
+
+func TestStoreSessions(t *testing.T) {
+
+	for name, db := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			insertTestUsers(t, db, "alice")
+			now := time.Now().UTC()
+			live := sessionData{id: newID(), userName: "alice", tokenHash: hashToken("live"), createdAt: now, expiresAt: now.Add(time.Hour)}
+			expired := sessionData{id: newID(), userName: "alice", tokenHash: hashToken("expired"), createdAt: now.Add(-2 * time.Hour), expiresAt: now.Add(-time.Hour)}
+			for _, session := range []sessionData{live, expired} {
+				err := db.insertSession(session)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			session, err := db.getSession(hashToken("live"))
+			if err != nil || session == nil || session.id != live.id || session.userName != "alice" {
+				t.Fatalf("getSession: got %v %v", session, err)
+			}
+			session, err = db.getSession(hashToken("unknown"))
+			if err != nil || session != nil {
+				t.Fatalf("getSession of an unknown token: got %v %v", session, err)
+			}
+
+			sessions, err := db.getSessions("alice", now)
+			if err != nil || len(sessions) != 1 || sessions[0].id != live.id {
+				t.Fatalf("getSessions should only return the live session: got %v %v", sessions, err)
+			}
+
+			err = db.deleteExpiredSessions(now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			session, err = db.getSession(hashToken("expired"))
+			if err != nil || session != nil {
+				t.Fatalf("getSession after deleteExpiredSessions: got %v %v", session, err)
+			}
+
+			err = db.deleteSession("alice", live.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sessions, err = db.getSessions("alice", now)
+			if err != nil || len(sessions) != 0 {
+				t.Fatalf("getSessions after deleteSession: got %v %v", sessions, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"golang.org/x/net/websocket"
+	"time"
+)
+
+// these are the options of the typing frames.
+// typingExpiry is the duration that clients show a typing state for, if no other typing frame comes.
+// typingRate is the number of typing frames that every connection can send per second.
+// typingBurst is the number of typing frames that every connection can send at once.
+const (
+	typingExpiry = 6 * time.Second
+	typingRate   = 1
+	typingBurst  = 5
+)
+
+// rateLimiter is a token bucket that limits how often something can happen.
+// it's not safe for concurrent use, so every connection's runReceiver keeps its own one.
+// tokens is the number of the things that can happen right now.
+// rate is the number of tokens that are added per second.
+// burst is the maximum number of tokens.
+// last is the time that tokens has been updated.
+type rateLimiter struct {
+	tokens float64
+	rate   float64
+	burst  float64
+	last   time.Time
+}
+
+// newRateLimiter inits a full rateLimiter with the given rate per second and burst and returns it as pointer.
+func newRateLimiter(rate float64, burst float64) *rateLimiter {
+
+	return &rateLimiter{tokens: burst, rate: rate, burst: burst}
+}
+
+// allow checks whether something can happen at the given time or not and takes a token if it can.
+// it returns True if it can happen and False if the limit is reached.
+func (limiter *rateLimiter) allow(now time.Time) bool {
+
+	if !limiter.last.IsZero() {
+		limiter.tokens += now.Sub(limiter.last).Seconds() * limiter.rate
+		if limiter.tokens > limiter.burst {
+			limiter.tokens = limiter.burst
+		}
+	}
+	limiter.last = now
+
+	if limiter.tokens < 1 {
+		return false
+	}
+
+	limiter.tokens--
+	return true
+}
+
+// typingHandler is a controller pointer method that handles the typing frames.
+// the typing state is only forwarded to the recipients that are online and accept messages from the sender
+// and it's never stored.
+// nothing is sent back to the sender unless the frame is not valid or the sender is not a member of the group.
+// it gets the frame, the websocket connection, the userName and the device ID of the client.
+func (c *controller) typingHandler(frame envelope, conn *websocket.Conn, userName string, device string) {
+
+	defer c.handlerRecover(userName, device)
+
+	var request typing
+	if !payloadDecoder(frame, &request, conn) {
+		return
+	}
+
+	if (request.To == "") == (request.Group == "") || request.To == userName ||
+		(request.State != typingStart && request.State != typingStop) {
+		frameErrorSender(conn, invalidFrame, frame)
+		return
+	}
+
+	state := typingState{UserName: userName, Group: request.Group, State: request.State}
+	if request.State == typingStart {
+		state.ExpiresIn = int(typingExpiry / time.Second)
+	}
+
+	recipients := []string{request.To}
+	if request.Group != "" {
+		members, role := c.groupMemberRole(request.Group, userName)
+		if role == "" {
+			frameResponder(conn, frame, notMember)
+			return
+		}
+
+		recipients = recipients[:0]
+		for _, member := range members {
+			if member.userName != userName {
+				recipients = append(recipients, member.userName)
+			}
+		}
+	}
+
+	for _, recipient := range recipients {
+		if !c.checkIsClientOnline(recipient) {
+			continue
+		}
+
+		accepted, err := c.acceptsFrom(recipient, userName, request.Group == "")
+		if err != nil {
+			panic(errScope{scope: "typingHandler-acceptsFrom", err: err})
+		}
+		if accepted {
+			c.notifyUser(recipient, typingFrame, "", state)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+
+	limiter := newRateLimiter(1, 3)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if !limiter.allow(now) {
+			t.Fatalf("frame %d of the burst should be allowed", i)
+		}
+	}
+	if limiter.allow(now) {
+		t.Fatal("the frame after the burst should not be allowed")
+	}
+
+	now = now.Add(time.Second)
+	if !limiter.allow(now) {
+		t.Fatal("a token should be added after a second")
+	}
+	if limiter.allow(now) {
+		t.Fatal("only one token should be added after a second")
+	}
+
+	// the tokens never go beyond the burst, no matter how long the limiter has been idle.
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if !limiter.allow(now) {
+			t.Fatalf("frame %d after the idle time should be allowed", i)
+		}
+	}
+	if limiter.allow(now) {
+		t.Fatal("the idle time should not add more tokens than the burst")
+	}
+}